@@ -0,0 +1,24 @@
+// Package market holds what the exporter and its tools share about the
+// Italian electricity market.
+package market
+
+import (
+	"fmt"
+	"time"
+	// the market is in Italian time, also on hosts without a time zone
+	// database
+	_ "time/tzdata"
+)
+
+// Location is the time zone of the Italian electricity market. Market days
+// and intervals are always in Italian time, whatever the host's time zone.
+var Location = mustLoadLocation("Europe/Rome")
+
+// mustLoadLocation loads a time zone, falling back to the embedded database.
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(fmt.Sprintf("cannot load time zone '%s': %v", name, err))
+	}
+	return loc
+}
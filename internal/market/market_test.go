@@ -0,0 +1,25 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocation(t *testing.T) {
+	for _, tc := range []struct {
+		utc  string
+		want string
+	}{
+		{"2024-01-15T12:00:00Z", "2024-01-15 13:00 CET"},
+		{"2024-07-15T12:00:00Z", "2024-07-15 14:00 CEST"},
+		{"2024-10-26T22:30:00Z", "2024-10-27 00:30 CEST"},
+	} {
+		ts, err := time.Parse(time.RFC3339, tc.utc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ts.In(Location).Format("2006-01-02 15:04 MST"); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.utc, got, tc.want)
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
@@ -14,6 +14,8 @@ import (
 	"github.com/maja42/goval"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
 )
 
 var (
@@ -21,9 +23,35 @@ var (
 	flagListen         = flag.String("l", ":9106", "Address to listen to")
 	flagAPIURL         = flag.String("A", "http://localhost:8080", "URL of the PUN API endpoint")
 	flagCompoundMetric = flag.String("C", "", "Custom metric. If empty, no custom metric is exported. A custom metric based on PUN or the monthly average. Example: \"monthly_cost=MPUN/1000+0.08\". You can use PUN (latest PUN) and MPUN (monthly average)")
+	flagProfiles       = flag.String("W", "", "Comma-separated list of punapi load profiles to export the current month's weighted average price for, e.g. arera after uploading ARERA's profile to punapi. If empty, no weighted average is exported")
+	flagBatteryPlan    = flag.String("B", "", "Query parameters of punapi's /plan endpoint to export the battery charge plan for, e.g. \"capacity=10&charge_power=3&discharge_power=3&soc=5&daily_load=10\". If empty, no plan is exported")
+	flagCheapRule      = flag.String("c", "", "Rule of the mercatoelettrico_pun_cheap signal: cheapest:N for the N cheapest hours of the day, percentile:P for the hours up to the P-th percentile, or below:X for the hours priced at most X EUR/MWh. If empty, no signal is exported")
+	flagHysteresis     = flag.Float64("H", 0, "Hysteresis of the cheap signal, in the unit of its rule: once cheap, an hour stays cheap until its rank, percentile or price exceeds the rule's value by this much")
 	flagSleepInterval  = flag.Duration("i", time.Minute, "Interval between speedtest executions, expressed as a Go duration string")
 )
 
+// zonePrice is a price column as returned by punapi's /zones endpoint.
+type zonePrice struct {
+	Name  string  `json:"name"`
+	Kind  string  `json:"kind"`
+	Price float64 `json:"price"`
+}
+
+// weightedAverage is the part of punapi's /weighted response used by the
+// exporter.
+type weightedAverage struct {
+	Profile         string  `json:"profile"`
+	WeightedAverage float64 `json:"weighted_average"`
+}
+
+// batteryPlan is the part of punapi's /plan response used by the exporter.
+type batteryPlan struct {
+	Plan []struct {
+		Time  time.Time `json:"time"`
+		Power float64   `json:"power"`
+	} `json:"plan"`
+}
+
 func splitLabelExpression(labelExpression string) (string, string, error) {
 	parts := strings.SplitN(labelExpression, "=", 2)
 	if len(parts) != 2 {
@@ -32,6 +60,41 @@ func splitLabelExpression(labelExpression string) (string, string, error) {
 	return parts[0], parts[1], nil
 }
 
+// getJSON gets endpoint with the query q, if any, and decodes its JSON
+// response into v.
+func getJSON(endpoint string, q url.Values, v any) error {
+	if len(q) > 0 {
+		endpoint += "?" + q.Encode()
+	}
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("GET failed: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Warning: failed to close HTTP body: %v", err)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-200 HTTP code: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	return nil
+}
+
+// fetchRank returns the rank of the hour of now among the prices of its
+// market day, fetched from punapi at apiURL.
+func fetchRank(apiURL string, now time.Time) (*hourRank, error) {
+	today := now.In(market.Location).Format("2006-01-02")
+	var prices []marketPrice
+	if err := getJSON(apiURL+"/prices", url.Values{"from": {today}, "to": {today}}, &prices); err != nil {
+		return nil, fmt.Errorf("cannot fetch the prices of the day: %w", err)
+	}
+	return rankHour(prices, now)
+}
+
 func main() {
 	flag.Parse()
 
@@ -46,6 +109,19 @@ func main() {
 		log.Fatalf("Scheme or host cannot be empty in API URL")
 	}
 
+	planQuery, err := url.ParseQuery(*flagBatteryPlan)
+	if err != nil {
+		log.Fatalf("Invalid battery plan parameters: %v", err)
+	}
+
+	var cheap *cheapRule
+	if *flagCheapRule != "" {
+		cheap, err = parseCheapRule(*flagCheapRule, *flagHysteresis)
+		if err != nil {
+			log.Fatalf("Invalid cheap rule: %v", err)
+		}
+	}
+
 	var (
 		eval                     *goval.Evaluator
 		custom_name, custom_expr string
@@ -78,6 +154,74 @@ func main() {
 	if err := prometheus.Register(punMonthlyAvgGauge); err != nil {
 		log.Fatalf("Failed to register PUN monthly average gauge: %v", err)
 	}
+	zoneGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mercatoelettrico_zone_price",
+			Help: "Hourly price of every zone and coupled market published by the Italian Mercato Elettrico",
+		},
+		[]string{"zone", "kind"},
+	)
+	if err := prometheus.Register(zoneGauge); err != nil {
+		log.Fatalf("Failed to register zone price gauge: %v", err)
+	}
+	weightedGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mercatoelettrico_pun_weighted_average",
+			Help: "PUN - Current month's average for Prezzo Unico Nazionale weighted by a load profile",
+		},
+		[]string{"profile"},
+	)
+	if err := prometheus.Register(weightedGauge); err != nil {
+		log.Fatalf("Failed to register PUN weighted average gauge: %v", err)
+	}
+	var profiles []string
+	if *flagProfiles != "" {
+		profiles = strings.Split(*flagProfiles, ",")
+	}
+	rankGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mercatoelettrico_pun_rank",
+			Help: "PUN - Rank of the current hour's price within the market day, 1 being the cheapest",
+		},
+		[]string{},
+	)
+	if err := prometheus.Register(rankGauge); err != nil {
+		log.Fatalf("Failed to register PUN rank gauge: %v", err)
+	}
+	percentileGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mercatoelettrico_pun_percentile",
+			Help: "PUN - Percentile of the current hour's price within the market day, 0 being the cheapest and 100 the most expensive",
+		},
+		[]string{},
+	)
+	if err := prometheus.Register(percentileGauge); err != nil {
+		log.Fatalf("Failed to register PUN percentile gauge: %v", err)
+	}
+	cheapGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mercatoelettrico_pun_cheap",
+			Help: "PUN - 1 if the current hour is cheap according to the rule " + *flagCheapRule + ", 0 otherwise",
+		},
+		[]string{},
+	)
+	if cheap != nil {
+		if err := prometheus.Register(cheapGauge); err != nil {
+			log.Fatalf("Failed to register PUN cheap gauge: %v", err)
+		}
+	}
+	planGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mercatoelettrico_planned_battery_power",
+			Help: "Planned battery power in kW, positive when charging and negative when discharging. hour is the offset in hours from the current hour, 0 being the current one",
+		},
+		[]string{"hour"},
+	)
+	if *flagBatteryPlan != "" {
+		if err := prometheus.Register(planGauge); err != nil {
+			log.Fatalf("Failed to register battery plan gauge: %v", err)
+		}
+	}
 	var punCustomGauge *prometheus.GaugeVec
 	if eval != nil {
 		log.Printf("Creating custom gauge `%s` with formula `%s`", custom_name, custom_expr)
@@ -93,21 +237,6 @@ func main() {
 		}
 	}
 
-	getPun := func(endpoint string) (float64, error) {
-		resp, err := http.Get(endpoint)
-		if err != nil {
-			return 0, fmt.Errorf("GET failed: %w", err)
-		}
-		data, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return 0, fmt.Errorf("HTTP body read failed: %w", err)
-		}
-		if err := resp.Body.Close(); err != nil {
-			log.Printf("Warning: failed to close HTTP body: %v", err)
-		}
-		return strconv.ParseFloat(string(data), 64)
-	}
-
 	go func() {
 		firstrun := true
 		for {
@@ -118,20 +247,76 @@ func main() {
 			firstrun = false
 			// export PUN
 			log.Printf("Fetching PUN value...")
-			pun, err := getPun(*flagAPIURL)
-			if err != nil {
+			var pun float64
+			if err := getJSON(*flagAPIURL, nil, &pun); err != nil {
 				log.Printf("Failed to fetch PUN value: %v", err)
 			} else {
 				punGauge.WithLabelValues().Set(pun)
 			}
 			// export monthly PUN average
 			log.Printf("Fetching PUN monthly average value...")
-			punavg, err := getPun(*flagAPIURL + "/month")
-			if err != nil {
+			var punavg float64
+			if err := getJSON(*flagAPIURL+"/month", nil, &punavg); err != nil {
 				log.Printf("Failed to fetch PUN monthly average value: %v", err)
 			} else {
 				punMonthlyAvgGauge.WithLabelValues().Set(punavg)
 			}
+			// export the price of every zone, including the ones that
+			// punapi does not know about
+			log.Printf("Fetching zone prices...")
+			var zones []zonePrice
+			if err := getJSON(*flagAPIURL+"/zones", nil, &zones); err != nil {
+				log.Printf("Failed to fetch zone prices: %v", err)
+			} else {
+				// drop zones that are no longer published
+				zoneGauge.Reset()
+				for _, z := range zones {
+					zoneGauge.WithLabelValues(z.Name, z.Kind).Set(z.Price)
+				}
+			}
+			// export the rank of the current hour within the day's prices
+			log.Printf("Fetching PUN prices of the day...")
+			if rank, err := fetchRank(*flagAPIURL, time.Now()); err != nil {
+				log.Printf("Failed to rank the current PUN price: %v", err)
+				// do not keep exporting the rank of an earlier hour
+				rankGauge.Reset()
+				percentileGauge.Reset()
+				cheapGauge.Reset()
+			} else {
+				rankGauge.WithLabelValues().Set(float64(rank.Rank))
+				percentileGauge.WithLabelValues().Set(rank.Percentile)
+				if cheap != nil {
+					var v float64
+					if cheap.Update(rank) {
+						v = 1
+					}
+					cheapGauge.WithLabelValues().Set(v)
+				}
+			}
+			// export the monthly average weighted by every load profile
+			for _, profile := range profiles {
+				log.Printf("Fetching PUN weighted average for profile `%s`...", profile)
+				var avg weightedAverage
+				if err := getJSON(*flagAPIURL+"/weighted", url.Values{"profile": {profile}}, &avg); err != nil {
+					log.Printf("Failed to fetch PUN weighted average for profile `%s`: %v", profile, err)
+				} else {
+					weightedGauge.WithLabelValues(avg.Profile).Set(avg.WeightedAverage)
+				}
+			}
+			if *flagBatteryPlan != "" {
+				log.Printf("Fetching battery plan...")
+				var plan batteryPlan
+				if err := getJSON(*flagAPIURL+"/plan", planQuery, &plan); err != nil {
+					log.Printf("Failed to fetch battery plan: %v", err)
+				} else {
+					// the plan starts at the current hour, and its length
+					// depends on the published prices
+					planGauge.Reset()
+					for idx, step := range plan.Plan {
+						planGauge.WithLabelValues(strconv.Itoa(idx)).Set(step.Power)
+					}
+				}
+			}
 			if eval != nil {
 				// export custom metric
 				log.Printf("Computing custom metric `%s`", custom_name)
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGetJSON(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		switch r.URL.Path {
+		case "/":
+			// punapi's plain price is a JSON number too
+			_, _ = w.Write([]byte("123.456000"))
+		case "/zones":
+			_, _ = w.Write([]byte(`[{"name":"NORD","kind":"national","price":100}]`))
+		case "/broken":
+			_, _ = w.Write([]byte("Fetch failed"))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	var pun float64
+	if err := getJSON(srv.URL+"/", nil, &pun); err != nil || pun != 123.456 {
+		t.Errorf("got %v and %v, want 123.456", pun, err)
+	}
+	if len(query) != 0 {
+		t.Errorf("got the query %v, want none", query)
+	}
+	var zones []zonePrice
+	if err := getJSON(srv.URL+"/zones", url.Values{"day": {"2024-01-15"}}, &zones); err != nil || len(zones) != 1 {
+		t.Errorf("got %+v and %v, want one zone", zones, err)
+	}
+	if query.Get("day") != "2024-01-15" {
+		t.Errorf("got the query %v, want day=2024-01-15", query)
+	}
+	for _, path := range []string{"/broken", "/failing"} {
+		if err := getJSON(srv.URL+path, nil, &pun); err == nil {
+			t.Errorf("%s: want an error", path)
+		}
+	}
+}
+
+func TestFetchRank(t *testing.T) {
+	var from, to string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prices" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		from, to = r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		_, _ = w.Write([]byte(`[{"time":"2024-01-15T23:00:00Z","minutes":60,"price":100},
+			{"time":"2024-01-16T00:00:00Z","minutes":60,"price":50}]`))
+	}))
+	defer srv.Close()
+
+	// 00:30 in Italy, still the 15th in UTC
+	r, err := fetchRank(srv.URL, time.Date(2024, time.January, 15, 23, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("fetchRank failed: %v", err)
+	}
+	if from != "2024-01-16" || to != "2024-01-16" {
+		t.Errorf("got from %s and to %s, want 2024-01-16", from, to)
+	}
+	if r.Rank != 2 {
+		t.Errorf("got rank %d, want 2", r.Rank)
+	}
+	if _, err := fetchRank(srv.URL+"/missing", time.Now()); err == nil {
+		t.Error("want an error for a failed request")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// marketPrice is a price as returned by punapi's /prices endpoint.
+type marketPrice struct {
+	Time    time.Time `json:"time"`
+	Minutes int       `json:"minutes"`
+	Price   float64   `json:"price"`
+}
+
+// hourRank is the position of an hour's price within its market day.
+type hourRank struct {
+	Price float64
+	// Rank is 1 for the cheapest hour of the day. Hours with the same price
+	// have the same rank.
+	Rank int
+	// Percentile is 0 for the cheapest hour and 100 for the most expensive
+	// one
+	Percentile float64
+}
+
+// rankHour returns the rank of the hour containing t among the hours of the
+// day's prices. Quarter-hourly prices are averaged over every hour.
+func rankHour(prices []marketPrice, t time.Time) (*hourRank, error) {
+	hourly := make(map[int64]float64)
+	minutes := make(map[int64]int)
+	for _, p := range prices {
+		h := p.Time.Truncate(time.Hour).Unix()
+		hourly[h] += p.Price * float64(p.Minutes)
+		minutes[h] += p.Minutes
+	}
+	current := t.Truncate(time.Hour).Unix()
+	if _, ok := hourly[current]; !ok {
+		return nil, fmt.Errorf("no price for the hour of %s", t.Format("2006-01-02 15:04"))
+	}
+	values := make([]float64, 0, len(hourly))
+	for h := range hourly {
+		hourly[h] /= float64(minutes[h])
+		values = append(values, hourly[h])
+	}
+	sort.Float64s(values)
+	price := hourly[current]
+	cheaper := sort.SearchFloat64s(values, price)
+	r := hourRank{Price: price, Rank: cheaper + 1}
+	if len(values) > 1 {
+		r.Percentile = float64(cheaper) / float64(len(values)-1) * 100
+	}
+	return &r, nil
+}
+
+// cheapRule decides whether the current hour is cheap. An hour becomes cheap
+// when its rank, percentile or price, depending on the kind, is at most
+// threshold, and stops being cheap when it is more than threshold plus
+// hysteresis, so that the signal does not flap around the threshold.
+type cheapRule struct {
+	kind       string
+	threshold  float64
+	hysteresis float64
+	cheap      bool
+}
+
+// Cheap rule kinds.
+const (
+	ruleCheapest   = "cheapest"
+	rulePercentile = "percentile"
+	ruleBelow      = "below"
+)
+
+// parseCheapRule parses a rule as cheapest:N (the N cheapest hours of the
+// day), percentile:P (the hours up to the P-th percentile) or below:X (the
+// hours priced at most X EUR/MWh).
+func parseCheapRule(s string, hysteresis float64) (*cheapRule, error) {
+	kind, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("expected kind:value, got '%s'", s)
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value '%s': %w", value, err)
+	}
+	switch kind {
+	case ruleCheapest:
+		if threshold < 1 {
+			return nil, fmt.Errorf("the number of cheapest hours must be at least 1")
+		}
+	case rulePercentile:
+		if threshold < 0 || threshold > 100 {
+			return nil, fmt.Errorf("the percentile must be between 0 and 100")
+		}
+	case ruleBelow:
+	default:
+		return nil, fmt.Errorf("unknown rule '%s', want %s, %s or %s", kind, ruleCheapest, rulePercentile, ruleBelow)
+	}
+	if hysteresis < 0 {
+		return nil, fmt.Errorf("hysteresis cannot be negative")
+	}
+	return &cheapRule{kind: kind, threshold: threshold, hysteresis: hysteresis}, nil
+}
+
+// Update returns whether the hour ranked r is cheap, given the previous
+// state.
+func (c *cheapRule) Update(r *hourRank) bool {
+	var v float64
+	switch c.kind {
+	case ruleCheapest:
+		v = float64(r.Rank)
+	case rulePercentile:
+		v = r.Percentile
+	case ruleBelow:
+		v = r.Price
+	}
+	limit := c.threshold
+	if c.cheap {
+		limit += c.hysteresis
+	}
+	c.cheap = v <= limit
+	return c.cheap
+}
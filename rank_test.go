@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// dayPrices returns hourly prices from the market midnight of day.
+func dayPrices(day string, values ...float64) []marketPrice {
+	start, err := time.ParseInLocation("2006-01-02", day, market.Location)
+	if err != nil {
+		panic(err)
+	}
+	prices := make([]marketPrice, len(values))
+	for idx, v := range values {
+		prices[idx] = marketPrice{Time: start.Add(time.Duration(idx) * time.Hour), Minutes: 60, Price: v}
+	}
+	return prices
+}
+
+func TestRankHour(t *testing.T) {
+	prices := dayPrices("2024-01-15", 10, 20, 20, 30)
+	for _, tc := range []struct {
+		hour       int
+		rank       int
+		percentile float64
+	}{
+		{0, 1, 0},
+		// ties share the rank of the first of them
+		{1, 2, 100.0 / 3},
+		{2, 2, 100.0 / 3},
+		{3, 4, 100},
+	} {
+		r, err := rankHour(prices, prices[tc.hour].Time.Add(30*time.Minute))
+		if err != nil {
+			t.Fatalf("hour %d: rankHour failed: %v", tc.hour, err)
+		}
+		if r.Rank != tc.rank || math.Abs(r.Percentile-tc.percentile) > 1e-9 {
+			t.Errorf("hour %d: got rank %d and percentile %v, want %d and %v", tc.hour, r.Rank, r.Percentile, tc.rank, tc.percentile)
+		}
+	}
+	if _, err := rankHour(prices, prices[3].Time.Add(time.Hour)); err == nil {
+		t.Error("want an error for an hour without a price")
+	}
+}
+
+func TestRankHourDST(t *testing.T) {
+	// the 25 hour day: the second 02:00 is the most expensive hour
+	values := make([]float64, 25)
+	for idx := range values {
+		values[idx] = float64(idx)
+	}
+	values[3] = 100
+	prices := dayPrices("2024-10-27", values...)
+	r, err := rankHour(prices, time.Date(2024, time.October, 27, 1, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("rankHour failed: %v", err)
+	}
+	if r.Rank != 25 || r.Percentile != 100 || r.Price != 100 {
+		t.Errorf("got %+v, want rank 25 of 25", r)
+	}
+}
+
+func TestRankHourQuarterHours(t *testing.T) {
+	start := time.Date(2025, time.October, 1, 0, 0, 0, 0, market.Location)
+	var prices []marketPrice
+	// the first hour averages 25, the second one 20
+	for idx, v := range []float64{10, 20, 30, 40, 20, 20, 20, 20} {
+		prices = append(prices, marketPrice{Time: start.Add(time.Duration(idx) * 15 * time.Minute), Minutes: 15, Price: v})
+	}
+	r, err := rankHour(prices, start.Add(10*time.Minute))
+	if err != nil {
+		t.Fatalf("rankHour failed: %v", err)
+	}
+	if r.Rank != 2 || r.Price != 25 {
+		t.Errorf("got %+v, want rank 2 at 25", r)
+	}
+}
+
+func TestCheapRuleHysteresis(t *testing.T) {
+	for _, tc := range []struct {
+		rule       string
+		hysteresis float64
+		ranks      []hourRank
+		want       []bool
+	}{
+		{
+			rule:       "cheapest:2",
+			hysteresis: 1,
+			ranks:      []hourRank{{Rank: 3}, {Rank: 2}, {Rank: 3}, {Rank: 4}, {Rank: 3}, {Rank: 1}},
+			want:       []bool{false, true, true, false, false, true},
+		},
+		{
+			rule:  "cheapest:2",
+			ranks: []hourRank{{Rank: 2}, {Rank: 3}, {Rank: 2}},
+			want:  []bool{true, false, true},
+		},
+		{
+			rule:       "percentile:25",
+			hysteresis: 10,
+			ranks:      []hourRank{{Percentile: 30}, {Percentile: 25}, {Percentile: 35}, {Percentile: 35.1}},
+			want:       []bool{false, true, true, false},
+		},
+		{
+			rule:       "below:90",
+			hysteresis: 5,
+			ranks:      []hourRank{{Price: 91}, {Price: 89}, {Price: 94}, {Price: 96}},
+			want:       []bool{false, true, true, false},
+		},
+	} {
+		t.Run(tc.rule, func(t *testing.T) {
+			c, err := parseCheapRule(tc.rule, tc.hysteresis)
+			if err != nil {
+				t.Fatalf("parseCheapRule failed: %v", err)
+			}
+			for idx := range tc.ranks {
+				if got := c.Update(&tc.ranks[idx]); got != tc.want[idx] {
+					t.Errorf("hour #%d %+v: got %v, want %v", idx, tc.ranks[idx], got, tc.want[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestParseCheapRuleErrors(t *testing.T) {
+	for _, rule := range []string{"cheapest", "cheapest:0", "cheapest:x", "percentile:101", "percentile:-1", "above:10"} {
+		if _, err := parseCheapRule(rule, 0); err == nil {
+			t.Errorf("%s: want an error", rule)
+		}
+	}
+	if _, err := parseCheapRule("below:90", -1); err == nil {
+		t.Error("want an error for a negative hysteresis")
+	}
+}
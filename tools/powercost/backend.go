@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Backend types.
+const (
+	BackendPrometheus       = "prometheus"
+	BackendVictoriaMetrics  = "victoriametrics"
+	BackendInfluxDBFlux     = "influxdb-flux"
+	BackendInfluxDBInfluxQL = "influxdb-influxql"
+	BackendCSV              = "csv"
+)
+
+// lookback is how far back QueryAt looks for the last sample in backends
+// without their own notion of staleness, like Prometheus' default.
+const lookback = 5 * time.Minute
+
+// checkStep returns an error if step is not a valid QueryRange step.
+func checkStep(step time.Duration) error {
+	if step < time.Millisecond {
+		return fmt.Errorf("invalid step %s, want at least 1ms", step)
+	}
+	return nil
+}
+
+// Sample is a value of a time series.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Backend is a time-series database the energy data is queried from. The
+// query language depends on the backend. Queries must return a single series:
+// QueryRange fails on more than one, QueryAt uses the first one.
+type Backend interface {
+	// QueryAt returns the value of the query at t.
+	QueryAt(q string, t time.Time) (float64, error)
+	// QueryRange returns the values of the query from start to end, every
+	// step, in chronological order.
+	QueryRange(q string, start, end time.Time, step time.Duration) ([]Sample, error)
+	// Increase returns the increase from start to end of the energy counter
+	// returned by the query, taking counter resets into account.
+	Increase(q string, start, end time.Time) (float64, error)
+}
+
+// BackendConfig selects and configures the backend.
+type BackendConfig struct {
+	// Type is one of prometheus (default), victoriametrics,
+	// influxdb-flux, influxdb-influxql and csv
+	Type string `json:"type"`
+	// URL is the base URL of the server, e.g. http://localhost:8086. For
+	// prometheus it defaults to the prometheus_* keys.
+	URL string `json:"url,omitempty"`
+	// Org is the InfluxDB organization, for Flux queries
+	Org string `json:"org,omitempty"`
+	// Database is the InfluxDB database, for InfluxQL queries
+	Database string `json:"database,omitempty"`
+	// Token and TokenFile are the InfluxDB API token. The file is read on
+	// every request.
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+	// Path is the CSV file
+	Path string `json:"path,omitempty"`
+}
+
+// newBackend returns the backend configured in cfg, sending HTTP requests
+// with client.
+func newBackend(cfg *Config, client *http.Client) (Backend, error) {
+	bc := &cfg.Backend
+	if (bc.Token != "" || bc.TokenFile != "") && (bc.Type != BackendInfluxDBFlux && bc.Type != BackendInfluxDBInfluxQL) {
+		return nil, fmt.Errorf("token and token_file are only used by the InfluxDB backends")
+	}
+	switch bc.Type {
+	case "", BackendPrometheus:
+		if bc.URL != "" {
+			u, err := url.Parse(bc.URL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid URL '%s': %w", bc.URL, err)
+			}
+			return NewPrometheusBackend(u.JoinPath("api/v1/query").String(), client, &cfg.PrometheusHTTP)
+		}
+		u := url.URL{
+			Scheme: cfg.PrometheusScheme,
+			Host:   cfg.PrometheusHostPort,
+			Path:   cfg.PrometheusQueryPath,
+		}
+		return NewPrometheusBackend(u.String(), client, &cfg.PrometheusHTTP)
+	case BackendVictoriaMetrics:
+		if bc.URL == "" {
+			return nil, fmt.Errorf("the %s backend requires a url", bc.Type)
+		}
+		return NewVictoriaMetricsBackend(bc.URL, client, &cfg.PrometheusHTTP)
+	case BackendInfluxDBFlux, BackendInfluxDBInfluxQL:
+		if bc.URL == "" {
+			return nil, fmt.Errorf("the %s backend requires a url", bc.Type)
+		}
+		if bc.Token != "" && bc.TokenFile != "" {
+			return nil, fmt.Errorf("only one of token and token_file can be set")
+		}
+		if bc.Token != "" || bc.TokenFile != "" {
+			h := &cfg.PrometheusHTTP
+			if h.BasicAuth != nil || h.BearerToken != "" || h.BearerTokenFile != "" {
+				return nil, fmt.Errorf("the InfluxDB token cannot be used with basic_auth or a bearer token")
+			}
+		}
+		if bc.Type == BackendInfluxDBFlux {
+			if bc.Org == "" {
+				return nil, fmt.Errorf("the %s backend requires an org", bc.Type)
+			}
+			return NewFluxBackend(bc.URL, bc.Org, bc.Token, bc.TokenFile, client, &cfg.PrometheusHTTP)
+		}
+		if bc.Database == "" {
+			return nil, fmt.Errorf("the %s backend requires a database", bc.Type)
+		}
+		return NewInfluxQLBackend(bc.URL, bc.Database, bc.Token, bc.TokenFile, client, &cfg.PrometheusHTTP)
+	case BackendCSV:
+		if bc.Path == "" {
+			return nil, fmt.Errorf("the %s backend requires a path", bc.Type)
+		}
+		return NewCSVBackend(bc.Path), nil
+	}
+	return nil, fmt.Errorf("unknown backend type '%s'", bc.Type)
+}
+
+// lastSample returns the value of the last sample of the query in the
+// lookback window before t.
+func lastSample(b Backend, q string, t time.Time) (float64, error) {
+	samples, err := b.QueryRange(q, t.Add(-lookback), t, time.Minute)
+	if err != nil {
+		return 0, err
+	}
+	if len(samples) == 0 {
+		return 0, fmt.Errorf("no samples in the %s before %s", lookback, t.Format(time.RFC3339))
+	}
+	return samples[len(samples)-1].Value, nil
+}
+
+// counterIncreaseOf returns the increase of a counter over its samples. A
+// decrease is a counter reset, after which the counter restarted from zero.
+func counterIncreaseOf(samples []Sample) float64 {
+	var increase float64
+	for idx := 1; idx < len(samples); idx++ {
+		delta := samples[idx].Value - samples[idx-1].Value
+		if delta < 0 {
+			delta = samples[idx].Value
+		}
+		increase += delta
+	}
+	return increase
+}
+
+// sampledIncrease returns the increase of the counter returned by q from
+// start to end, computed from its samples, for the backends without an
+// increase function.
+func sampledIncrease(b Backend, q string, start, end time.Time) (float64, error) {
+	if !end.After(start) {
+		return 0, fmt.Errorf("empty period")
+	}
+	step := end.Sub(start) / maxRangePoints
+	if step < time.Minute {
+		step = time.Minute
+	}
+	samples, err := b.QueryRange(q, start, end, step)
+	if err != nil {
+		return 0, err
+	}
+	if len(samples) < 2 {
+		return 0, fmt.Errorf("need at least 2 samples, got %d", len(samples))
+	}
+	return counterIncreaseOf(samples), nil
+}
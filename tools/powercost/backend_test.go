@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBackend is a Backend serving in-memory series, keyed by query. Range
+// queries return the samples between start and end, whatever the step.
+type fakeBackend struct {
+	series map[string][]Sample
+}
+
+func (b *fakeBackend) QueryAt(q string, t time.Time) (float64, error) {
+	return lastSample(b, q, t)
+}
+
+func (b *fakeBackend) QueryRange(q string, start, end time.Time, step time.Duration) ([]Sample, error) {
+	series, ok := b.series[q]
+	if !ok {
+		return nil, fmt.Errorf("unknown series '%s'", q)
+	}
+	var samples []Sample
+	for _, s := range series {
+		if !s.Time.Before(start) && !s.Time.After(end) {
+			samples = append(samples, s)
+		}
+	}
+	return samples, nil
+}
+
+func (b *fakeBackend) Increase(q string, start, end time.Time) (float64, error) {
+	return sampledIncrease(b, q, start, end)
+}
+
+// sampleEvery returns the samples of f from start to end included, every
+// step, skipping the times where f returns false.
+func sampleEvery(start, end time.Time, step time.Duration, f func(t time.Time) (float64, bool)) []Sample {
+	var samples []Sample
+	for t := start; !t.After(end); t = t.Add(step) {
+		if v, ok := f(t); ok {
+			samples = append(samples, Sample{Time: t, Value: v})
+		}
+	}
+	return samples
+}
+
+func TestCounterIncreaseOf(t *testing.T) {
+	at := func(minutes int, v float64) Sample {
+		return Sample{Time: time.Unix(int64(minutes)*60, 0), Value: v}
+	}
+	for _, tc := range []struct {
+		name    string
+		samples []Sample
+		want    float64
+	}{
+		{"no samples", nil, 0},
+		{"one sample", []Sample{at(0, 10)}, 0},
+		{"increasing", []Sample{at(0, 10), at(1, 15), at(2, 30)}, 20},
+		{"flat", []Sample{at(0, 10), at(1, 10)}, 0},
+		{"reset", []Sample{at(0, 10), at(1, 20), at(2, 5), at(3, 8)}, 18},
+		{"reset to zero", []Sample{at(0, 10), at(1, 0), at(2, 4)}, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := counterIncreaseOf(tc.samples); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSampledIncrease(t *testing.T) {
+	start := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+	b := &fakeBackend{series: map[string][]Sample{
+		"energy": sampleEvery(start, start.Add(time.Hour), 15*time.Minute, func(t time.Time) (float64, bool) {
+			return t.Sub(start).Minutes(), true
+		}),
+	}}
+	v, err := sampledIncrease(b, "energy", start, start.Add(time.Hour))
+	if err != nil || v != 60 {
+		t.Errorf("got %v and %v, want 60", v, err)
+	}
+	if _, err := sampledIncrease(b, "energy", start, start.Add(10*time.Minute)); err == nil {
+		t.Error("want an error for a single sample")
+	}
+	if _, err := sampledIncrease(b, "energy", start, start); err == nil {
+		t.Error("want an error for an empty period")
+	}
+	if _, err := sampledIncrease(b, "power", start, start.Add(time.Hour)); err == nil {
+		t.Error("want an error for an unknown series")
+	}
+}
+
+func TestLastSample(t *testing.T) {
+	start := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+	b := &fakeBackend{series: map[string][]Sample{
+		"power": {{Time: start, Value: 1}, {Time: start.Add(2 * time.Minute), Value: 2}},
+	}}
+	for _, tc := range []struct {
+		name string
+		t    time.Time
+		want float64
+		ok   bool
+	}{
+		{"at a sample", start, 1, true},
+		{"between samples", start.Add(time.Minute), 1, true},
+		{"within the lookback", start.Add(2*time.Minute + lookback), 2, true},
+		{"after the lookback", start.Add(3*time.Minute + lookback), 0, false},
+		{"before the first sample", start.Add(-time.Second), 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := lastSample(b, "power", tc.t)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got %v", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("got %v and %v, want %v", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	base := Config{PrometheusScheme: "http", PrometheusHostPort: "localhost:9090", PrometheusQueryPath: "/api/v1/query"}
+	for _, tc := range []struct {
+		name    string
+		backend BackendConfig
+		http    HTTPConfig
+		errMsg  string
+	}{
+		{name: "default"},
+		{name: "prometheus with a url", backend: BackendConfig{Type: BackendPrometheus, URL: "http://prometheus:9090"}},
+		{name: "victoriametrics", backend: BackendConfig{Type: BackendVictoriaMetrics, URL: "http://vm:8428"}},
+		{name: "victoriametrics without a url", backend: BackendConfig{Type: BackendVictoriaMetrics}, errMsg: "url"},
+		{name: "flux", backend: BackendConfig{Type: BackendInfluxDBFlux, URL: "http://influx:8086", Org: "home", Token: "t"}},
+		{name: "flux without an org", backend: BackendConfig{Type: BackendInfluxDBFlux, URL: "http://influx:8086"}, errMsg: "org"},
+		{name: "influxql", backend: BackendConfig{Type: BackendInfluxDBInfluxQL, URL: "http://influx:8086", Database: "home"}},
+		{name: "influxql without a database", backend: BackendConfig{Type: BackendInfluxDBInfluxQL, URL: "http://influx:8086"}, errMsg: "database"},
+		{name: "influxdb without a url", backend: BackendConfig{Type: BackendInfluxDBInfluxQL, Database: "home"}, errMsg: "url"},
+		{
+			name:    "two influxdb tokens",
+			backend: BackendConfig{Type: BackendInfluxDBFlux, URL: "http://influx:8086", Org: "home", Token: "t", TokenFile: "f"},
+			errMsg:  "token",
+		},
+		{
+			name:    "influxdb token and bearer token",
+			backend: BackendConfig{Type: BackendInfluxDBFlux, URL: "http://influx:8086", Org: "home", Token: "t"},
+			http:    HTTPConfig{BearerToken: "b"},
+			errMsg:  "token",
+		},
+		{name: "token for prometheus", backend: BackendConfig{Token: "t"}, errMsg: "InfluxDB"},
+		{name: "csv", backend: BackendConfig{Type: BackendCSV, Path: "series.csv"}},
+		{name: "csv without a path", backend: BackendConfig{Type: BackendCSV}, errMsg: "path"},
+		{name: "unknown type", backend: BackendConfig{Type: "graphite"}, errMsg: "unknown backend"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := base
+			cfg.Backend, cfg.PrometheusHTTP = tc.backend, tc.http
+			b, err := newBackend(&cfg, http.DefaultClient)
+			if tc.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+					t.Errorf("got %v, want an error about %s", err, tc.errMsg)
+				}
+				return
+			}
+			if err != nil || b == nil {
+				t.Errorf("got %v and %v, want a backend", b, err)
+			}
+		})
+	}
+}
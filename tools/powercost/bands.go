@@ -0,0 +1,94 @@
+package main
+
+import "time"
+
+// Band is an ARERA time band ("fascia oraria").
+type Band int
+
+// ARERA time bands.
+const (
+	// F1 is Monday to Friday from 8:00 to 19:00, except holidays
+	F1 Band = iota + 1
+	// F2 is Monday to Friday from 7:00 to 8:00 and from 19:00 to 23:00,
+	// and Saturday from 7:00 to 23:00, except holidays
+	F2
+	// F3 is every day from 23:00 to 7:00, and Sundays and holidays
+	F3
+)
+
+func (b Band) String() string {
+	switch b {
+	case F1:
+		return "F1"
+	case F2:
+		return "F2"
+	case F3:
+		return "F3"
+	}
+	return "unknown"
+}
+
+// easter returns Easter Sunday of the given year, computed with the
+// anonymous Gregorian algorithm.
+func easter(year int, loc *time.Location) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+}
+
+// holidays are the fixed-date national holidays in Italy.
+var holidays = []struct {
+	month time.Month
+	day   int
+}{
+	{time.January, 1},   // Capodanno
+	{time.January, 6},   // Epifania
+	{time.April, 25},    // Festa della Liberazione
+	{time.May, 1},       // Festa del Lavoro
+	{time.June, 2},      // Festa della Repubblica
+	{time.August, 15},   // Ferragosto
+	{time.November, 1},  // Ognissanti
+	{time.December, 8},  // Immacolata Concezione
+	{time.December, 25}, // Natale
+	{time.December, 26}, // Santo Stefano
+}
+
+// isHoliday tells whether the day of t is a national holiday in Italy,
+// including Easter Monday.
+func isHoliday(t time.Time) bool {
+	y, m, d := t.Date()
+	for _, h := range holidays {
+		if m == h.month && d == h.day {
+			return true
+		}
+	}
+	_, em, ed := easter(y, t.Location()).AddDate(0, 0, 1).Date()
+	return m == em && d == ed
+}
+
+// bandOf returns the ARERA time band of t, which must be in Italian time.
+func bandOf(t time.Time) Band {
+	if t.Weekday() == time.Sunday || isHoliday(t) {
+		return F3
+	}
+	hour := t.Hour()
+	if hour < 7 || hour >= 23 {
+		return F3
+	}
+	if t.Weekday() == time.Saturday {
+		return F2
+	}
+	if hour >= 8 && hour < 19 {
+		return F1
+	}
+	return F2
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+func TestEaster(t *testing.T) {
+	for _, want := range []string{
+		"2019-04-21",
+		"2024-03-31",
+		"2025-04-20",
+		"2026-04-05",
+		"2038-04-25",
+	} {
+		day, err := time.ParseInLocation("2006-01-02", want, market.Location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := easter(day.Year(), market.Location); !got.Equal(day) {
+			t.Errorf("got %s, want %s", got.Format("2006-01-02"), want)
+		}
+	}
+}
+
+func TestIsHoliday(t *testing.T) {
+	for _, tc := range []struct {
+		day  string
+		want bool
+	}{
+		{"2024-01-01", true},
+		{"2024-04-25", true},
+		{"2024-12-26", true},
+		// Easter Monday
+		{"2024-04-01", true},
+		{"2025-04-21", true},
+		// Easter Sunday is a Sunday anyway, and Good Friday is no holiday
+		{"2024-03-31", false},
+		{"2024-03-29", false},
+		{"2024-04-02", false},
+		{"2024-07-15", false},
+	} {
+		t.Run(tc.day, func(t *testing.T) {
+			day, err := time.ParseInLocation("2006-01-02", tc.day, market.Location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := isHoliday(day.Add(12 * time.Hour)); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBandOf(t *testing.T) {
+	for _, tc := range []struct {
+		t    string
+		want Band
+	}{
+		// Monday
+		{"2024-07-15 06:59", F3},
+		{"2024-07-15 07:00", F2},
+		{"2024-07-15 08:00", F1},
+		{"2024-07-15 18:59", F1},
+		{"2024-07-15 19:00", F2},
+		{"2024-07-15 22:59", F2},
+		{"2024-07-15 23:00", F3},
+		// Saturday
+		{"2024-07-13 06:59", F3},
+		{"2024-07-13 10:00", F2},
+		{"2024-07-13 23:00", F3},
+		// Sunday
+		{"2024-07-14 10:00", F3},
+		// Easter Monday and Ferragosto, on a Thursday
+		{"2024-04-01 10:00", F3},
+		{"2024-08-15 10:00", F3},
+		// the day after Easter Monday
+		{"2024-04-02 10:00", F1},
+	} {
+		t.Run(tc.t, func(t *testing.T) {
+			ts, err := time.ParseInLocation("2006-01-02 15:04", tc.t, market.Location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := bandOf(ts); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/maja42/goval"
+)
+
+// defaultCERFormula is the incentive of an hour for plants up to 200 kW: the
+// shared energy paid a fixed 80 EUR/MWh plus up to 100 EUR/MWh when the zonal
+// price is below 180 EUR/MWh, for at most 120 EUR/MWh.
+const defaultCERFormula = "SHARED / 1000 * min(120, 80 + max(0, 180 - PZ))"
+
+// CERMember is a member of a renewable energy community.
+type CERMember struct {
+	Name string `json:"name"`
+	// CSV, if set, is a CSV file read like the csv backend, that the
+	// member's series are read from instead of the configured backend
+	CSV string `json:"csv,omitempty"`
+	// Import is the energy the member takes from the grid
+	Import EnergySeries `json:"import"`
+	// Export is the energy the member feeds into the grid
+	Export EnergySeries `json:"export"`
+}
+
+// CERConfig configures the renewable energy community ("Comunità Energetica
+// Rinnovabile").
+type CERConfig struct {
+	// Formula is the incentive of an hour, as a goval expression using
+	// SHARED, IMPORT and EXPORT, the shared, imported and exported energy of
+	// the community in kWh, and PZ, the zonal price in EUR/MWh. min and max
+	// are available.
+	Formula string      `json:"formula"`
+	Members []CERMember `json:"members"`
+	// Zone is the market zone of the community, e.g. NORD, whose hourly
+	// price is PZ
+	Zone string `json:"zone"`
+}
+
+// cerFunctions are the functions available to the incentive formula.
+var cerFunctions = map[string]goval.ExpressionFunction{
+	"min": func(args ...interface{}) (interface{}, error) { return foldNumbers(math.Min, args) },
+	"max": func(args ...interface{}) (interface{}, error) { return foldNumbers(math.Max, args) },
+}
+
+// toFloat returns the value of a number returned by goval.
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	}
+	return 0, fmt.Errorf("expected a number, got %v (%T)", v, v)
+}
+
+// foldNumbers applies f to all the numbers in args.
+func foldNumbers(f func(float64, float64) float64, args []interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("at least one argument is required")
+	}
+	var result float64
+	for idx, arg := range args {
+		v, err := toFloat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if idx == 0 {
+			result = v
+		} else {
+			result = f(result, v)
+		}
+	}
+	return result, nil
+}
+
+// incentive evaluates the formula for an hour.
+func (c *CERConfig) incentive(shared, imported, exported, price float64) (float64, error) {
+	variables := map[string]interface{}{
+		"SHARED": shared,
+		"IMPORT": imported,
+		"EXPORT": exported,
+		"PZ":     price,
+	}
+	result, err := goval.NewEvaluator().Evaluate(c.Formula, variables, cerFunctions)
+	if err != nil {
+		return 0, fmt.Errorf("cannot evaluate formula '%s': %w", c.Formula, err)
+	}
+	return toFloat(result)
+}
+
+// Validate checks the members, the zone and the formula.
+func (c *CERConfig) Validate() error {
+	if err := validateMarketZone(c.Zone); err != nil {
+		return err
+	}
+	if len(c.Members) == 0 {
+		return fmt.Errorf("no members")
+	}
+	names := make(map[string]bool, len(c.Members))
+	for idx := range c.Members {
+		m := &c.Members[idx]
+		if m.Name == "" {
+			return fmt.Errorf("member name cannot be empty")
+		}
+		if names[m.Name] {
+			return fmt.Errorf("duplicate member '%s'", m.Name)
+		}
+		names[m.Name] = true
+		for _, p := range []Preset{m.Import.preset(m.Name + " import"), m.Export.preset(m.Name + " export")} {
+			if err := validateHourly(&p); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := c.incentive(1, 1, 1, 100); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cerMemberEnergy is the energy a member imported and exported in the
+// period.
+type cerMemberEnergy struct {
+	name               string
+	imported, exported float64
+}
+
+// cerSummary prints the shared energy of the community over period and its
+// incentive. The shared energy of every hour is the minimum of the energy
+// exported and imported by all the members. The incentive of every hour is
+// computed by the formula with the hourly price of the community's zone.
+func cerSummary(cfg *Config, period *Period) error {
+	if cfg.CER == nil {
+		return fmt.Errorf("no cer in the configuration file")
+	}
+	from := period.From.Truncate(time.Hour)
+	to := period.To.Truncate(time.Hour)
+	if !to.After(from) {
+		return fmt.Errorf("no complete hour in the period")
+	}
+	var (
+		imports  = make(map[int64]float64)
+		exports  = make(map[int64]float64)
+		members  = make([]cerMemberEnergy, len(cfg.CER.Members))
+		backends = make(map[string]Backend)
+	)
+	for idx := range cfg.CER.Members {
+		m := &cfg.CER.Members[idx]
+		b := cfg.backend
+		if m.CSV != "" {
+			// members sharing a file read it once
+			if backends[m.CSV] == nil {
+				backends[m.CSV] = NewCSVBackend(m.CSV)
+			}
+			b = backends[m.CSV]
+		}
+		members[idx].name = m.Name
+		for _, s := range []struct {
+			preset Preset
+			total  map[int64]float64
+			sum    *float64
+		}{
+			{m.Import.preset("import"), imports, &members[idx].imported},
+			{m.Export.preset("export"), exports, &members[idx].exported},
+		} {
+			energy, err := hourlyEnergy(b, &s.preset, from, to, *flagStep, *flagMaxGap)
+			if err != nil {
+				return fmt.Errorf("member '%s': %s query failed: %w", m.Name, s.preset.Name, err)
+			}
+			for hour, kwh := range energy {
+				s.total[hour] += kwh
+				*s.sum += kwh
+			}
+		}
+	}
+
+	zone := cfg.CER.Zone
+	hourly, err := punapiHourlyAverages(cfg, zone, from, to)
+	if err != nil {
+		return err
+	}
+
+	var (
+		importedKwh, exportedKwh, sharedKwh, incentive float64
+		unpriced                                       int
+	)
+	for hour := from; hour.Before(to); hour = hour.Add(time.Hour) {
+		h := hour.Unix()
+		shared := math.Min(imports[h], exports[h])
+		importedKwh += imports[h]
+		exportedKwh += exports[h]
+		sharedKwh += shared
+		if shared <= 0 {
+			continue
+		}
+		price, ok := hourly[h]
+		if !ok {
+			unpriced++
+			continue
+		}
+		v, err := cfg.CER.incentive(shared, imports[h], exports[h], price)
+		if err != nil {
+			return fmt.Errorf("hour %s: %w", hour.Format("2006-01-02 15:04"), err)
+		}
+		incentive += v
+	}
+
+	fmt.Printf("## Renewable energy community (%s)\n", zone)
+	fmt.Printf("    period   : %s to %s\n", from.Format("2006-01-02 15:04"), to.Format("2006-01-02 15:04"))
+	fmt.Printf("    formula  : %s\n", cfg.CER.Formula)
+	fmt.Printf("    import   : %.3f kWh\n", importedKwh)
+	fmt.Printf("    export   : %.3f kWh\n", exportedKwh)
+	fmt.Printf("    shared   : %.3f kWh", sharedKwh)
+	if exportedKwh > 0 {
+		fmt.Printf(" (%.1f%% of the export)", sharedKwh/exportedKwh*100)
+	}
+	fmt.Println()
+	if unpriced > 0 {
+		fmt.Printf("    unpriced : %d hours of shared energy without a %s price\n", unpriced, zone)
+	}
+	fmt.Printf("    incentive: %.3f %s", incentive, cfg.Currency)
+	if sharedKwh > 0 {
+		fmt.Printf(" (%.3f %s/MWh)", incentive/sharedKwh*1000, cfg.Currency)
+	}
+	fmt.Println()
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "MEMBER\tIMPORT kWh\tEXPORT kWh\n")
+	for _, m := range members {
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\n", m.name, m.imported, m.exported)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCERIncentive(t *testing.T) {
+	c := CERConfig{Formula: defaultCERFormula}
+	for _, tc := range []struct {
+		name   string
+		shared float64
+		price  float64
+		want   float64
+	}{
+		// 80 + (180 - 100) capped at 120 EUR/MWh
+		{"low price", 1000, 100, 120},
+		{"mid price", 1000, 150, 110},
+		// no variable part above 180 EUR/MWh
+		{"high price", 1000, 200, 80},
+		{"partial MWh", 500, 200, 40},
+		{"no shared energy", 0, 100, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := c.incentive(tc.shared, 2000, 1000, tc.price)
+			if err != nil {
+				t.Fatalf("incentive failed: %v", err)
+			}
+			if math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	custom := CERConfig{Formula: "max(IMPORT, EXPORT, 3) - min(SHARED, 1)"}
+	if got, err := custom.incentive(0.5, 2, 1, 0); err != nil || got != 2.5 {
+		t.Errorf("got %v and %v, want 2.5", got, err)
+	}
+	// integer results are numbers too
+	integer := CERConfig{Formula: "2 + 3"}
+	if got, err := integer.incentive(0, 0, 0, 0); err != nil || got != 5 {
+		t.Errorf("got %v and %v, want 5", got, err)
+	}
+	for _, formula := range []string{"SHARED *", "UNKNOWN + 1", "max()", "min(SHARED, \"a\")", "\"text\"", "SHARED > 1"} {
+		bad := CERConfig{Formula: formula}
+		if got, err := bad.incentive(1, 1, 1, 100); err == nil {
+			t.Errorf("%s: want an error, got %v", formula, got)
+		}
+	}
+}
+
+func TestCERConfigValidate(t *testing.T) {
+	member := func(name string) CERMember {
+		return CERMember{
+			Name:   name,
+			Import: EnergySeries{Query: "import", Unit: UnitKWh},
+			Export: EnergySeries{Query: "export", Unit: UnitWhCounter},
+		}
+	}
+	noUnit := member("flat2")
+	noUnit.Export.Unit = ""
+	noQuery := member("flat2")
+	noQuery.Import.Query = ""
+	for _, tc := range []struct {
+		name string
+		cfg  CERConfig
+		ok   bool
+	}{
+		{"valid", CERConfig{Formula: defaultCERFormula, Members: []CERMember{member("flat1"), member("flat2")}, Zone: "NORD"}, true},
+		{"no members", CERConfig{Formula: defaultCERFormula, Zone: "NORD"}, false},
+		{"unnamed member", CERConfig{Formula: defaultCERFormula, Members: []CERMember{member("")}, Zone: "NORD"}, false},
+		{"duplicate member", CERConfig{Formula: defaultCERFormula, Members: []CERMember{member("flat1"), member("flat1")}, Zone: "NORD"}, false},
+		{"no unit", CERConfig{Formula: defaultCERFormula, Members: []CERMember{member("flat1"), noUnit}, Zone: "NORD"}, false},
+		{"no query", CERConfig{Formula: defaultCERFormula, Members: []CERMember{noQuery}, Zone: "NORD"}, false},
+		{"invalid formula", CERConfig{Formula: "SHARED +", Members: []CERMember{member("flat1")}, Zone: "NORD"}, false},
+		{"no zone", CERConfig{Formula: defaultCERFormula, Members: []CERMember{member("flat1")}}, false},
+		// PZ is the zonal price
+		{"PUN", CERConfig{Formula: defaultCERFormula, Members: []CERMember{member("flat1")}, Zone: "PUN"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.cfg.Validate(); (err == nil) != tc.ok {
+				t.Errorf("got %v, want ok %v", err, tc.ok)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path"
 
@@ -11,12 +12,30 @@ import (
 )
 
 type Config struct {
-	PrometheusScheme    string `json:"prometheus_scheme"`
-	PrometheusHostPort  string `json:"prometheus_host_port"`
-	PrometheusQueryPath string `json:"prometheus_query_path"`
-	Currency            string `json:"currency"`
-	// internal field
-	path string
+	PrometheusScheme    string   `json:"prometheus_scheme"`
+	PrometheusHostPort  string   `json:"prometheus_host_port"`
+	PrometheusQueryPath string   `json:"prometheus_query_path"`
+	Currency            string   `json:"currency"`
+	PunAPIURL           string   `json:"punapi_url"`
+	Presets             []Preset `json:"presets"`
+	// Tariffs are the offers compared by the tariffs command
+	Tariffs []Tariff `json:"tariffs,omitempty"`
+	// PV holds the series of the photovoltaic system, if any
+	PV *PVConfig `json:"pv,omitempty"`
+	// CER configures the renewable energy community, if any
+	CER *CERConfig `json:"cer,omitempty"`
+	// BillingCycleDay is the day of the month the billing cycle starts on
+	BillingCycleDay int `json:"billing_cycle_day"`
+	// PrometheusHTTP holds the authentication, TLS and timeout options of
+	// the requests to Prometheus, or to any other HTTP backend
+	PrometheusHTTP HTTPConfig `json:"prometheus_http"`
+	// Backend is the time-series database to query, Prometheus by default
+	Backend BackendConfig `json:"backend"`
+	// internal fields
+	path    string
+	backend Backend
+	// client has the timeout and TLS options of PrometheusHTTP
+	client *http.Client
 }
 
 func loadConfig(overrides map[string]interface{}) (*Config, error) {
@@ -27,6 +46,11 @@ func loadConfig(overrides map[string]interface{}) (*Config, error) {
 		PrometheusHostPort:  defaultPrometheusHostPort,
 		PrometheusQueryPath: defaultPrometheusQueryPath,
 		Currency:            defaultCurrency,
+		PunAPIURL:           defaultPunAPIURL,
+		Presets:             append([]Preset(nil), defaultPresets...),
+		BillingCycleDay:     defaultBillingCycleDay,
+		PrometheusHTTP:      HTTPConfig{Timeout: defaultPrometheusTimeout},
+		Backend:             BackendConfig{Type: BackendPrometheus},
 		path:                configFile,
 	}
 	data, err := os.ReadFile(configFile)
@@ -61,9 +85,62 @@ func loadConfig(overrides map[string]interface{}) (*Config, error) {
 			cfg.PrometheusQueryPath = v.(string)
 		case "currency":
 			cfg.Currency = v.(string)
+		case "punapi_url":
+			cfg.PunAPIURL = v.(string)
+		case "billing_cycle_day":
+			cfg.BillingCycleDay = v.(int)
 		default:
 			return nil, fmt.Errorf("unknown config override '%s'", k)
 		}
 	}
+	if err := cfg.PrometheusHTTP.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid prometheus_http: %w", err)
+	}
+	cfg.client, err = cfg.PrometheusHTTP.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create HTTP client: %w", err)
+	}
+	cfg.backend, err = newBackend(&cfg, cfg.client)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend: %w", err)
+	}
+	if cfg.BillingCycleDay < 1 || cfg.BillingCycleDay > 31 {
+		return nil, fmt.Errorf("billing_cycle_day must be between 1 and 31, got %d", cfg.BillingCycleDay)
+	}
+	names := make(map[string]bool, len(cfg.Presets))
+	for idx := range cfg.Presets {
+		p := &cfg.Presets[idx]
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate preset '%s'", p.Name)
+		}
+		names[p.Name] = true
+	}
+	tariffs := make(map[string]bool, len(cfg.Tariffs))
+	for idx := range cfg.Tariffs {
+		t := &cfg.Tariffs[idx]
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+		if tariffs[t.Name] {
+			return nil, fmt.Errorf("duplicate tariff '%s'", t.Name)
+		}
+		tariffs[t.Name] = true
+	}
+	if cfg.PV != nil {
+		if err := cfg.PV.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid pv: %w", err)
+		}
+	}
+	if cfg.CER != nil {
+		if cfg.CER.Formula == "" {
+			cfg.CER.Formula = defaultCERFormula
+		}
+		if err := cfg.CER.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid cer: %w", err)
+		}
+	}
 	return &cfg, nil
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kirsle/configdir"
+)
+
+// withConfig points the configuration directory to a temporary one, with the
+// given config.json unless it is empty.
+func withConfig(t *testing.T, data string) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	configdir.Refresh()
+	t.Cleanup(configdir.Refresh)
+	if data == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Join(dir, progname), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, progname, "config.json"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigDefault(t *testing.T) {
+	withConfig(t, "")
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if len(cfg.Presets) != len(defaultPresets) {
+		t.Errorf("got %d presets, want the %d default ones", len(cfg.Presets), len(defaultPresets))
+	}
+	// the default configuration is written, and loads again
+	if _, err := os.Stat(cfg.path); err != nil {
+		t.Errorf("default configuration not written: %v", err)
+	}
+	if _, err := loadConfig(nil); err != nil {
+		t.Errorf("cannot load the default configuration: %v", err)
+	}
+}
+
+func TestLoadConfigPresets(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		presets string
+		want    []string
+		errMsg  string
+	}{
+		{
+			name:    "custom presets",
+			presets: `[{"name": "boiler", "query": "boiler_power_w", "unit": "W-integrated", "period": "1d"}, {"name": "today", "query": "q", "unit": "kWh", "period": "today"}]`,
+			want:    []string{"boiler", "today"},
+		},
+		{name: "no presets", presets: `[]`},
+		{
+			name:    "unknown unit",
+			presets: `[{"name": "boiler", "query": "boiler_power_w", "unit": "W", "period": "1d"}]`,
+			errMsg:  "unit",
+		},
+		{
+			name:    "invalid period",
+			presets: `[{"name": "boiler", "query": "boiler_power_w", "unit": "W-integrated", "period": "daily"}]`,
+			errMsg:  "period",
+		},
+		{
+			name:    "duplicate name",
+			presets: `[{"name": "a", "query": "q", "unit": "Wh", "period": "today"}, {"name": "a", "query": "q", "unit": "Wh", "period": "7d"}]`,
+			errMsg:  "duplicate preset 'a'",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withConfig(t, `{"presets": `+tc.presets+`}`)
+			cfg, err := loadConfig(nil)
+			if tc.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+					t.Errorf("got %v, want an error about %s", err, tc.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig failed: %v", err)
+			}
+			if len(cfg.Presets) != len(tc.want) {
+				t.Fatalf("got %d presets, want %d", len(cfg.Presets), len(tc.want))
+			}
+			for idx, name := range tc.want {
+				if cfg.Presets[idx].Name != name {
+					t.Errorf("preset #%d is %s, want %s", idx, cfg.Presets[idx].Name, name)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	withConfig(t, `{"currency": "EUR"}`)
+	cfg, err := loadConfig(map[string]interface{}{"currency": "USD"})
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if cfg.Currency != "USD" {
+		t.Errorf("got currency %s, want USD", cfg.Currency)
+	}
+	if _, err := loadConfig(map[string]interface{}{"colour": "red"}); err == nil {
+		t.Error("want an error for an unknown override")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// csvBackend reads the time series from a CSV file with the columns time,
+// series and value. Times are RFC 3339 or Unix timestamps, and the query is
+// the name of a series. A header row is allowed. The file is read once.
+type csvBackend struct {
+	path string
+
+	once   sync.Once
+	series map[string][]Sample
+	err    error
+}
+
+// NewCSVBackend returns a backend reading the CSV file at path.
+func NewCSVBackend(path string) Backend {
+	return &csvBackend{path: path}
+}
+
+func parseCSVTime(s string) (time.Time, error) {
+	if ts, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(ts*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// readSeries parses the CSV file into series sorted by time.
+func readSeries(r io.Reader) (map[string][]Sample, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+	series := make(map[string][]Sample)
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		t, err := parseCSVTime(strings.TrimSpace(record[0]))
+		if err != nil {
+			if line == 1 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid time '%s'", line, record[0])
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value '%s'", line, record[2])
+		}
+		name := strings.TrimSpace(record[1])
+		series[name] = append(series[name], Sample{Time: t, Value: v})
+	}
+	for _, samples := range series {
+		sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	}
+	return series, nil
+}
+
+func (b *csvBackend) load(q string) ([]Sample, error) {
+	b.once.Do(func() {
+		f, err := os.Open(b.path)
+		if err != nil {
+			b.err = fmt.Errorf("cannot open CSV file: %w", err)
+			return
+		}
+		defer f.Close()
+		if b.series, err = readSeries(f); err != nil {
+			b.err = fmt.Errorf("cannot parse CSV file '%s': %w", b.path, err)
+		}
+	})
+	if b.err != nil {
+		return nil, b.err
+	}
+	samples, ok := b.series[q]
+	if !ok {
+		return nil, fmt.Errorf("no series '%s' in '%s'", q, b.path)
+	}
+	return samples, nil
+}
+
+// QueryAt returns the last sample at or before t, however old.
+func (b *csvBackend) QueryAt(q string, t time.Time) (float64, error) {
+	samples, err := b.load(q)
+	if err != nil {
+		return 0, err
+	}
+	idx := sort.Search(len(samples), func(i int) bool { return samples[i].Time.After(t) })
+	if idx == 0 {
+		return 0, fmt.Errorf("no samples of '%s' before %s", q, t.Format(time.RFC3339))
+	}
+	return samples[idx-1].Value, nil
+}
+
+// QueryRange returns the samples from start to end as they are in the file,
+// regardless of step.
+func (b *csvBackend) QueryRange(q string, start, end time.Time, step time.Duration) ([]Sample, error) {
+	samples, err := b.load(q)
+	if err != nil {
+		return nil, err
+	}
+	from := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(start) })
+	to := sort.Search(len(samples), func(i int) bool { return samples[i].Time.After(end) })
+	return samples[from:to], nil
+}
+
+func (b *csvBackend) Increase(q string, start, end time.Time) (float64, error) {
+	return sampledIncrease(b, q, start, end)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadSeries(t *testing.T) {
+	series, err := readSeries(strings.NewReader(`time,series,value
+2024-01-15T10:02:00Z, power, 3
+1705312800, power, 1
+2024-01-15T10:01:00+01:00, power, 2
+1705312800.5, energy, 10
+`))
+	if err != nil {
+		t.Fatalf("readSeries failed: %v", err)
+	}
+	power := series["power"]
+	if len(power) != 3 {
+		t.Fatalf("got %+v, want 3 power samples", power)
+	}
+	// 10:01+01:00 is before 10:00Z
+	for idx, want := range []float64{2, 1, 3} {
+		if power[idx].Value != want {
+			t.Errorf("power sample #%d is %v, want %v", idx, power[idx].Value, want)
+		}
+	}
+	energy := series["energy"]
+	if len(energy) != 1 || !energy[0].Time.Equal(time.UnixMilli(1705312800500)) {
+		t.Errorf("unexpected energy samples %+v", energy)
+	}
+
+	for _, bad := range []string{
+		"time,series,value\n1705312800,power,x\n",
+		"1705312800,power,1\nyesterday,power,2\n",
+		"1705312800,power\n",
+	} {
+		if _, err := readSeries(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: want an error", bad)
+		}
+	}
+}
+
+func TestCSVBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "series.csv")
+	var lines []string
+	start := time.Date(2024, time.January, 15, 10, 0, 0, 0, time.UTC)
+	for idx := 0; idx <= 60; idx += 15 {
+		// a counter growing by 100 every 15 minutes, reset at 10:45
+		v := idx / 15 * 100
+		if idx >= 45 {
+			v = (idx - 45) / 15 * 100
+		}
+		lines = append(lines, start.Add(time.Duration(idx)*time.Minute).Format(time.RFC3339)+",energy,"+strconv.Itoa(v))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b := NewCSVBackend(path)
+
+	samples, err := b.QueryRange("energy", start.Add(15*time.Minute), start.Add(45*time.Minute), time.Hour)
+	if err != nil {
+		t.Fatalf("QueryRange failed: %v", err)
+	}
+	if len(samples) != 3 || samples[0].Value != 100 || samples[2].Value != 0 {
+		t.Errorf("got %+v, want the samples from 10:15 to 10:45", samples)
+	}
+	v, err := b.QueryAt("energy", start.Add(20*time.Minute))
+	if err != nil || v != 100 {
+		t.Errorf("QueryAt got %v and %v, want 100", v, err)
+	}
+	if _, err := b.QueryAt("energy", start.Add(-time.Minute)); err == nil {
+		t.Error("QueryAt before the first sample: want an error")
+	}
+	// 200 up to the reset, then 100
+	inc, err := b.Increase("energy", start, start.Add(time.Hour))
+	if err != nil || inc != 300 {
+		t.Errorf("Increase got %v and %v, want 300", inc, err)
+	}
+	if _, err := b.QueryAt("power", start); err == nil {
+		t.Error("unknown series: want an error")
+	}
+	if _, err := NewCSVBackend(filepath.Join(t.TempDir(), "missing.csv")).QueryAt("energy", start); err == nil {
+		t.Error("missing file: want an error")
+	}
+}
@@ -7,6 +7,10 @@ const (
 	defaultPrometheusHostPort  = "localhost"
 	defaultPrometheusQueryPath = "/api/v1/query"
 	defaultCurrency            = "EUR"
+	defaultPunAPIURL           = "http://localhost:8080"
+	defaultZone                = "PUN"
+	defaultBillingCycleDay     = 1
+	defaultPrometheusTimeout   = "30s"
 )
 
 var defaultPrometheusQueryURL = url.URL{
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxRangePoints is the number of points requested by every range query.
+// Prometheus refuses queries returning more than 11000 points per series.
+const maxRangePoints = 10000
+
+// integratePower returns the energy used from start to end according to the
+// power in W returned by the query q, integrating its samples with the
+// trapezoidal rule. Samples are taken every step. Intervals between samples
+// longer than maxGap, e.g. while the sensor was offline, are not integrated,
+// and the covered fraction of the period is returned with the energy. It is
+// the sum of the hours of hourlyEnergyCoverage.
+func integratePower(b Backend, q string, start, end time.Time, step, maxGap time.Duration) (Quantity, float64, error) {
+	energy, coverage, err := hourlyEnergyCoverage(b, &Preset{Name: q, Query: q, Unit: UnitWIntegrated}, start, end, step, maxGap)
+	if err != nil {
+		return Quantity{}, 0, err
+	}
+	var kwh float64
+	for _, v := range energy {
+		kwh += v
+	}
+	return Quantity{Value: kwh * 1000, Unit: WattHour}, coverage, nil
+}
+
+// EnergySeries is a time series that can be split into hours, see
+// hourlyEnergy.
+type EnergySeries struct {
+	Query string `json:"query"`
+	// Unit is one of Wh and kWh for the energy of the hour, W-integrated,
+	// Wh-counter and kWh-counter
+	Unit string `json:"unit"`
+}
+
+// preset returns the series as a preset with the given name.
+func (s *EnergySeries) preset(name string) Preset {
+	return Preset{Name: name, Query: s.Query, Unit: s.Unit}
+}
+
+// validateHourly checks that the preset has a query, with a unit that can be
+// split into hours.
+func validateHourly(p *Preset) error {
+	if p.Query == "" {
+		return fmt.Errorf("%s: query cannot be empty", p.Name)
+	}
+	switch p.Unit {
+	case UnitWh, UnitKWh, UnitWIntegrated, UnitWhCounter, UnitKWhCounter:
+		return nil
+	}
+	return fmt.Errorf("%s: unit must be one of %s, %s, %s, %s, %s, got '%s'", p.Name, UnitWh, UnitKWh, UnitWIntegrated, UnitWhCounter, UnitKWhCounter, p.Unit)
+}
+
+// hourlyEnergy returns the energy in kWh used in every hour from start to end
+// according to the preset, keyed by the Unix time of the start of the hour.
+// Wh and kWh queries are evaluated at the end of every hour and must return
+// the energy of the hour that just ended, like in hourlyConsumption.
+// W-integrated queries are integrated like integratePower, and energy
+// counters are sampled every step. The energy between two samples goes to the
+// hour of the first one.
+func hourlyEnergy(b Backend, p *Preset, start, end time.Time, step, maxGap time.Duration) (map[int64]float64, error) {
+	energy, _, err := hourlyEnergyCoverage(b, p, start, end, step, maxGap)
+	return energy, err
+}
+
+// hourlyEnergyCoverage is hourlyEnergy also returning the fraction of the
+// period with samples, like integratePower. It is always 1 for the units
+// other than W-integrated.
+func hourlyEnergyCoverage(b Backend, p *Preset, start, end time.Time, step, maxGap time.Duration) (map[int64]float64, float64, error) {
+	var kwhPerUnit float64
+	switch p.Unit {
+	case UnitWh, UnitKWh:
+		energy, err := hourlyConsumption(b, p.Query, Unit(p.Unit), start, end)
+		return energy, 1, err
+	case UnitWIntegrated, UnitWhCounter:
+		kwhPerUnit = 1.0 / 1000
+	case UnitKWhCounter:
+		kwhPerUnit = 1
+	default:
+		return nil, 0, fmt.Errorf("unknown unit '%s'", p.Unit)
+	}
+	if !end.After(start) {
+		return nil, 0, fmt.Errorf("empty period")
+	}
+	var (
+		energy   = make(map[int64]float64)
+		covered  time.Duration
+		prev     Sample
+		havePrev bool
+	)
+	chunk := step * maxRangePoints
+	for from := start; from.Before(end); from = from.Add(chunk) {
+		to := from.Add(chunk)
+		if to.After(end) {
+			to = end
+		}
+		samples, err := b.QueryRange(p.Query, from, to, step)
+		if err != nil {
+			return nil, 0, fmt.Errorf("range query failed: %w", err)
+		}
+		for _, s := range samples {
+			// consecutive chunks share their boundary
+			if havePrev && !s.Time.After(prev.Time) {
+				continue
+			}
+			if havePrev {
+				var value float64
+				if p.Unit == UnitWIntegrated {
+					if gap := s.Time.Sub(prev.Time); gap <= maxGap {
+						value = (prev.Value + s.Value) / 2 * gap.Hours()
+						covered += gap
+					}
+				} else {
+					value = s.Value - prev.Value
+					// a decrease is a counter reset
+					if value < 0 {
+						value = s.Value
+					}
+				}
+				energy[prev.Time.Truncate(time.Hour).Unix()] += value * kwhPerUnit
+			}
+			prev, havePrev = s, true
+		}
+	}
+	if p.Unit != UnitWIntegrated {
+		return energy, 1, nil
+	}
+	return energy, float64(covered) / float64(end.Sub(start)), nil
+}
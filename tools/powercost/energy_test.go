@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIntegratePower(t *testing.T) {
+	start := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	for _, tc := range []struct {
+		name     string
+		series   func(time.Time) (float64, bool)
+		step     time.Duration
+		maxGap   time.Duration
+		wantWh   float64
+		coverage float64
+	}{
+		{
+			name:     "constant power",
+			series:   func(time.Time) (float64, bool) { return 100, true },
+			step:     time.Minute,
+			maxGap:   5 * time.Minute,
+			wantWh:   200,
+			coverage: 1,
+		},
+		{
+			// the trapezoidal rule is exact for a linear ramp
+			name:     "ramp",
+			series:   func(t time.Time) (float64, bool) { return t.Sub(start).Hours() * 60, true },
+			step:     10 * time.Minute,
+			maxGap:   10 * time.Minute,
+			wantWh:   120,
+			coverage: 1,
+		},
+		{
+			name: "gap longer than maxGap",
+			series: func(t time.Time) (float64, bool) {
+				// offline for the second hour
+				return 100, t.Sub(start) <= time.Hour || t.Equal(end)
+			},
+			step:     time.Minute,
+			maxGap:   5 * time.Minute,
+			wantWh:   100,
+			coverage: 0.5,
+		},
+		{
+			name: "gap up to maxGap",
+			series: func(t time.Time) (float64, bool) {
+				return 100, t.Sub(start) <= time.Hour || t.Sub(start) >= time.Hour+5*time.Minute
+			},
+			step:     time.Minute,
+			maxGap:   5 * time.Minute,
+			wantWh:   200,
+			coverage: 1,
+		},
+		{
+			name:   "no samples",
+			series: func(time.Time) (float64, bool) { return 0, false },
+			step:   time.Minute,
+			maxGap: 5 * time.Minute,
+		},
+		{
+			// more samples than a single range query can return
+			name:     "several chunks",
+			series:   func(time.Time) (float64, bool) { return 60, true },
+			step:     time.Second,
+			maxGap:   time.Second,
+			wantWh:   120,
+			coverage: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &fakeBackend{series: map[string][]Sample{"power_w": sampleEvery(start, end, tc.step, tc.series)}}
+			energy, coverage, err := integratePower(b, "power_w", start, end, tc.step, tc.maxGap)
+			if err != nil {
+				t.Fatalf("integratePower failed: %v", err)
+			}
+			if energy.Unit != WattHour || math.Abs(energy.Value-tc.wantWh) > 1e-9 {
+				t.Errorf("got %s, want %v Wh", energy, tc.wantWh)
+			}
+			if math.Abs(coverage-tc.coverage) > 1e-9 {
+				t.Errorf("got coverage %v, want %v", coverage, tc.coverage)
+			}
+		})
+	}
+	b := &fakeBackend{series: map[string][]Sample{"power_w": nil}}
+	if _, _, err := integratePower(b, "power_w", end, start, time.Minute, time.Minute); err == nil {
+		t.Error("want an error for an empty period")
+	}
+}
+
+func TestPresetEnergy(t *testing.T) {
+	at := time.Date(2024, time.January, 15, 6, 0, 0, 0, time.UTC)
+	b := &fakeBackend{series: map[string][]Sample{
+		"q": sampleEvery(at.Add(-6*time.Hour), at, time.Minute, func(time.Time) (float64, bool) { return 1500, true }),
+		// 1500 over the 6 hours, with a reset
+		"counter": sampleEvery(at.Add(-6*time.Hour), at, time.Hour, func(t time.Time) (float64, bool) {
+			return float64(t.Sub(at.Add(-6*time.Hour)) / time.Hour % 4 * 300), true
+		}),
+	}}
+	for _, tc := range []struct {
+		name   string
+		preset Preset
+		want   Quantity
+	}{
+		{"Wh", Preset{Query: "q", Unit: UnitWh, Period: "today"}, Quantity{1500, WattHour}},
+		{"kWh", Preset{Query: "q", Unit: UnitKWh, Period: "7d"}, Quantity{1500, KiloWattHour}},
+		{"power", Preset{Query: "q", Unit: UnitWIntegrated, Period: "1h"}, Quantity{9000, WattHour}},
+		{"Wh counter", Preset{Query: "counter", Unit: UnitWhCounter, Period: "1d"}, Quantity{1500, WattHour}},
+		{"kWh counter", Preset{Query: "counter", Unit: UnitKWhCounter, Period: "1d"}, Quantity{1500, KiloWattHour}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, coverage, err := tc.preset.Energy(b, at.Add(-6*time.Hour), at, time.Minute, 5*time.Minute)
+			if err != nil {
+				t.Fatalf("Energy failed: %v", err)
+			}
+			// W-integrated energy is summed hour by hour
+			if got.Unit != tc.want.Unit || math.Abs(got.Value-tc.want.Value) > 1e-9 || coverage != 1 {
+				t.Errorf("got %s and coverage %v, want %s", got, coverage, tc.want)
+			}
+		})
+	}
+	power := Preset{Query: "q", Unit: UnitWIntegrated, Period: "today"}
+	if _, _, err := power.Energy(b, at, at, time.Minute, time.Minute); err == nil {
+		t.Error("want an error for an empty period")
+	}
+}
+
+func TestHourlyEnergy(t *testing.T) {
+	start := time.Date(2024, time.January, 15, 10, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	quarter := 15 * time.Minute
+	b := &fakeBackend{series: map[string][]Sample{
+		// 1 kW in the first hour and 2 kW in the second one, offline from
+		// 11:15 to 11:45
+		"power": sampleEvery(start, end, quarter, func(t time.Time) (float64, bool) {
+			if t.Hour() == 10 || t.Equal(end) {
+				return 1000, true
+			}
+			return 2000, t.Minute() == 0 || t.Minute() == 45
+		}),
+		// 400 Wh every quarter-hour, reset at 11:00
+		"counter": sampleEvery(start, end, quarter, func(t time.Time) (float64, bool) {
+			return float64(t.Sub(start)/quarter%4) * 400, true
+		}),
+	}}
+	for _, tc := range []struct {
+		name     string
+		preset   Preset
+		want     map[int64]float64
+		coverage float64
+	}{
+		{
+			name:   "power",
+			preset: Preset{Query: "power", Unit: UnitWIntegrated},
+			// the 11:00 sample is 2 kW, and 11:45 to 12:00 averages 1.5 kW
+			want: map[int64]float64{start.Unix(): 1.125, start.Add(time.Hour).Unix(): 0.375},
+			// 11:00 to 11:45 is not integrated
+			coverage: 0.625,
+		},
+		{
+			name:   "Wh counter",
+			preset: Preset{Query: "counter", Unit: UnitWhCounter},
+			// the reset to 0 at 11:00 counts as 0
+			want:     map[int64]float64{start.Unix(): 1.2, start.Add(time.Hour).Unix(): 1.2},
+			coverage: 1,
+		},
+		{
+			name:     "kWh counter",
+			preset:   Preset{Query: "counter", Unit: UnitKWhCounter},
+			want:     map[int64]float64{start.Unix(): 1200, start.Add(time.Hour).Unix(): 1200},
+			coverage: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, coverage, err := hourlyEnergyCoverage(b, &tc.preset, start, end, quarter, quarter)
+			if err != nil {
+				t.Fatalf("hourlyEnergyCoverage failed: %v", err)
+			}
+			if math.Abs(coverage-tc.coverage) > 1e-9 {
+				t.Errorf("got coverage %v, want %v", coverage, tc.coverage)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+			for hour, kwh := range tc.want {
+				if math.Abs(got[hour]-kwh) > 1e-9 {
+					t.Errorf("%s: got %v kWh, want %v", time.Unix(hour, 0).UTC(), got[hour], kwh)
+				}
+			}
+		})
+	}
+	for _, p := range []Preset{{Query: "power", Unit: "W"}, {Query: "missing", Unit: UnitWIntegrated}} {
+		if _, err := hourlyEnergy(b, &p, start, end, quarter, quarter); err == nil {
+			t.Errorf("%s %s: want an error", p.Query, p.Unit)
+		}
+	}
+	if _, err := hourlyEnergy(b, &Preset{Query: "power", Unit: UnitWIntegrated}, end, start, quarter, quarter); err == nil {
+		t.Error("want an error for an empty period")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// BasicAuth holds the credentials for HTTP basic authentication.
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	// PasswordFile is read on every request, if Password is empty
+	PasswordFile string `json:"password_file,omitempty"`
+}
+
+// TLSConfig configures the TLS connections to the backend.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of the CAs to trust, in addition to the
+	// system ones
+	CAFile string `json:"ca_file,omitempty"`
+	// CertFile and KeyFile are the PEM client certificate and key
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// HTTPConfig configures the HTTP requests to the backend.
+type HTTPConfig struct {
+	BasicAuth   *BasicAuth `json:"basic_auth,omitempty"`
+	BearerToken string     `json:"bearer_token,omitempty"`
+	// BearerTokenFile is read on every request, so that rotated tokens are
+	// picked up
+	BearerTokenFile string            `json:"bearer_token_file,omitempty"`
+	TLS             TLSConfig         `json:"tls"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	// Timeout is the timeout of every request, as a Go duration string. If
+	// empty, defaultPrometheusTimeout is used.
+	Timeout string `json:"timeout"`
+}
+
+// timeout returns the parsed Timeout, or the default one if not set.
+func (c *HTTPConfig) timeout() (time.Duration, error) {
+	s := c.Timeout
+	if s == "" {
+		s = defaultPrometheusTimeout
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout '%s': %w", s, err)
+	}
+	return d, nil
+}
+
+// Validate checks that the options are consistent.
+func (c *HTTPConfig) Validate() error {
+	auths := 0
+	if c.BasicAuth != nil {
+		auths++
+		if c.BasicAuth.Password != "" && c.BasicAuth.PasswordFile != "" {
+			return fmt.Errorf("only one of password and password_file can be set")
+		}
+	}
+	if c.BearerToken != "" {
+		auths++
+	}
+	if c.BearerTokenFile != "" {
+		auths++
+	}
+	if auths > 1 {
+		return fmt.Errorf("only one of basic_auth, bearer_token and bearer_token_file can be set")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls cert_file and key_file must be set together")
+	}
+	for k := range c.Headers {
+		if strings.EqualFold(k, "Authorization") {
+			return fmt.Errorf("use basic_auth or bearer_token instead of an Authorization header")
+		}
+	}
+	if _, err := c.timeout(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// NewClient returns an HTTP client with the configured TLS options and
+// timeout.
+func (c *HTTPConfig) NewClient() (*http.Client, error) {
+	timeout, err := c.timeout()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         c.TLS.ServerName,
+		InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+	}
+	if c.TLS.CAFile != "" {
+		pem, err := os.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file '%s'", c.TLS.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// readSecretFile returns the content of a password or token file, without
+// the trailing newline.
+func readSecretFile(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Authorize sets the configured headers and credentials on req.
+func (c *HTTPConfig) Authorize(req *http.Request) error {
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	switch {
+	case c.BasicAuth != nil:
+		password := c.BasicAuth.Password
+		if c.BasicAuth.PasswordFile != "" {
+			var err error
+			if password, err = readSecretFile(c.BasicAuth.PasswordFile); err != nil {
+				return fmt.Errorf("failed to read password file: %w", err)
+			}
+		}
+		req.SetBasicAuth(c.BasicAuth.Username, password)
+	case c.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	case c.BearerTokenFile != "":
+		token, err := readSecretFile(c.BearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read bearer token file: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// httpBackend sends the requests of the backends using HTTP APIs.
+type httpBackend struct {
+	client *http.Client
+	// auth holds the headers and credentials of every request, if not nil
+	auth *HTTPConfig
+}
+
+// do sends req with the configured headers and credentials, and returns the
+// body of the response. Responses other than 200 OK are errors.
+func (h *httpBackend) do(req *http.Request) ([]byte, error) {
+	if h.auth != nil {
+		if err := h.auth.Authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP %s failed: %w", req.Method, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-200 HTTP code: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
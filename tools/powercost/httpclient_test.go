@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHTTPConfigTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		timeout string
+		want    time.Duration
+		ok      bool
+	}{
+		// a block without timeout gets the default
+		{"", 30 * time.Second, true},
+		{"5s", 5 * time.Second, true},
+		{"1m30s", 90 * time.Second, true},
+		{"30", 0, false},
+		{"soon", 0, false},
+	} {
+		t.Run(tc.timeout, func(t *testing.T) {
+			c := HTTPConfig{Timeout: tc.timeout}
+			err := c.Validate()
+			if !tc.ok {
+				if err == nil {
+					t.Error("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			client, err := c.NewClient()
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+			if client.Timeout != tc.want {
+				t.Errorf("got timeout %s, want %s", client.Timeout, tc.want)
+			}
+		})
+	}
+}
+
+func TestHTTPConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  HTTPConfig
+	}{
+		{"two passwords", HTTPConfig{BasicAuth: &BasicAuth{Username: "u", Password: "p", PasswordFile: "f"}, Timeout: "1s"}},
+		{"basic auth and token", HTTPConfig{BasicAuth: &BasicAuth{Username: "u"}, BearerToken: "t", Timeout: "1s"}},
+		{"token and token file", HTTPConfig{BearerToken: "t", BearerTokenFile: "f", Timeout: "1s"}},
+		{"cert without key", HTTPConfig{TLS: TLSConfig{CertFile: "cert.pem"}, Timeout: "1s"}},
+		{"authorization header", HTTPConfig{Headers: map[string]string{"authorization": "Bearer t"}, Timeout: "1s"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.cfg.Validate(); err == nil {
+				t.Error("want an error")
+			}
+		})
+	}
+}
+
+func TestHTTPConfigNewClientCAFile(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := HTTPConfig{TLS: TLSConfig{CAFile: caFile}, Timeout: "1s"}
+	if _, err := c.NewClient(); err == nil {
+		t.Error("want an error for a CA file without certificates")
+	}
+	c.TLS.CAFile = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := c.NewClient(); err == nil {
+		t.Error("want an error for a missing CA file")
+	}
+}
+
+func TestHTTPConfigAuthorize(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		cfg  HTTPConfig
+		want string
+	}{
+		{"basic auth", HTTPConfig{BasicAuth: &BasicAuth{Username: "u", Password: "p"}}, "Basic dTpw"},
+		{"basic auth with a password file", HTTPConfig{BasicAuth: &BasicAuth{Username: "u", PasswordFile: tokenFile}}, "Basic dTpzZWNyZXQ="},
+		{"bearer token", HTTPConfig{BearerToken: "t"}, "Bearer t"},
+		{"bearer token file", HTTPConfig{BearerTokenFile: tokenFile}, "Bearer secret"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got, org string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, org = r.Header.Get("Authorization"), r.Header.Get("X-Scope-OrgID")
+			}))
+			defer srv.Close()
+			tc.cfg.Headers = map[string]string{"X-Scope-OrgID": "home"}
+			h := httpBackend{client: srv.Client(), auth: &tc.cfg}
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := h.do(req); err != nil {
+				t.Fatalf("do failed: %v", err)
+			}
+			if got != tc.want || org != "home" {
+				t.Errorf("got Authorization %q and X-Scope-OrgID %q, want %q and home", got, org, tc.want)
+			}
+		})
+	}
+	h := httpBackend{client: http.DefaultClient, auth: &HTTPConfig{BearerTokenFile: filepath.Join(t.TempDir(), "missing")}}
+	req, err := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.do(req); err == nil {
+		t.Error("want an error for a missing token file")
+	}
+}
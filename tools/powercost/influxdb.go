@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxBackend holds what the Flux and InfluxQL backends share.
+type influxBackend struct {
+	httpBackend
+	baseURL          *url.URL
+	token, tokenFile string
+}
+
+func newInfluxBackend(baseURL, token, tokenFile string, client *http.Client, auth *HTTPConfig) (*influxBackend, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL '%s': %w", baseURL, err)
+	}
+	return &influxBackend{
+		httpBackend: httpBackend{client: client, auth: auth},
+		baseURL:     u,
+		token:       token,
+		tokenFile:   tokenFile,
+	}, nil
+}
+
+// authorize sets the API token on req, if any.
+func (b *influxBackend) authorize(req *http.Request) error {
+	token := b.token
+	if b.tokenFile != "" {
+		var err error
+		if token, err = readSecretFile(b.tokenFile); err != nil {
+			return fmt.Errorf("failed to read token file: %w", err)
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+	return nil
+}
+
+// fluxBackend sends Flux queries to the InfluxDB 2 API. Queries can use
+// v.timeRangeStart, v.timeRangeStop and v.windowPeriod, like in Grafana, e.g.
+//
+//	from(bucket: "home")
+//	  |> range(start: v.timeRangeStart, stop: v.timeRangeStop)
+//	  |> filter(fn: (r) => r._measurement == "power")
+//	  |> aggregateWindow(every: v.windowPeriod, fn: mean)
+type fluxBackend struct {
+	*influxBackend
+	org string
+}
+
+// NewFluxBackend returns a backend sending Flux queries to the InfluxDB
+// server at baseURL, in the organization org, with client. token, or the
+// content of tokenFile, is the API token. auth, if not nil, sets the headers
+// and credentials of every request.
+func NewFluxBackend(baseURL, org, token, tokenFile string, client *http.Client, auth *HTTPConfig) (Backend, error) {
+	ib, err := newInfluxBackend(baseURL, token, tokenFile, client, auth)
+	if err != nil {
+		return nil, err
+	}
+	return &fluxBackend{influxBackend: ib, org: org}, nil
+}
+
+func (b *fluxBackend) QueryAt(q string, t time.Time) (float64, error) {
+	return lastSample(b, q, t)
+}
+
+func (b *fluxBackend) QueryRange(q string, start, end time.Time, step time.Duration) ([]Sample, error) {
+	if err := checkStep(step); err != nil {
+		return nil, err
+	}
+	script := fmt.Sprintf("option v = {timeRangeStart: %s, timeRangeStop: %s, windowPeriod: %dms}\n%s",
+		start.UTC().Format(time.RFC3339),
+		// the stop of a Flux range is excluded
+		end.Add(time.Second).UTC().Format(time.RFC3339),
+		step.Milliseconds(),
+		q,
+	)
+	u := b.baseURL.JoinPath("api/v2/query")
+	u.RawQuery = url.Values{"org": []string{b.org}}.Encode()
+	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(script))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/vnd.flux")
+	req.Header.Set("Accept", "application/csv")
+	if err := b.authorize(req); err != nil {
+		return nil, err
+	}
+	body, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return parseFluxCSV(body)
+}
+
+// parseFluxCSV returns the _time and _value columns of a Flux CSV response
+// with a single table.
+func parseFluxCSV(body []byte) ([]Sample, error) {
+	r := csv.NewReader(bytes.NewReader(body))
+	r.FieldsPerRecord = -1
+	var (
+		samples                     []Sample
+		timeCol, valueCol, tableCol = -1, -1, -1
+		firstTable                  string
+		haveFirstTable              bool
+	)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if len(record) > 0 && strings.HasPrefix(record[0], "#") {
+			continue
+		}
+		// every table starts with its header
+		header := false
+		for idx, field := range record {
+			switch field {
+			case "_time":
+				timeCol, header = idx, true
+			case "_value":
+				valueCol, header = idx, true
+			case "table":
+				tableCol = idx
+			}
+		}
+		if header {
+			continue
+		}
+		if timeCol < 0 || valueCol < 0 || timeCol >= len(record) || valueCol >= len(record) {
+			continue
+		}
+		if tableCol >= 0 && tableCol < len(record) {
+			if !haveFirstTable {
+				firstTable, haveFirstTable = record[tableCol], true
+			} else if record[tableCol] != firstTable {
+				return nil, fmt.Errorf("query returned more than one table, want 1")
+			}
+		}
+		if record[valueCol] == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, record[timeCol])
+		if err != nil {
+			return nil, fmt.Errorf("invalid _time '%s': %w", record[timeCol], err)
+		}
+		v, err := strconv.ParseFloat(record[valueCol], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid _value '%s': %w", record[valueCol], err)
+		}
+		samples = append(samples, Sample{Time: t, Value: v})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+func (b *fluxBackend) Increase(q string, start, end time.Time) (float64, error) {
+	return sampledIncrease(b, q, start, end)
+}
+
+// influxQLBackend sends InfluxQL queries to the InfluxDB 1 API, also
+// available in InfluxDB 2. Queries can use $timeFilter and $interval, like in
+// Grafana, e.g.
+//
+//	SELECT mean("value") FROM "power" WHERE $timeFilter GROUP BY time($interval)
+type influxQLBackend struct {
+	*influxBackend
+	database string
+}
+
+// NewInfluxQLBackend returns a backend sending InfluxQL queries to the
+// InfluxDB server at baseURL, on database, with client. token, or the content
+// of tokenFile, is the API token. auth, if not nil, sets the headers and
+// credentials of every request.
+func NewInfluxQLBackend(baseURL, database, token, tokenFile string, client *http.Client, auth *HTTPConfig) (Backend, error) {
+	ib, err := newInfluxBackend(baseURL, token, tokenFile, client, auth)
+	if err != nil {
+		return nil, err
+	}
+	return &influxQLBackend{influxBackend: ib, database: database}, nil
+}
+
+func (b *influxQLBackend) QueryAt(q string, t time.Time) (float64, error) {
+	return lastSample(b, q, t)
+}
+
+func (b *influxQLBackend) QueryRange(q string, start, end time.Time, step time.Duration) ([]Sample, error) {
+	if err := checkStep(step); err != nil {
+		return nil, err
+	}
+	q = strings.NewReplacer(
+		"$timeFilter", fmt.Sprintf("time >= %ds AND time <= %ds", start.Unix(), end.Unix()),
+		"$interval", fmt.Sprintf("%dms", step.Milliseconds()),
+	).Replace(q)
+	u := b.baseURL.JoinPath("query")
+	u.RawQuery = url.Values{
+		"db":    []string{b.database},
+		"q":     []string{q},
+		"epoch": []string{"ms"},
+	}.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := b.authorize(req); err != nil {
+		return nil, err
+	}
+	body, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var resp influxQLResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal failed: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("query failed: %s", resp.Error)
+	}
+	if len(resp.Results) == 0 {
+		return nil, fmt.Errorf("query returned no results")
+	}
+	if resp.Results[0].Error != "" {
+		return nil, fmt.Errorf("query failed: %s", resp.Results[0].Error)
+	}
+	if len(resp.Results[0].Series) == 0 {
+		return nil, nil
+	}
+	if n := len(resp.Results[0].Series); n > 1 {
+		return nil, fmt.Errorf("query returned %d series, want 1", n)
+	}
+	var samples []Sample
+	for _, row := range resp.Results[0].Series[0].Values {
+		// the first column is the time, the second one the value, which
+		// is null in empty intervals
+		if len(row) < 2 || row[0] == nil || row[1] == nil {
+			continue
+		}
+		ts, ok := row[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("invalid time '%v'", row[0])
+		}
+		v, ok := row[1].(float64)
+		if !ok {
+			return nil, fmt.Errorf("invalid value '%v'", row[1])
+		}
+		samples = append(samples, Sample{Time: time.UnixMilli(int64(ts)), Value: v})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+func (b *influxQLBackend) Increase(q string, start, end time.Time) (float64, error) {
+	return sampledIncrease(b, q, start, end)
+}
+
+type influxQLResponse struct {
+	Results []struct {
+		Series []struct {
+			Name    string
+			Columns []string
+			Values  [][]interface{}
+		}
+		Error string
+	}
+	Error string
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFluxCSV(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		csv  string
+		want []float64
+		ok   bool
+	}{
+		{
+			name: "annotated",
+			csv: `#group,false,false,true,true,false,false
+#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,double
+#default,_result,,,,,
+,result,table,_start,_stop,_time,_value
+,,0,2024-01-15T10:00:00Z,2024-01-15T11:00:00Z,2024-01-15T10:01:00Z,2
+,,0,2024-01-15T10:00:00Z,2024-01-15T11:00:00Z,2024-01-15T10:00:00.5Z,1
+`,
+			want: []float64{1, 2},
+			ok:   true,
+		},
+		{
+			name: "empty windows",
+			csv: `,result,table,_time,_value
+,_result,0,2024-01-15T10:00:00Z,
+,_result,0,2024-01-15T10:01:00Z,3
+`,
+			want: []float64{3},
+			ok:   true,
+		},
+		{
+			name: "no rows",
+			csv:  "\r\n",
+			ok:   true,
+		},
+		{
+			name: "more than one table",
+			csv: `,result,table,_time,_value
+,_result,0,2024-01-15T10:00:00Z,1
+,_result,1,2024-01-15T10:00:00Z,2
+`,
+		},
+		{
+			name: "invalid time",
+			csv: `,result,table,_time,_value
+,_result,0,yesterday,1
+`,
+		},
+		{
+			name: "invalid value",
+			csv: `,result,table,_time,_value
+,_result,0,2024-01-15T10:00:00Z,many
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			samples, err := parseFluxCSV([]byte(tc.csv))
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got %+v", samples)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFluxCSV failed: %v", err)
+			}
+			if len(samples) != len(tc.want) {
+				t.Fatalf("got %+v, want the values %v", samples, tc.want)
+			}
+			for idx, s := range samples {
+				if s.Value != tc.want[idx] {
+					t.Errorf("sample #%d is %v, want %v", idx, s.Value, tc.want[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestFluxBackendQueryRange(t *testing.T) {
+	var script, org, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v2/query" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		script, org, auth = string(body), r.URL.Query().Get("org"), r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(",result,table,_time,_value\n,_result,0,2024-01-15T10:00:00Z,1\n"))
+	}))
+	defer srv.Close()
+
+	b, err := NewFluxBackend(srv.URL, "home", "secret", "", srv.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, time.January, 15, 10, 0, 0, 0, time.UTC)
+	samples, err := b.QueryRange(`from(bucket: "home")`, start, start.Add(time.Hour), 90*time.Second)
+	if err != nil {
+		t.Fatalf("QueryRange failed: %v", err)
+	}
+	if len(samples) != 1 || samples[0].Value != 1 {
+		t.Errorf("unexpected samples %+v", samples)
+	}
+	want := "option v = {timeRangeStart: 2024-01-15T10:00:00Z, timeRangeStop: 2024-01-15T11:00:01Z, windowPeriod: 90000ms}\n" +
+		`from(bucket: "home")`
+	if script != want {
+		t.Errorf("got script %q, want %q", script, want)
+	}
+	if org != "home" || auth != "Token secret" {
+		t.Errorf("got org %q and authorization %q", org, auth)
+	}
+}
+
+func TestInfluxQLBackendQueryRange(t *testing.T) {
+	var got url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		switch {
+		case strings.Contains(got.Get("q"), "many"):
+			_, _ = w.Write([]byte(`{"results":[{"series":[{"name":"a","values":[[1705312800000,1]]},{"name":"b","values":[[1705312800000,2]]}]}]}`))
+		case strings.Contains(got.Get("q"), "missing"):
+			_, _ = w.Write([]byte(`{"results":[{"error":"measurement not found"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"results":[{"series":[{"name":"power","columns":["time","mean"],
+				"values":[[1705312860500,2],[1705312800000,1],[1705312920000,null]]}]}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	b, err := NewInfluxQLBackend(srv.URL, "home", "", "", srv.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1705312800, 0)
+	samples, err := b.QueryRange(`SELECT mean("value") FROM "power" WHERE $timeFilter GROUP BY time($interval)`,
+		start, start.Add(time.Hour), 2*time.Minute)
+	if err != nil {
+		t.Fatalf("QueryRange failed: %v", err)
+	}
+	want := `SELECT mean("value") FROM "power" WHERE time >= 1705312800s AND time <= 1705316400s GROUP BY time(120000ms)`
+	if q := got.Get("q"); q != want {
+		t.Errorf("got query %q, want %q", q, want)
+	}
+	if got.Get("db") != "home" || got.Get("epoch") != "ms" {
+		t.Errorf("unexpected parameters %v", got)
+	}
+	if len(samples) != 2 || samples[0].Value != 1 || samples[1].Value != 2 {
+		t.Fatalf("got %+v, want the samples 1 and 2 in chronological order", samples)
+	}
+	if !samples[1].Time.Equal(time.UnixMilli(1705312860500)) {
+		t.Errorf("got time %s, want %s", samples[1].Time, time.UnixMilli(1705312860500))
+	}
+
+	for _, q := range []string{"many", "missing"} {
+		if samples, err := b.QueryRange(q, start, start.Add(time.Hour), time.Minute); err == nil {
+			t.Errorf("%s: want an error, got %+v", q, samples)
+		}
+	}
+}
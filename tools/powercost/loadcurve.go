@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// meterReading is the energy measured by the meter in the quarter-hour
+// starting at Start.
+type meterReading struct {
+	Start time.Time
+	KWh   float64
+}
+
+var (
+	// intervalHeader matches the header of a value column, e.g. "00:00-00:15"
+	// or "00.15".
+	intervalHeader = regexp.MustCompile(`^\d{1,2}[.:]\d{2}`)
+	loadCurveDate  = []string{"02/01/2006", "2006-01-02", "02-01-2006", "20060102"}
+)
+
+func parseLoadCurveDate(s string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range loadCurveDate {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(s), loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseLoadCurve parses the quarter-hour load curve ("curva di carico") CSV
+// exported by the e-distribuzione portal. It is a ";"-separated file with one
+// row per day, holding the day and 96 quarter-hourly readings in kWh with a
+// comma as decimal separator, optionally preceded by a header row and other
+// columns such as the POD.
+//
+// The i-th reading of a day starts i*15 minutes of elapsed time after
+// midnight in loc, so that the 92 and 100 quarter-hours of the DST changes map
+// to the right instants. Readings beyond the length of the day must be empty
+// or zero, and empty readings are skipped.
+func parseLoadCurve(r io.Reader, loc *time.Location) ([]meterReading, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = ';'
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	var (
+		readings []meterReading
+		// value columns according to the header, if any
+		valueColumns []int
+		line         int
+	)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		dateColumn := -1
+		var day time.Time
+		for idx, field := range record {
+			if d, ok := parseLoadCurveDate(field, loc); ok {
+				dateColumn, day = idx, d
+				break
+			}
+		}
+		if dateColumn < 0 {
+			// not a data row, look for the value columns in the header
+			var columns []int
+			for idx, field := range record {
+				if intervalHeader.MatchString(strings.TrimSpace(field)) {
+					columns = append(columns, idx)
+				}
+			}
+			if len(columns) > 0 {
+				valueColumns = columns
+			}
+			continue
+		}
+		columns := valueColumns
+		if columns == nil {
+			for idx := dateColumn + 1; idx < len(record); idx++ {
+				columns = append(columns, idx)
+			}
+		}
+
+		quarters := int(day.AddDate(0, 0, 1).Sub(day) / (15 * time.Minute))
+		if len(columns) < quarters {
+			log.Printf("Warning: line %d: %s has %d quarter-hours, got %d readings", line, day.Format("2006-01-02"), quarters, len(columns))
+		}
+		for i, idx := range columns {
+			if idx >= len(record) {
+				break
+			}
+			field := strings.TrimSpace(record[idx])
+			if field == "" {
+				continue
+			}
+			kwh, err := strconv.ParseFloat(strings.Replace(field, ",", ".", 1), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid reading '%s': %w", line, field, err)
+			}
+			if i >= quarters {
+				if kwh != 0 {
+					return nil, fmt.Errorf("line %d: %s has %d quarter-hours, got a reading for quarter-hour %d", line, day.Format("2006-01-02"), quarters, i+1)
+				}
+				continue
+			}
+			readings = append(readings, meterReading{
+				Start: day.Add(time.Duration(i) * 15 * time.Minute),
+				KWh:   kwh,
+			})
+		}
+	}
+	if len(readings) == 0 {
+		return nil, fmt.Errorf("no readings found")
+	}
+	sort.Slice(readings, func(i, j int) bool { return readings[i].Start.Before(readings[j].Start) })
+	return readings, nil
+}
+
+// costReport holds the energy and cost of a day or a month.
+type costReport struct {
+	Name string
+	KWh  float64
+	Cost float64
+	// UnpricedKWh is the energy with no market price available
+	UnpricedKWh float64
+}
+
+func (c *costReport) add(kwh, cost float64, priced bool) {
+	c.KWh += kwh
+	if priced {
+		c.Cost += cost
+	} else {
+		c.UnpricedKWh += kwh
+	}
+}
+
+func (c *costReport) print(indent string) {
+	fmt.Printf("%s%-10s: %10.3f kWh %10.3f EUR", indent, c.Name, c.KWh, c.Cost)
+	if priced := c.KWh - c.UnpricedKWh; priced > 0 {
+		fmt.Printf("  (%.2f EUR/MWh)", c.Cost/priced*1000)
+	}
+	if c.UnpricedKWh > 0 {
+		fmt.Printf("  [%.3f kWh without price]", c.UnpricedKWh)
+	}
+	fmt.Println()
+}
+
+// loadCurveSummary prints the per-day and per-month market cost of the load
+// curve in the CSV file at path, priced with the hourly or quarter-hourly
+// prices of zone. Every reading is priced with the market interval containing
+// its start. If period is not nil, only the readings starting in it are used.
+func loadCurveSummary(cfg *Config, path, zone string, period *Period) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open load curve: %w", err)
+	}
+	defer f.Close()
+	readings, err := parseLoadCurve(f, market.Location)
+	if err != nil {
+		return fmt.Errorf("cannot parse load curve '%s': %w", path, err)
+	}
+	if period != nil {
+		inPeriod := readings[:0]
+		for _, r := range readings {
+			if !r.Start.Before(period.From) && r.Start.Before(period.To) {
+				inPeriod = append(inPeriod, r)
+			}
+		}
+		if len(inPeriod) == 0 {
+			return fmt.Errorf("no readings from %s", period)
+		}
+		readings = inPeriod
+	}
+	first, last := readings[0].Start, readings[len(readings)-1].Start
+	// the intervals are matched by their absolute time
+	prices, err := punapiHourlyPrices(cfg, zone, first, last)
+	if err != nil {
+		return fmt.Errorf("cannot get prices: %w", err)
+	}
+	byStart := make(map[int64]hourlyPrice, len(prices))
+	for _, p := range prices {
+		byStart[p.Time.Unix()] = p
+	}
+
+	var (
+		days, months []*costReport
+		total        = costReport{Name: "total"}
+	)
+	for _, r := range readings {
+		start := r.Start.In(market.Location)
+		// quarter-hourly price first, then the hourly one. Italian time is
+		// always a whole number of hours away from UTC, so truncating the
+		// absolute time gives the start of the local hour.
+		p, ok := byStart[start.Unix()]
+		if !ok {
+			p, ok = byStart[start.Truncate(time.Hour).Unix()]
+		}
+		cost := r.KWh * p.Price / 1000
+		day, month := start.Format("2006-01-02"), start.Format("2006-01")
+		if len(days) == 0 || days[len(days)-1].Name != day {
+			days = append(days, &costReport{Name: day})
+		}
+		if len(months) == 0 || months[len(months)-1].Name != month {
+			months = append(months, &costReport{Name: month})
+		}
+		days[len(days)-1].add(r.KWh, cost, ok)
+		months[len(months)-1].add(r.KWh, cost, ok)
+		total.add(r.KWh, cost, ok)
+	}
+
+	fmt.Printf("## Load curve %s, %s prices\n", path, zone)
+	for _, m := range months {
+		fmt.Printf("### %s\n", m.Name)
+		for _, d := range days {
+			if strings.HasPrefix(d.Name, m.Name) {
+				d.print("    ")
+			}
+		}
+		m.print("    ")
+	}
+	if len(months) > 1 {
+		total.print("")
+	}
+	return nil
+}
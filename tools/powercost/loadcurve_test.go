@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// loadCurveRow returns a row of a load curve for day, with n readings of
+// value followed by 100-n empty ones.
+func loadCurveRow(day string, n int, value string) string {
+	fields := []string{"IT001E12345678", day}
+	for idx := 0; idx < 100; idx++ {
+		if idx < n {
+			fields = append(fields, value)
+		} else {
+			fields = append(fields, "")
+		}
+	}
+	return strings.Join(fields, ";")
+}
+
+func TestParseLoadCurve(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		data     string
+		readings int
+		first    string
+		last     string
+		kwh      float64
+	}{
+		{
+			name:     "normal day",
+			data:     loadCurveRow("15/01/2024", 96, "0,25"),
+			readings: 96,
+			first:    "2024-01-14T23:00:00Z",
+			last:     "2024-01-15T22:45:00Z",
+			kwh:      24,
+		},
+		{
+			name:     "23 hour day",
+			data:     loadCurveRow("31/03/2024", 92, "0,25"),
+			readings: 92,
+			first:    "2024-03-30T23:00:00Z",
+			last:     "2024-03-31T21:45:00Z",
+			kwh:      23,
+		},
+		{
+			name:     "23 hour day with zero padding",
+			data:     loadCurveRow("31/03/2024", 92, "0,25") + strings.Repeat(";0", 4),
+			readings: 92,
+			first:    "2024-03-30T23:00:00Z",
+			last:     "2024-03-31T21:45:00Z",
+			kwh:      23,
+		},
+		{
+			name:     "25 hour day",
+			data:     loadCurveRow("2024-10-27", 100, "0.25"),
+			readings: 100,
+			first:    "2024-10-26T22:00:00Z",
+			last:     "2024-10-27T22:45:00Z",
+			kwh:      25,
+		},
+		{
+			name:     "header and missing readings",
+			data:     "POD;Data;00:00-00:15;00:15-00:30;00:30-00:45\nIT001E12345678;20240115;1,5;;0,5\n",
+			readings: 2,
+			first:    "2024-01-14T23:00:00Z",
+			last:     "2024-01-14T23:30:00Z",
+			kwh:      2,
+		},
+		{
+			name:     "days out of order",
+			data:     loadCurveRow("16/01/2024", 1, "1") + "\n" + loadCurveRow("15/01/2024", 1, "2"),
+			readings: 2,
+			first:    "2024-01-14T23:00:00Z",
+			last:     "2024-01-15T23:00:00Z",
+			kwh:      3,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			readings, err := parseLoadCurve(strings.NewReader(tc.data), market.Location)
+			if err != nil {
+				t.Fatalf("parseLoadCurve failed: %v", err)
+			}
+			if len(readings) != tc.readings {
+				t.Fatalf("got %d readings, want %d", len(readings), tc.readings)
+			}
+			if got := readings[0].Start.UTC().Format(time.RFC3339); got != tc.first {
+				t.Errorf("first reading starts at %s, want %s", got, tc.first)
+			}
+			if got := readings[len(readings)-1].Start.UTC().Format(time.RFC3339); got != tc.last {
+				t.Errorf("last reading starts at %s, want %s", got, tc.last)
+			}
+			var kwh float64
+			for _, r := range readings {
+				kwh += r.KWh
+			}
+			if fmt.Sprintf("%.3f", kwh) != fmt.Sprintf("%.3f", tc.kwh) {
+				t.Errorf("got %.3f kWh, want %.3f", kwh, tc.kwh)
+			}
+		})
+	}
+}
+
+func TestParseLoadCurveErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+	}{
+		{"no readings", "POD;Data;00:00-00:15\n"},
+		{"invalid reading", "IT001E12345678;15/01/2024;abc\n"},
+		{"reading beyond the 23 hour day", loadCurveRow("31/03/2024", 93, "0,25")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseLoadCurve(strings.NewReader(tc.data), market.Location); err == nil {
+				t.Error("want an error")
+			}
+		})
+	}
+}
+
+func TestLoadCurveSummaryMarketDays(t *testing.T) {
+	var from, to string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, to = r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	// the 25 hour day ends at 23:45 Italian time, i.e. 22:45 UTC
+	path := filepath.Join(t.TempDir(), "curve.csv")
+	if err := os.WriteFile(path, []byte(loadCurveRow("27/10/2024", 100, "0,25")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadCurveSummary(&Config{PunAPIURL: srv.URL}, path, "PUN", nil); err != nil {
+		t.Fatalf("loadCurveSummary failed: %v", err)
+	}
+	if from != "2024-10-27" || to != "2024-10-27" {
+		t.Errorf("got prices from %s to %s, want 2024-10-27 only", from, to)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -16,9 +18,28 @@ const progname = "powercost"
 var (
 	flagPricePerKwh        = pflag.Float64P("price-per-kwh", "p", 0, "Price per kWh")
 	flagCurrency           = pflag.StringP("currency", "C", defaultCurrency, "Currency name")
-	flagTime               = pflag.StringP("time", "t", "", "Time string for the point in time the consumption is desired. Format: YYYY-MM-DD hh:mm:ss. If hh:mm:ss is omitted, use current time for today, or 23:59:00 for past days. All times are local time.")
+	flagTime               = pflag.StringP("time", "t", "", "Time string for the point in time the consumption is desired. Format: YYYY-MM-DD [hh:mm[:ss]]. If hh:mm:ss is omitted, use current time for today, or 23:59:00 for past days. All times are local time.")
 	flagCustomQuery        = pflag.StringP("custom-query", "q", "", "Use custom query instead of presets")
+	flagUnit               = pflag.StringP("unit", "u", UnitWh, "Unit of the custom query's result: Wh or kWh for the energy used in the period, W-integrated for power to integrate over the period, Wh-counter or kWh-counter for energy counters")
+	flagPeriod             = pflag.String("period", "today", "Period of the custom query ending at --time: today, or a duration like 7d or 12h")
+	flagStep               = pflag.Duration("step", time.Minute, "Interval between the power samples integrated for W-integrated queries")
+	flagFrom               = pflag.String("from", "", "Start of the period, as yyyy-mm-dd [hh:mm[:ss]] local time")
+	flagTo                 = pflag.String("to", "", "End of the period, as yyyy-mm-dd [hh:mm[:ss]] local time. A day without time is included. Default: --time")
+	flagMonth              = pflag.String("month", "", "Use the given month as period, as yyyy-mm")
+	flagLast               = pflag.String("last", "", "Use the given duration ending at --time as period, e.g. 7d or 12h")
+	flagBillingCycle       = pflag.Bool("billing-cycle", false, "Use the billing cycle containing --time up to --time as period")
+	flagBillingCycleDay    = pflag.Int("billing-cycle-day", defaultBillingCycleDay, "Day of the month the billing cycle starts on")
+	flagListen             = pflag.StringP("listen", "l", ":9107", "Address to listen to in serve mode")
+	flagInterval           = pflag.DurationP("interval", "i", 5*time.Minute, "Interval between the computations of the presets in serve mode")
+	flagMaxGap             = pflag.Duration("max-gap", 5*time.Minute, "Longest interval between two power samples that is integrated. Longer gaps count as missing data")
+	flagPresets            = pflag.StringSliceP("preset", "s", nil, "Comma-separated names of the presets to run, from the configuration file. Default: all of them. Run `powercost presets list` to list them")
 	flagPrometheusQueryURL = pflag.StringP("prometheus-host-port", "P", defaultPrometheusQueryURL.String(), "Prometheus query URL")
+	flagWeightedQuery      = pflag.StringP("weighted-query", "w", "", "Print the average price of the period (default: the month up to --time), weighted by the hourly consumption returned by this query, e.g. 'sum(increase(energy_wh_total[1h]))'")
+	flagZone               = pflag.StringP("zone", "z", defaultZone, "Price zone for the weighted average and the load curve, e.g. PUN or NORD")
+	flagLoadCurve          = pflag.StringP("load-curve", "L", "", "Print the per-day and per-month market cost of the quarter-hour load curve in this e-distribuzione CSV file")
+	flagPunAPIURL          = pflag.String("punapi-url", defaultPunAPIURL, "URL of the punapi service providing the hourly prices")
+	flagHistory            = pflag.String("history", "56d", "Past consumption the projection's daily averages by weekday are computed over")
+	flagSpread             = pflag.Float64("spread", 0, "Amount per kWh added to the zone's price in the projection and the photovoltaic report, when --price-per-kwh is not set")
 )
 
 func parseTime(s string) (*time.Time, error) {
@@ -26,37 +47,59 @@ func parseTime(s string) (*time.Time, error) {
 	if s == "" {
 		return &now, nil
 	}
-	t, err := time.Parse("2006-02-01 15:04:00", s)
+	t, hasTime, err := parseDateTime(s)
 	if err != nil {
-		t, err = time.Parse("2006-02-01", s)
-		if err != nil {
-			return nil, fmt.Errorf("wrong format, want yyyy-mm-dd [hh:mm:ss]: %w", err)
-		}
-		// if only the day is specified, also add hour, minute, second.
-		yn, mn, dn := now.Date()
-		y, m, d := t.Date()
-		if y == yn && m == mn && d == dn {
-			// if it's today, return `now`
-			return &now, nil
-		}
-		// otherwise return the last minute of that day
-		t = time.Date(y, m, d, 23, 59, 00, 0, now.Location())
+		return nil, err
+	}
+	if hasTime {
 		return &t, nil
 	}
-
-	return nil, fmt.Errorf("not implemented yet")
+	// if only the day is specified, also add hour, minute, second.
+	yn, mn, dn := now.Date()
+	y, m, d := t.Date()
+	if y == yn && m == mn && d == dn {
+		// if it's today, return `now`
+		return &now, nil
+	}
+	// otherwise return the last minute of that day
+	t = time.Date(y, m, d, 23, 59, 00, 0, now.Location())
+	return &t, nil
 }
 
-func usageSummary(cfg *Config, q string, t *time.Time, title string, pricePerKwh float64) error {
-	watts, err := promQueryAt(cfg, q, t)
+// usageSummary prints the energy and cost of the preset over period, or over
+// the preset's own period ending at t if period is nil. Presets with a fixed
+// period cannot cover any other period.
+func usageSummary(cfg *Config, p *Preset, period *Period, t *time.Time, pricePerKwh float64) error {
+	if period == nil {
+		window, err := p.Window(*t)
+		if err != nil {
+			return err
+		}
+		period = &Period{From: t.Add(-window), To: *t}
+	} else if p.FixedPeriod() {
+		return fmt.Errorf("%s queries return the energy of their own period (%s), so they cannot cover %s", p.Unit, p.Period, period)
+	}
+	energy, coverage, err := p.Energy(cfg.backend, period.From, period.To, *flagStep, *flagMaxGap)
 	if err != nil {
 		return fmt.Errorf("query failed: %w", err)
 	}
-	fmt.Printf("## %s\n", title)
-	fmt.Printf("    query: %s\n", q)
-	fmt.Printf("    usage: %d W\n", int(watts))
-	cost := float64(watts) * pricePerKwh / 1000
-	fmt.Printf("    cost : %.3f %s\n", cost, cfg.Currency)
+	kwh, err := energy.To(KiloWattHour)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("## %s\n", p.Name)
+	if p.FixedPeriod() {
+		fmt.Printf("    query : %s (%s, %s)\n", p.Query, p.Unit, p.Period)
+	} else {
+		fmt.Printf("    query : %s (%s)\n", p.Query, p.Unit)
+		fmt.Printf("    period: %s\n", period)
+	}
+	fmt.Printf("    usage : %s\n", kwh)
+	if coverage < 1 {
+		fmt.Printf("    data  : %.1f%% of the period\n", coverage*100)
+	}
+	cost := kwh.Value * pricePerKwh
+	fmt.Printf("    cost  : %.3f %s\n", cost, cfg.Currency)
 	return nil
 }
 
@@ -65,6 +108,12 @@ func getOverrides() map[string]interface{} {
 	if *flagCurrency != defaultCurrency {
 		overrides["currency"] = *flagCurrency
 	}
+	if *flagBillingCycleDay != defaultBillingCycleDay {
+		overrides["billing_cycle_day"] = *flagBillingCycleDay
+	}
+	if *flagPunAPIURL != defaultPunAPIURL {
+		overrides["punapi_url"] = *flagPunAPIURL
+	}
 	if *flagPrometheusQueryURL != defaultPrometheusQueryURL.String() {
 		promURL, err := url.Parse(*flagPrometheusQueryURL)
 		if err != nil {
@@ -83,9 +132,221 @@ func getOverrides() map[string]interface{} {
 	return overrides
 }
 
+// command is a powercost subcommand.
+type command struct {
+	// run runs the command at time t
+	run func(cfg *Config, t *time.Time) error
+	// needsPrice tells whether the command requires --price-per-kwh, unless
+	// --weighted-query or --load-curve is set
+	needsPrice bool
+	// quiet commands do not print the configuration file they loaded
+	quiet bool
+}
+
+// commands are the subcommands by name. The empty name is the default
+// command, printing the cost of the presets.
+var commands = map[string]command{
+	"":             {run: runPresets, needsPrice: true},
+	"presets list": {run: runPresetsList, quiet: true},
+	"serve":        {run: runServe, needsPrice: true},
+	"project":      {run: runProject},
+	"tariffs":      {run: runTariffs},
+	"pv":           {run: runPV},
+	"cer":          {run: runCER},
+}
+
+// commandNames returns the quoted names of the commands, sorted.
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		if name != "" {
+			names = append(names, "'"+name+"'")
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolvePeriod returns the period selected by the flags, or nil if none.
+func resolvePeriod(cfg *Config, t *time.Time) (*Period, error) {
+	opts := PeriodOptions{
+		From:            *flagFrom,
+		To:              *flagTo,
+		Month:           *flagMonth,
+		Last:            *flagLast,
+		BillingCycle:    *flagBillingCycle,
+		BillingCycleDay: cfg.BillingCycleDay,
+	}
+	period, err := opts.Resolve(*t)
+	if err != nil {
+		return nil, fmt.Errorf("invalid period: %w", err)
+	}
+	return period, nil
+}
+
+// resolvePeriodOrMonth returns the period selected by the flags, or the
+// month containing t if none.
+func resolvePeriodOrMonth(cfg *Config, t *time.Time) (*Period, error) {
+	period, err := resolvePeriod(cfg, t)
+	if err != nil || period != nil {
+		return period, err
+	}
+	opts := PeriodOptions{Month: t.Format("2006-01")}
+	if period, err = opts.Resolve(*t); err != nil {
+		return nil, fmt.Errorf("invalid period: %w", err)
+	}
+	return period, nil
+}
+
+// queryPresets returns the preset of -q, or the presets selected with
+// --preset, and whether they were given by name.
+func queryPresets(cfg *Config) ([]Preset, bool, error) {
+	if *flagCustomQuery != "" {
+		p := Preset{Name: "Custom query", Query: *flagCustomQuery, Unit: *flagUnit, Period: *flagPeriod}
+		if err := p.Validate(); err != nil {
+			return nil, false, err
+		}
+		return []Preset{p}, true, nil
+	}
+	presets, err := selectPresets(cfg.Presets, *flagPresets)
+	return presets, len(*flagPresets) > 0, err
+}
+
+func runPresetsList(cfg *Config, t *time.Time) error {
+	listPresets(cfg)
+	return nil
+}
+
+func runServe(cfg *Config, t *time.Time) error {
+	presets, err := selectPresets(cfg.Presets, *flagPresets)
+	if err != nil {
+		return err
+	}
+	return serve(cfg, presets, *flagPricePerKwh, *flagListen, *flagInterval)
+}
+
+func runProject(cfg *Config, t *time.Time) error {
+	period, err := resolvePeriodOrMonth(cfg, t)
+	if err != nil {
+		return err
+	}
+	history, err := parsePeriodDuration(*flagHistory)
+	if err != nil {
+		return fmt.Errorf("invalid history: %w", err)
+	}
+	presets, byName, err := queryPresets(cfg)
+	if err != nil {
+		return err
+	}
+	if presets, err = coveringPresets(presets, byName); err != nil {
+		return fmt.Errorf("%w. Add a preset with unit %s, %s or %s to the configuration file, or use -q with -u", err, UnitWIntegrated, UnitWhCounter, UnitKWhCounter)
+	}
+	for idx := range presets {
+		p := &presets[idx]
+		if err := projectionSummary(cfg, p, *flagZone, period, history, *flagPricePerKwh, *flagSpread); err != nil {
+			return fmt.Errorf("cannot project preset '%s': %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+func runPV(cfg *Config, t *time.Time) error {
+	period, err := resolvePeriodOrMonth(cfg, t)
+	if err != nil {
+		return err
+	}
+	if err := pvSummary(cfg, *flagZone, period, *flagPricePerKwh, *flagSpread); err != nil {
+		return fmt.Errorf("cannot get photovoltaic report: %w", err)
+	}
+	return nil
+}
+
+func runCER(cfg *Config, t *time.Time) error {
+	period, err := resolvePeriodOrMonth(cfg, t)
+	if err != nil {
+		return err
+	}
+	if err := cerSummary(cfg, period); err != nil {
+		return fmt.Errorf("cannot compute the community incentive: %w", err)
+	}
+	return nil
+}
+
+func runTariffs(cfg *Config, t *time.Time) error {
+	if *flagCustomQuery == "" {
+		return fmt.Errorf("the tariffs command requires a query returning the hourly consumption (-q)")
+	}
+	if *flagUnit != UnitWh && *flagUnit != UnitKWh {
+		return fmt.Errorf("the hourly consumption must be in %s or %s, got '%s'", UnitWh, UnitKWh, *flagUnit)
+	}
+	period, err := resolvePeriod(cfg, t)
+	if err != nil {
+		return err
+	}
+	if period == nil {
+		// default to the last 12 complete months
+		y, m, _ := t.Date()
+		to := time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+		period = &Period{From: to.AddDate(-1, 0, 0), To: to, End: to}
+	}
+	if err := tariffsSummary(cfg, *flagCustomQuery, Unit(*flagUnit), *flagZone, period); err != nil {
+		return fmt.Errorf("cannot compare tariffs: %w", err)
+	}
+	return nil
+}
+
+// runPresets prints the load curve cost, the weighted average price, or the
+// cost of the presets or of -q.
+func runPresets(cfg *Config, t *time.Time) error {
+	period, err := resolvePeriod(cfg, t)
+	if err != nil {
+		return err
+	}
+	if *flagLoadCurve != "" {
+		if err := loadCurveSummary(cfg, *flagLoadCurve, *flagZone, period); err != nil {
+			return fmt.Errorf("cannot get load curve cost: %w", err)
+		}
+		return nil
+	}
+	if *flagWeightedQuery != "" {
+		if period == nil {
+			// default to the current month
+			y, m, _ := t.Date()
+			period = &Period{From: time.Date(y, m, 1, 0, 0, 0, 0, t.Location()), To: *t}
+		}
+		if err := weightedSummary(cfg, *flagWeightedQuery, *flagZone, period); err != nil {
+			return fmt.Errorf("cannot get weighted average price: %w", err)
+		}
+		return nil
+	}
+
+	fmt.Printf("Cost per kWh: %.6f %s\n", *flagPricePerKwh, cfg.Currency)
+	presets, byName, err := queryPresets(cfg)
+	if err != nil {
+		return err
+	}
+	if period != nil {
+		if presets, err = coveringPresets(presets, byName); err != nil {
+			return err
+		}
+	}
+	for idx := range presets {
+		p := &presets[idx]
+		if err := usageSummary(cfg, p, period, t, *flagPricePerKwh); err != nil {
+			return fmt.Errorf("cannot get usage for preset '%s': %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
 func main() {
 	pflag.Parse()
-	if *flagPricePerKwh == 0 {
+	name := strings.Join(pflag.Args(), " ")
+	cmd, ok := commands[name]
+	if !ok {
+		log.Fatalf("Error: unknown command '%s', the commands are %s", name, strings.Join(commandNames(), ", "))
+	}
+	if cmd.needsPrice && *flagPricePerKwh == 0 && *flagWeightedQuery == "" && *flagLoadCurve == "" {
 		log.Fatalf("Error: price per kWh is required")
 	}
 	t, err := parseTime(*flagTime)
@@ -98,23 +359,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error: cannot load configuration: %v", err)
 	}
-	fmt.Printf("Loaded config file '%s'\n", cfg.path)
-
-	fmt.Printf("Cost per kWh: %.6f %s\n", *flagPricePerKwh, cfg.Currency)
-
-	if *flagCustomQuery != "" {
-		if err := usageSummary(cfg, *flagCustomQuery, t, "Custom query", *flagPricePerKwh); err != nil {
-			log.Fatalf("Cannot get today's usage: %v", err)
-		}
-	} else {
-		if err := usageSummary(cfg, "sum(tapo_plug_power_usage_today)", t, "Today", *flagPricePerKwh); err != nil {
-			log.Fatalf("Cannot get today's usage: %v", err)
-		}
-		if err := usageSummary(cfg, "sum(tapo_plug_power_usage_past7)", t, "Past 7 days", *flagPricePerKwh); err != nil {
-			log.Fatalf("Cannot get past 7 days usage: %v", err)
-		}
-		if err := usageSummary(cfg, "sum(tapo_plug_power_usage_past30)", t, "Past 30 days", *flagPricePerKwh); err != nil {
-			log.Fatalf("Cannot get past 30 days usage: %v", err)
-		}
+	if !cmd.quiet {
+		fmt.Printf("Loaded config file '%s'\n", cfg.path)
+	}
+	if err := cmd.run(cfg, t); err != nil {
+		log.Fatalf("Error: %v", err)
 	}
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Period is the window the energy and cost are computed over, from From
+// (included) to To (excluded).
+type Period struct {
+	From, To time.Time
+	// End is the end of the selected period, which is after To when the
+	// period was cut at the requested time, e.g. for the current month
+	End time.Time
+}
+
+func (p *Period) String() string {
+	return fmt.Sprintf("%s to %s", p.From.Format("2006-01-02 15:04"), p.To.Format("2006-01-02 15:04"))
+}
+
+// PeriodOptions are the ways to select a period. At most one of them can be
+// set, with From and To counting as one.
+type PeriodOptions struct {
+	From, To     string
+	Month        string
+	Last         string
+	BillingCycle bool
+	// BillingCycleDay is the day of the month the billing cycle starts on
+	BillingCycleDay int
+}
+
+// parseDateTime parses a local time as yyyy-mm-dd, yyyy-mm-dd hh:mm or
+// yyyy-mm-dd hh:mm:ss. It returns whether the time part was given.
+func parseDateTime(s string) (time.Time, bool, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true, nil
+		}
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("wrong format '%s', want yyyy-mm-dd [hh:mm[:ss]]", s)
+	}
+	return t, false, nil
+}
+
+// cycleStartIn returns the start of the billing cycle starting in the given
+// month. The cycle starts on the given day of the month, or on the last day of
+// the months that are too short.
+func cycleStartIn(y int, m time.Month, day int, loc *time.Location) time.Time {
+	last := time.Date(y, m+1, 0, 0, 0, 0, 0, loc).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(y, m, day, 0, 0, 0, 0, loc)
+}
+
+// billingCycleStart returns the start of the billing cycle containing t.
+func billingCycleStart(t time.Time, day int) time.Time {
+	y, m, _ := t.Date()
+	if s := cycleStartIn(y, m, day, t.Location()); !s.After(t) {
+		return s
+	}
+	return cycleStartIn(y, m-1, day, t.Location())
+}
+
+// Resolve returns the period selected by the options, ending no later than
+// now, or nil if no period was selected.
+func (o *PeriodOptions) Resolve(now time.Time) (*Period, error) {
+	selected := 0
+	for _, set := range []bool{o.From != "" || o.To != "", o.Month != "", o.Last != "", o.BillingCycle} {
+		if set {
+			selected++
+		}
+	}
+	if selected == 0 {
+		return nil, nil
+	}
+	if selected > 1 {
+		return nil, fmt.Errorf("only one of --from/--to, --month, --last and --billing-cycle can be used")
+	}
+
+	var p Period
+	switch {
+	case o.From != "" || o.To != "":
+		if o.From == "" {
+			return nil, fmt.Errorf("--to requires --from")
+		}
+		from, _, err := parseDateTime(o.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --from: %w", err)
+		}
+		p = Period{From: from, To: now}
+		if o.To != "" {
+			to, hasTime, err := parseDateTime(o.To)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --to: %w", err)
+			}
+			// a day without time includes the whole day
+			if !hasTime {
+				to = to.AddDate(0, 0, 1)
+			}
+			p.To = to
+		}
+	case o.Month != "":
+		month, err := time.ParseInLocation("2006-01", o.Month, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --month '%s', want yyyy-mm", o.Month)
+		}
+		p = Period{From: month, To: month.AddDate(0, 1, 0)}
+	case o.Last != "":
+		d, err := parsePeriodDuration(o.Last)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --last: %w", err)
+		}
+		p = Period{From: now.Add(-d), To: now}
+	case o.BillingCycle:
+		if o.BillingCycleDay < 1 || o.BillingCycleDay > 31 {
+			return nil, fmt.Errorf("billing cycle day must be between 1 and 31, got %d", o.BillingCycleDay)
+		}
+		start := billingCycleStart(now, o.BillingCycleDay)
+		p = Period{From: start, To: cycleStartIn(start.Year(), start.Month()+1, o.BillingCycleDay, start.Location())}
+	}
+
+	if p.From.After(now) {
+		return nil, fmt.Errorf("period starts in the future: %s", p.From.Format("2006-01-02 15:04"))
+	}
+	p.End = p.To
+	if p.To.After(now) {
+		p.To = now
+	}
+	if !p.To.After(p.From) {
+		return nil, fmt.Errorf("empty period %s", &p)
+	}
+	return &p, nil
+}
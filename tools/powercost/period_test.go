@@ -0,0 +1,183 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDateTime(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    time.Time
+		hasTime bool
+		ok      bool
+	}{
+		{"2024-01-15", time.Date(2024, time.January, 15, 0, 0, 0, 0, time.Local), false, true},
+		{"2024-01-15 13:45", time.Date(2024, time.January, 15, 13, 45, 0, 0, time.Local), true, true},
+		{"2024-01-15 13:45:30", time.Date(2024, time.January, 15, 13, 45, 30, 0, time.Local), true, true},
+		// month and day are not swapped
+		{"2024-02-01", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.Local), false, true},
+		{"2024-13-01", time.Time{}, false, false},
+		{"15/01/2024", time.Time{}, false, false},
+		{"2024-01-15T13:45", time.Time{}, false, false},
+		{"2024-01-15 13", time.Time{}, false, false},
+		{"", time.Time{}, false, false},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			got, hasTime, err := parseDateTime(tc.in)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDateTime failed: %v", err)
+			}
+			if !got.Equal(tc.want) || hasTime != tc.hasTime {
+				t.Errorf("got %s and %v, want %s and %v", got, hasTime, tc.want, tc.hasTime)
+			}
+		})
+	}
+}
+
+func TestBillingCycleStart(t *testing.T) {
+	date := func(y int, m time.Month, d, h int) time.Time {
+		return time.Date(y, m, d, h, 0, 0, 0, time.Local)
+	}
+	for _, tc := range []struct {
+		name string
+		t    time.Time
+		day  int
+		want time.Time
+	}{
+		{"first of the month", date(2024, time.March, 15, 12), 1, date(2024, time.March, 1, 0)},
+		{"after the start day", date(2024, time.March, 20, 12), 15, date(2024, time.March, 15, 0)},
+		{"on the start day", date(2024, time.March, 15, 0), 15, date(2024, time.March, 15, 0)},
+		{"before the start day", date(2024, time.March, 10, 12), 15, date(2024, time.February, 15, 0)},
+		{"previous year", date(2024, time.January, 10, 12), 15, date(2023, time.December, 15, 0)},
+		{"29 in a leap February", date(2024, time.March, 10, 12), 29, date(2024, time.February, 29, 0)},
+		{"29 in February", date(2023, time.March, 10, 12), 29, date(2023, time.February, 28, 0)},
+		{"30 in February", date(2024, time.March, 10, 12), 30, date(2024, time.February, 29, 0)},
+		{"31 in February", date(2023, time.March, 30, 12), 31, date(2023, time.February, 28, 0)},
+		{"31 on the last day of February", date(2023, time.February, 28, 12), 31, date(2023, time.February, 28, 0)},
+		{"31 before the last day of February", date(2023, time.February, 27, 12), 31, date(2023, time.January, 31, 0)},
+		{"31 in April", date(2024, time.April, 30, 12), 31, date(2024, time.April, 30, 0)},
+		{"31 before the last day of April", date(2024, time.April, 29, 12), 31, date(2024, time.March, 31, 0)},
+		{"31 at the start of May", date(2024, time.May, 1, 12), 31, date(2024, time.April, 30, 0)},
+		{"31 in a long month", date(2024, time.May, 31, 12), 31, date(2024, time.May, 31, 0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := billingCycleStart(tc.t, tc.day); !got.Equal(tc.want) {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPeriodOptionsResolve(t *testing.T) {
+	now := time.Date(2024, time.March, 20, 12, 30, 0, 0, time.Local)
+	date := func(m time.Month, d, h, min int) time.Time {
+		return time.Date(2024, m, d, h, min, 0, 0, time.Local)
+	}
+	for _, tc := range []struct {
+		name     string
+		opts     PeriodOptions
+		from, to time.Time
+		// end is to if zero
+		end  time.Time
+		none bool
+		ok   bool
+	}{
+		{name: "nothing selected", none: true, ok: true},
+		{name: "from", opts: PeriodOptions{From: "2024-03-01"}, from: date(time.March, 1, 0, 0), to: now, ok: true},
+		{
+			name: "from and to days",
+			opts: PeriodOptions{From: "2024-03-01", To: "2024-03-10"},
+			from: date(time.March, 1, 0, 0),
+			to:   date(time.March, 11, 0, 0),
+			ok:   true,
+		},
+		{
+			name: "from and to times",
+			opts: PeriodOptions{From: "2024-03-01 08:00", To: "2024-03-10 18:15:00"},
+			from: date(time.March, 1, 8, 0),
+			to:   date(time.March, 10, 18, 15),
+			ok:   true,
+		},
+		{
+			name: "to in the future",
+			opts: PeriodOptions{From: "2024-03-01", To: "2024-04-01"},
+			from: date(time.March, 1, 0, 0),
+			to:   now,
+			end:  date(time.April, 2, 0, 0),
+			ok:   true,
+		},
+		{name: "month", opts: PeriodOptions{Month: "2024-02"}, from: date(time.February, 1, 0, 0), to: date(time.March, 1, 0, 0), ok: true},
+		{name: "current month", opts: PeriodOptions{Month: "2024-03"}, from: date(time.March, 1, 0, 0), to: now, end: date(time.April, 1, 0, 0), ok: true},
+		{name: "last", opts: PeriodOptions{Last: "7d"}, from: now.AddDate(0, 0, -7), to: now, ok: true},
+		{name: "last hours", opts: PeriodOptions{Last: "12h"}, from: now.Add(-12 * time.Hour), to: now, ok: true},
+		{
+			name: "billing cycle",
+			opts: PeriodOptions{BillingCycle: true, BillingCycleDay: 25},
+			from: date(time.February, 25, 0, 0),
+			to:   now,
+			end:  date(time.March, 25, 0, 0),
+			ok:   true,
+		},
+		{
+			name: "billing cycle from a short month",
+			opts: PeriodOptions{BillingCycle: true, BillingCycleDay: 31},
+			from: date(time.February, 29, 0, 0),
+			to:   now,
+			end:  date(time.March, 31, 0, 0),
+			ok:   true,
+		},
+		{name: "to without from", opts: PeriodOptions{To: "2024-03-10"}},
+		{name: "invalid from", opts: PeriodOptions{From: "yesterday"}},
+		{name: "invalid to", opts: PeriodOptions{From: "2024-03-01", To: "2024/03/10"}},
+		{name: "to before from", opts: PeriodOptions{From: "2024-03-10", To: "2024-03-01"}},
+		{name: "same time", opts: PeriodOptions{From: "2024-03-10 10:00", To: "2024-03-10 10:00"}},
+		{name: "future from", opts: PeriodOptions{From: "2024-04-01"}},
+		{name: "invalid month", opts: PeriodOptions{Month: "2024-13"}},
+		{name: "month with a day", opts: PeriodOptions{Month: "2024-02-01"}},
+		{name: "future month", opts: PeriodOptions{Month: "2024-04"}},
+		{name: "invalid last", opts: PeriodOptions{Last: "a week"}},
+		{name: "billing cycle day 0", opts: PeriodOptions{BillingCycle: true}},
+		{name: "billing cycle day 32", opts: PeriodOptions{BillingCycle: true, BillingCycleDay: 32}},
+		{name: "month and last", opts: PeriodOptions{Month: "2024-02", Last: "7d"}},
+		{name: "from and billing cycle", opts: PeriodOptions{From: "2024-03-01", BillingCycle: true, BillingCycleDay: 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := tc.opts.Resolve(now)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got %v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve failed: %v", err)
+			}
+			if tc.none {
+				if p != nil {
+					t.Errorf("got %s, want no period", p)
+				}
+				return
+			}
+			if p == nil {
+				t.Fatal("got no period")
+			}
+			if !p.From.Equal(tc.from) || !p.To.Equal(tc.to) {
+				t.Errorf("got %s, want %s to %s", p, tc.from, tc.to)
+			}
+			end := tc.end
+			if end.IsZero() {
+				end = tc.to
+			}
+			if !p.End.Equal(end) {
+				t.Errorf("got end %s, want %s", p.End, end)
+			}
+		})
+	}
+}
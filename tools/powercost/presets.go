@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Units of the value returned by a preset's query.
+const (
+	// UnitWh and UnitKWh are energy over the preset's period, e.g. Tapo's
+	// tapo_plug_power_usage_today
+	UnitWh  = "Wh"
+	UnitKWh = "kWh"
+	// UnitWIntegrated is instantaneous power in W, integrated over the
+	// preset's period
+	UnitWIntegrated = "W-integrated"
+	// UnitWhCounter and UnitKWhCounter are cumulative energy counters, whose
+	// increase over the preset's period is the energy used. With PromQL
+	// backends their query must be a series selector, e.g.
+	// energy_wh_total{device="boiler"}
+	UnitWhCounter  = "Wh-counter"
+	UnitKWhCounter = "kWh-counter"
+)
+
+// Preset is a named query for the energy used over a period.
+type Preset struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	Unit  string `json:"unit"`
+	// Period is "today", for the time since midnight, or a duration ending
+	// at the requested time, like "7d" or "12h"
+	Period string `json:"period"`
+	// Device is the device label of the preset's metrics in serve mode,
+	// "all" if empty
+	Device string `json:"device,omitempty"`
+}
+
+// defaultPresets are the metrics exported by
+// insomniacslk/prometheus-tapo-exporter.
+var defaultPresets = []Preset{
+	{Name: "today", Query: "sum(tapo_plug_power_usage_today)", Unit: UnitWh, Period: "today"},
+	{Name: "past7", Query: "sum(tapo_plug_power_usage_past7)", Unit: UnitWh, Period: "7d"},
+	{Name: "past30", Query: "sum(tapo_plug_power_usage_past30)", Unit: UnitWh, Period: "30d"},
+}
+
+// parsePeriodDuration parses a duration like time.ParseDuration, also
+// accepting a number of days, e.g. "7d".
+func parsePeriodDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of days '%s'", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
+	}
+	return d, nil
+}
+
+// Window returns the length of the preset's period ending at t.
+func (p *Preset) Window(t time.Time) (time.Duration, error) {
+	if p.Period == "today" {
+		y, m, d := t.Date()
+		return t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location())), nil
+	}
+	return parsePeriodDuration(p.Period)
+}
+
+// Validate checks the preset's fields.
+func (p *Preset) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("preset name cannot be empty")
+	}
+	if p.Query == "" {
+		return fmt.Errorf("preset '%s': query cannot be empty", p.Name)
+	}
+	switch p.Unit {
+	case UnitWh, UnitKWh, UnitWIntegrated, UnitWhCounter, UnitKWhCounter:
+	default:
+		return fmt.Errorf("preset '%s': unit must be one of %s, %s, %s, %s, %s, got '%s'", p.Name, UnitWh, UnitKWh, UnitWIntegrated, UnitWhCounter, UnitKWhCounter, p.Unit)
+	}
+	if _, err := p.Window(time.Now()); err != nil {
+		return fmt.Errorf("preset '%s': invalid period '%s': %w", p.Name, p.Period, err)
+	}
+	return nil
+}
+
+// FixedPeriod tells whether the preset's query returns the energy of its own
+// period, like Tapo's metrics, so that it cannot be computed over any other
+// period.
+func (p *Preset) FixedPeriod() bool {
+	return p.Unit == UnitWh || p.Unit == UnitKWh
+}
+
+// Energy returns the energy used from `from` to `to`. Presets with a fixed
+// period are evaluated at `to`. Power is integrated with samples every step,
+// ignoring gaps longer than maxGap, and the returned coverage is the fraction
+// of the period with samples. For the other units the coverage is always 1.
+func (p *Preset) Energy(b Backend, from, to time.Time, step, maxGap time.Duration) (Quantity, float64, error) {
+	switch p.Unit {
+	case UnitWh:
+		value, err := b.QueryAt(p.Query, to)
+		return Quantity{Value: value, Unit: WattHour}, 1, err
+	case UnitKWh:
+		value, err := b.QueryAt(p.Query, to)
+		return Quantity{Value: value, Unit: KiloWattHour}, 1, err
+	case UnitWhCounter:
+		value, err := b.Increase(p.Query, from, to)
+		return Quantity{Value: value, Unit: WattHour}, 1, err
+	case UnitKWhCounter:
+		value, err := b.Increase(p.Query, from, to)
+		return Quantity{Value: value, Unit: KiloWattHour}, 1, err
+	}
+	return integratePower(b, p.Query, from, to, step, maxGap)
+}
+
+// selectPresets returns the presets with the given names, in the given order,
+// or all of them if names is empty.
+func selectPresets(presets []Preset, names []string) ([]Preset, error) {
+	if len(names) == 0 {
+		return presets, nil
+	}
+	selected := make([]Preset, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, p := range presets {
+			if p.Name == name {
+				selected = append(selected, p)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown preset '%s'", name)
+		}
+	}
+	return selected, nil
+}
+
+// coveringPresets returns the presets that can compute the energy of any
+// period, i.e. those without a fixed period. If the presets were selected by
+// name, every one of them must be able to.
+func coveringPresets(presets []Preset, byName bool) ([]Preset, error) {
+	covering := make([]Preset, 0, len(presets))
+	for _, p := range presets {
+		if !p.FixedPeriod() {
+			covering = append(covering, p)
+		} else if byName {
+			return nil, fmt.Errorf("preset '%s' cannot cover a selected period: %s queries return the energy of their own period (%s)", p.Name, p.Unit, p.Period)
+		}
+	}
+	if len(covering) == 0 {
+		return nil, fmt.Errorf("no preset can cover a selected period: %s and %s queries return the energy of their own period", UnitWh, UnitKWh)
+	}
+	return covering, nil
+}
+
+// listPresets prints the configured presets.
+func listPresets(cfg *Config) {
+	for _, p := range cfg.Presets {
+		fmt.Printf("%s\n", p.Name)
+		fmt.Printf("    query : %s\n", p.Query)
+		fmt.Printf("    unit  : %s\n", p.Unit)
+		fmt.Printf("    period: %s\n", p.Period)
+		if p.Device != "" {
+			fmt.Printf("    device: %s\n", p.Device)
+		}
+	}
+}
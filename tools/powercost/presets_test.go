@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePeriodDuration(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"7d", 7 * 24 * time.Hour, true},
+		{"12h", 12 * time.Hour, true},
+		{"90m", 90 * time.Minute, true},
+		{"0d", 0, false},
+		{"-1d", 0, false},
+		{"xd", 0, false},
+		{"0s", 0, false},
+		{"-1h", 0, false},
+		{"week", 0, false},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := parsePeriodDuration(tc.in)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got %s", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("got %s and %v, want %s", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestPresetValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		preset Preset
+		errMsg string
+	}{
+		{name: "energy today", preset: Preset{Name: "a", Query: "q", Unit: UnitWh, Period: "today"}},
+		{name: "energy in kWh over days", preset: Preset{Name: "a", Query: "q", Unit: UnitKWh, Period: "30d"}},
+		{name: "integrated power", preset: Preset{Name: "a", Query: "q", Unit: UnitWIntegrated, Period: "12h"}},
+		{name: "energy counter", preset: Preset{Name: "a", Query: "q", Unit: UnitWhCounter, Period: "today"}},
+		{name: "energy counter in kWh", preset: Preset{Name: "a", Query: "q", Unit: UnitKWhCounter, Period: "7d"}},
+		{name: "no name", preset: Preset{Query: "q", Unit: UnitWh, Period: "today"}, errMsg: "name"},
+		{name: "no query", preset: Preset{Name: "a", Unit: UnitWh, Period: "today"}, errMsg: "query"},
+		{name: "no unit", preset: Preset{Name: "a", Query: "q", Period: "today"}, errMsg: "unit"},
+		{name: "unknown unit", preset: Preset{Name: "a", Query: "q", Unit: "W", Period: "today"}, errMsg: "unit"},
+		{name: "unit in the wrong case", preset: Preset{Name: "a", Query: "q", Unit: "kwh", Period: "today"}, errMsg: "unit"},
+		{name: "no period", preset: Preset{Name: "a", Query: "q", Unit: UnitWh}, errMsg: "period"},
+		{name: "energy over an invalid period", preset: Preset{Name: "a", Query: "q", Unit: UnitWh, Period: "yesterday"}, errMsg: "period"},
+		{name: "power over no days", preset: Preset{Name: "a", Query: "q", Unit: UnitWIntegrated, Period: "0d"}, errMsg: "period"},
+		{name: "counter over an invalid period", preset: Preset{Name: "a", Query: "q", Unit: UnitKWhCounter, Period: "1w"}, errMsg: "period"},
+		{name: "power over a negative period", preset: Preset{Name: "a", Query: "q", Unit: UnitWIntegrated, Period: "-1h"}, errMsg: "period"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.preset.Validate()
+			if tc.errMsg == "" {
+				if err != nil {
+					t.Errorf("Validate failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("got %v, want an error about the %s", err, tc.errMsg)
+			}
+		})
+	}
+	for _, p := range defaultPresets {
+		if err := p.Validate(); err != nil {
+			t.Errorf("default preset: %v", err)
+		}
+	}
+}
+
+func TestSelectPresets(t *testing.T) {
+	presets := []Preset{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	for _, tc := range []struct {
+		name  string
+		names []string
+		want  string
+		ok    bool
+	}{
+		{name: "all", want: "abc", ok: true},
+		{name: "given order", names: []string{"c", "a"}, want: "ca", ok: true},
+		{name: "repeated", names: []string{"b", "b"}, want: "bb", ok: true},
+		{name: "unknown", names: []string{"a", "d"}},
+		{name: "wrong case", names: []string{"A"}},
+		{name: "empty name", names: []string{""}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			selected, err := selectPresets(presets, tc.names)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got %d presets", len(selected))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectPresets failed: %v", err)
+			}
+			var got string
+			for _, p := range selected {
+				got += p.Name
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCoveringPresets(t *testing.T) {
+	fixed := Preset{Name: "today", Unit: UnitWh, Period: "today"}
+	power := Preset{Name: "boiler", Unit: UnitWIntegrated, Period: "1d"}
+	counter := Preset{Name: "meter", Unit: UnitKWhCounter, Period: "7d"}
+	for _, tc := range []struct {
+		name    string
+		presets []Preset
+		byName  bool
+		want    string
+		ok      bool
+	}{
+		{name: "all covering", presets: []Preset{power, counter}, want: "boilermeter", ok: true},
+		{name: "fixed ones left out", presets: []Preset{fixed, power}, want: "boiler", ok: true},
+		{name: "only fixed ones", presets: []Preset{fixed}},
+		{name: "no presets"},
+		{name: "fixed one selected by name", presets: []Preset{power, fixed}, byName: true},
+		{name: "selected by name", presets: []Preset{counter}, byName: true, want: "meter", ok: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			covering, err := coveringPresets(tc.presets, tc.byName)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got %d presets", len(covering))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("coveringPresets failed: %v", err)
+			}
+			var got string
+			for _, p := range covering {
+				got += p.Name
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
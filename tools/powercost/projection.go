@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// projectionZ is the number of standard deviations of the 90% uncertainty
+// band of the projection.
+const projectionZ = 1.645
+
+// minHistoryCoverage is the fraction of a past day that must have samples for
+// the day to be used in the consumption statistics.
+const minHistoryCoverage = 0.9
+
+// meanStd returns the mean and the sample standard deviation of values.
+func meanStd(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)-1))
+}
+
+// startOfDay returns the local midnight starting the day containing t.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// estimate is a forecast value with its standard deviation.
+type estimate struct {
+	mean, std float64
+}
+
+// dayPrices holds the hourly and daily average prices of a zone in EUR/kWh,
+// or a fixed price.
+type dayPrices struct {
+	fixed  bool
+	byHour map[int64]float64
+	byDay  map[int64]float64
+	period estimate
+}
+
+// newDayPrices returns the hourly and daily average prices of zone from
+// punapi for the days of period, plus spread, or pricePerKwh for every hour
+// if not zero. Unknown prices are estimated with the average of the daily
+// prices of the period up to its To.
+func newDayPrices(cfg *Config, zone string, period *Period, pricePerKwh, spread float64) (*dayPrices, error) {
+	if pricePerKwh != 0 {
+		return &dayPrices{fixed: true, period: estimate{mean: pricePerKwh}}, nil
+	}
+	prices, err := punapiHourlyPrices(cfg, zone, period.From, period.End.Add(-time.Nanosecond))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get hourly prices: %w", err)
+	}
+	// average the quarter-hourly prices of every hour, and every day's prices
+	// weighted by the length of their interval
+	hourSums := make(map[int64]float64)
+	hourMinutes := make(map[int64]int)
+	daySums := make(map[int64]float64)
+	dayMinutes := make(map[int64]int)
+	for _, p := range prices {
+		hour := p.Time.Truncate(time.Hour).Unix()
+		hourSums[hour] += p.Price * float64(p.Minutes)
+		hourMinutes[hour] += p.Minutes
+		day := startOfDay(p.Time.In(period.From.Location())).Unix()
+		daySums[day] += p.Price * float64(p.Minutes)
+		dayMinutes[day] += p.Minutes
+	}
+	dp := dayPrices{
+		byHour: make(map[int64]float64, len(hourSums)),
+		byDay:  make(map[int64]float64, len(daySums)),
+	}
+	for hour, sum := range hourSums {
+		dp.byHour[hour] = sum/float64(hourMinutes[hour])/1000 + spread
+	}
+	var toDate []float64
+	for day, sum := range daySums {
+		price := sum/float64(dayMinutes[day])/1000 + spread
+		dp.byDay[day] = price
+		if !time.Unix(day, 0).After(period.To) {
+			toDate = append(toDate, price)
+		}
+	}
+	if len(toDate) == 0 {
+		return nil, fmt.Errorf("no %s prices from %s to %s", zone, period.From.Format("2006-01-02"), period.To.Format("2006-01-02"))
+	}
+	dp.period.mean, dp.period.std = meanStd(toDate)
+	return &dp, nil
+}
+
+// get returns the average price of the day starting at day, and whether it is
+// known.
+func (dp *dayPrices) get(day time.Time) (estimate, bool) {
+	if dp.fixed {
+		return dp.period, true
+	}
+	if price, ok := dp.byDay[day.Unix()]; ok {
+		return estimate{mean: price}, true
+	}
+	return dp.period, false
+}
+
+// hour returns the price of the hour starting at hour, and whether it is
+// known.
+func (dp *dayPrices) hour(hour time.Time) (float64, bool) {
+	if dp.fixed {
+		return dp.period.mean, true
+	}
+	if price, ok := dp.byHour[hour.Unix()]; ok {
+		return price, true
+	}
+	return dp.period.mean, false
+}
+
+// weekdayConsumption returns the mean and standard deviation of the daily
+// consumption in kWh of every weekday, over the complete days in the history
+// before the day containing now. Weekdays with less than two days use the
+// statistics of all the days.
+func weekdayConsumption(cfg *Config, p *Preset, now time.Time, history time.Duration) ([7]estimate, error) {
+	var (
+		byWeekday [7][]float64
+		all       []float64
+		stats     [7]estimate
+	)
+	today := startOfDay(now)
+	days := int(history / (24 * time.Hour))
+	if days < 1 {
+		days = 1
+	}
+	for day := today.AddDate(0, 0, -days); day.Before(today); day = day.AddDate(0, 0, 1) {
+		energy, coverage, err := p.Energy(cfg.backend, day, day.AddDate(0, 0, 1), *flagStep, *flagMaxGap)
+		if err != nil {
+			log.Printf("Skipping %s in the consumption history: %v", day.Format("2006-01-02"), err)
+			continue
+		}
+		if coverage < minHistoryCoverage {
+			continue
+		}
+		kwh, err := energy.To(KiloWattHour)
+		if err != nil {
+			return stats, err
+		}
+		byWeekday[day.Weekday()] = append(byWeekday[day.Weekday()], kwh.Value)
+		all = append(all, kwh.Value)
+	}
+	if len(all) == 0 {
+		return stats, fmt.Errorf("no complete day of consumption in the last %d days", days)
+	}
+	var overall estimate
+	overall.mean, overall.std = meanStd(all)
+	for wd := range byWeekday {
+		if len(byWeekday[wd]) < 2 {
+			stats[wd] = overall
+			continue
+		}
+		stats[wd].mean, stats[wd].std = meanStd(byWeekday[wd])
+	}
+	return stats, nil
+}
+
+// projectionSummary prints the energy and cost of the preset over period up
+// to its To, priced hour by hour, and their projection up to its End. The
+// rest of the period is forecast with the preset's average daily consumption
+// of every weekday over the history, spread evenly over the day, priced with
+// the average day-ahead prices of zone or, when not yet published, with the
+// average price of the period so far. Hours to date without a price are also
+// priced with that average. The price of a kWh is the zone's price plus
+// spread, or pricePerKwh if not zero. The uncertainty band assumes that the
+// days are independent.
+func projectionSummary(cfg *Config, p *Preset, zone string, period *Period, history time.Duration, pricePerKwh, spread float64) error {
+	if p.FixedPeriod() {
+		return fmt.Errorf("%s queries return the energy of their own period (%s), so they cannot be projected", p.Unit, p.Period)
+	}
+	fmt.Printf("## %s\n", p.Name)
+	prices, err := newDayPrices(cfg, zone, period, pricePerKwh, spread)
+	if err != nil {
+		return err
+	}
+	consumption, err := weekdayConsumption(cfg, p, period.To, history)
+	if err != nil {
+		return err
+	}
+
+	// energy and cost up to To, priced hour by hour
+	hourly, coverage, err := hourlyEnergyCoverage(cfg.backend, p, period.From, period.To, *flagStep, *flagMaxGap)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	var (
+		toDateKwh, toDateCost float64
+		unpriced              int
+	)
+	for hour := period.From.Truncate(time.Hour); hour.Before(period.To); hour = hour.Add(time.Hour) {
+		kwh := hourly[hour.Unix()]
+		price, known := prices.hour(hour)
+		if !known && kwh > 0 {
+			unpriced++
+		}
+		toDateKwh += kwh
+		toDateCost += kwh * price
+	}
+
+	// forecast of the rest of the period
+	var (
+		restKwh, restCost, kwhVar, costVar float64
+		restDays, knownDays                int
+	)
+	for day := startOfDay(period.To); day.Before(period.End); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		from, to := day, next
+		if from.Before(period.To) {
+			from = period.To
+		}
+		if to.After(period.End) {
+			to = period.End
+		}
+		if !to.After(from) {
+			continue
+		}
+		share := to.Sub(from).Seconds() / next.Sub(day).Seconds()
+		energy := estimate{
+			mean: consumption[day.Weekday()].mean * share,
+			std:  consumption[day.Weekday()].std * share,
+		}
+		price, known := prices.get(day)
+		restDays++
+		if known {
+			knownDays++
+		}
+		restKwh += energy.mean
+		restCost += energy.mean * price.mean
+		kwhVar += energy.std * energy.std
+		// variance of the product of independent variables
+		costVar += energy.mean*energy.mean*price.std*price.std +
+			price.mean*price.mean*energy.std*energy.std +
+			energy.std*energy.std*price.std*price.std
+	}
+
+	kwhBand, costBand := projectionZ*math.Sqrt(kwhVar), projectionZ*math.Sqrt(costVar)
+	totalKwh, totalCost := toDateKwh+restKwh, toDateCost+restCost
+	fmt.Printf("    query    : %s (%s)\n", p.Query, p.Unit)
+	fmt.Printf("    period   : %s to %s\n", period.From.Format("2006-01-02 15:04"), period.End.Format("2006-01-02 15:04"))
+	fmt.Printf("    to date  : %.3f kWh, %.3f %s (up to %s)\n", toDateKwh, toDateCost, cfg.Currency, period.To.Format("2006-01-02 15:04"))
+	if coverage < 1 {
+		fmt.Printf("    data     : %.1f%% of the period to date\n", coverage*100)
+	}
+	if !prices.fixed {
+		fmt.Printf("    price    : %.6f %s/kWh average to date (%s)\n", prices.period.mean, cfg.Currency, zone)
+	}
+	if unpriced > 0 {
+		fmt.Printf("    unpriced : %d hours to date without a %s price, priced at the average\n", unpriced, zone)
+	}
+	fmt.Printf("    remaining: %.3f kWh, %.3f %s (%d days, %d with known prices)\n", restKwh, restCost, cfg.Currency, restDays, knownDays)
+	fmt.Printf("    projected: %.3f kWh, %.3f %s\n", totalKwh, totalCost, cfg.Currency)
+	fmt.Printf("    90%% band : %.3f-%.3f kWh, %.3f-%.3f %s\n",
+		math.Max(toDateKwh, totalKwh-kwhBand), totalKwh+kwhBand,
+		math.Max(toDateCost, totalCost-costBand), totalCost+costBand, cfg.Currency)
+	return nil
+}
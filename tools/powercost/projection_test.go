@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+func TestMeanStd(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		values    []float64
+		mean, std float64
+	}{
+		{"no values", nil, 0, 0},
+		{"one value", []float64{3}, 3, 0},
+		{"constant", []float64{2, 2, 2}, 2, 0},
+		{"sample deviation", []float64{2, 4, 4, 4, 5, 5, 7, 9}, 5, math.Sqrt(32.0 / 7)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mean, std := meanStd(tc.values)
+			if math.Abs(mean-tc.mean) > 1e-9 || math.Abs(std-tc.std) > 1e-9 {
+				t.Errorf("got %v and %v, want %v and %v", mean, std, tc.mean, tc.std)
+			}
+		})
+	}
+}
+
+func TestStartOfDay(t *testing.T) {
+	got := startOfDay(time.Date(2024, time.January, 15, 13, 45, 10, 5, time.Local))
+	if want := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDayPrices(t *testing.T) {
+	day := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.Local)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// punapi is asked for market days
+		if r.URL.Query().Get("from") != day.In(market.Location).Format("2006-01-02") {
+			_, _ = w.Write([]byte("[]"))
+			return
+		}
+		// an hourly price, then four quarter-hours
+		_ = json.NewEncoder(w).Encode([]hourlyPrice{
+			{Time: day, Minutes: 60, Price: 100},
+			{Time: day.Add(time.Hour), Minutes: 15, Price: 200},
+			{Time: day.Add(time.Hour + 15*time.Minute), Minutes: 15, Price: 200},
+			{Time: day.Add(time.Hour + 30*time.Minute), Minutes: 15, Price: 300},
+			{Time: day.Add(time.Hour + 45*time.Minute), Minutes: 15, Price: 300},
+		})
+	}))
+	defer srv.Close()
+
+	cfg := &Config{PunAPIURL: srv.URL}
+	period := &Period{From: day, To: day.Add(2 * time.Hour), End: day.AddDate(0, 0, 2)}
+	prices, err := newDayPrices(cfg, "PUN", period, 0, 0.01)
+	if err != nil {
+		t.Fatalf("newDayPrices failed: %v", err)
+	}
+	for _, tc := range []struct {
+		hour  time.Time
+		want  float64
+		known bool
+	}{
+		{day, 0.11, true},
+		// the average of the quarter-hours
+		{day.Add(time.Hour), 0.26, true},
+		// unknown hours use the average of the days to date
+		{day.Add(2 * time.Hour), 0.185, false},
+	} {
+		got, known := prices.hour(tc.hour)
+		if math.Abs(got-tc.want) > 1e-9 || known != tc.known {
+			t.Errorf("%s: got %v, %v, want %v, %v", tc.hour, got, known, tc.want, tc.known)
+		}
+	}
+	// (100*60 + 200*30 + 300*30) / 120 / 1000 + 0.01
+	if got, known := prices.get(day); !known || math.Abs(got.mean-0.185) > 1e-9 {
+		t.Errorf("got the daily price %v, %v, want 0.185", got.mean, known)
+	}
+	// unknown days use the average of the days to date
+	if got, known := prices.get(day.AddDate(0, 0, 1)); known || math.Abs(got.mean-0.185) > 1e-9 {
+		t.Errorf("got the price of tomorrow %v, %v, want the unknown 0.185", got.mean, known)
+	}
+
+	fixed, err := newDayPrices(cfg, "PUN", period, 0.2, 0.01)
+	if err != nil {
+		t.Fatalf("newDayPrices failed: %v", err)
+	}
+	if got, known := fixed.get(day.AddDate(0, 0, 1)); got.mean != 0.2 || !known {
+		t.Errorf("got the fixed price %v, %v, want 0.2", got.mean, known)
+	}
+	if got, known := fixed.hour(day.AddDate(0, 0, 1)); got != 0.2 || !known {
+		t.Errorf("got the fixed hourly price %v, %v, want 0.2", got, known)
+	}
+
+	empty := &Period{From: day.AddDate(0, 0, 5), To: day.AddDate(0, 0, 6), End: day.AddDate(0, 0, 6)}
+	if _, err := newDayPrices(cfg, "PUN", empty, 0, 0); err == nil {
+		t.Error("want an error without prices to date")
+	}
+}
+
+func TestWeekdayConsumption(t *testing.T) {
+	// Monday
+	now := time.Date(2024, time.January, 15, 12, 0, 0, 0, time.Local)
+	// every 1/16 of an hour, so that the integrals are exact
+	step := 225 * time.Second
+	// 0.5 kW, except from 1:00 to 23:00 on Mondays, 1 kW, and Sundays, 2 kW,
+	// with a sensor offline for most of Saturday the 13th
+	power := func(t time.Time) (float64, bool) {
+		switch {
+		case t.Hour() < 1 || t.Hour() >= 23:
+			return 500, true
+		case t.Day() == 13:
+			return 0, false
+		case t.Weekday() == time.Monday:
+			return 1000, true
+		case t.Weekday() == time.Sunday:
+			return 2000, true
+		}
+		return 500, true
+	}
+	b := &fakeBackend{series: map[string][]Sample{"power": sampleEvery(now.AddDate(0, 0, -15), now, step, power)}}
+	cfg := &Config{backend: b}
+	p := &Preset{Name: "power", Query: "power", Unit: UnitWIntegrated}
+
+	defer func(s, g time.Duration) { *flagStep, *flagMaxGap = s, g }(*flagStep, *flagMaxGap)
+	*flagStep, *flagMaxGap = step, step
+	stats, err := weekdayConsumption(cfg, p, now, 14*24*time.Hour)
+	if err != nil {
+		t.Fatalf("weekdayConsumption failed: %v", err)
+	}
+	if got := stats[time.Sunday]; got.mean != 45 || got.std != 0 {
+		t.Errorf("Sunday: got %+v, want 45 kWh", got)
+	}
+	if got := stats[time.Tuesday]; got.mean != 12 || got.std != 0 {
+		t.Errorf("Tuesday: got %+v, want 12 kWh", got)
+	}
+	if got := stats[time.Monday]; got.mean != 23 || got.std != 0 {
+		t.Errorf("Monday: got %+v, want 23 kWh", got)
+	}
+	// a single complete Saturday, that uses all the days
+	wantMean, wantStd := meanStd([]float64{23, 12, 12, 12, 12, 12, 45, 23, 12, 12, 12, 12, 45})
+	if got := stats[time.Saturday]; math.Abs(got.mean-wantMean) > 1e-9 || math.Abs(got.std-wantStd) > 1e-9 {
+		t.Errorf("Saturday: got %+v, want %v and %v", got, wantMean, wantStd)
+	}
+
+	if _, err := weekdayConsumption(&Config{backend: &fakeBackend{}}, p, now, 7*24*time.Hour); err == nil {
+		t.Error("want an error without any consumption")
+	}
+}
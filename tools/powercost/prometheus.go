@@ -3,9 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -32,37 +33,81 @@ func (d *promDatapoint) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func promQueryAt(cfg *Config, qs string, t *time.Time) (float64, error) {
-	// if no time is specified, let it be now
-	if t == nil {
-		n := time.Now()
-		t = &n
+// promBackend queries the Prometheus HTTP API, also implemented by
+// VictoriaMetrics.
+type promBackend struct {
+	httpBackend
+	// queryURL is the URL of the instant queries. The range query URL is the
+	// same followed by "_range", like Prometheus' /api/v1/query_range.
+	queryURL url.URL
+	// params are added to every request
+	params url.Values
+}
+
+// NewPrometheusBackend returns a backend sending PromQL queries to the
+// instant query URL queryURL, e.g. http://localhost:9090/api/v1/query, with
+// client. auth, if not nil, sets the headers and credentials of every request.
+func NewPrometheusBackend(queryURL string, client *http.Client, auth *HTTPConfig) (Backend, error) {
+	u, err := url.Parse(queryURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query URL '%s': %w", queryURL, err)
 	}
-	u := url.URL{
-		Scheme: cfg.PrometheusScheme,
-		Host:   cfg.PrometheusHostPort,
-		Path:   cfg.PrometheusQueryPath,
+	return &promBackend{
+		httpBackend: httpBackend{client: client, auth: auth},
+		queryURL:    *u,
+	}, nil
+}
+
+// NewVictoriaMetricsBackend returns a backend sending MetricsQL queries to
+// the Prometheus-compatible API of VictoriaMetrics at baseURL, e.g.
+// http://localhost:8428 or http://vmselect:8481/select/0/prometheus for a
+// cluster. Results are not cached by VictoriaMetrics, so that the last
+// minutes are never stale.
+func NewVictoriaMetricsBackend(baseURL string, client *http.Client, auth *HTTPConfig) (Backend, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL '%s': %w", baseURL, err)
 	}
+	return &promBackend{
+		httpBackend: httpBackend{client: client, auth: auth},
+		queryURL:    *u.JoinPath("api/v1/query"),
+		params:      url.Values{"nocache": []string{"1"}},
+	}, nil
+}
+
+func (b *promBackend) get(path string, params url.Values) ([]byte, error) {
+	u := b.queryURL
+	u.Path += path
 	q := u.Query()
-	q.Set("query", qs)
-	q.Set("time", strconv.FormatInt(t.Unix(), 10))
+	for k, v := range b.params {
+		q[k] = v
+	}
+	for k, v := range params {
+		q[k] = v
+	}
 	u.RawQuery = q.Encode()
-	resp, err := http.Get(u.String())
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 	if err != nil {
-		return 0, fmt.Errorf("http.GET failed: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return 0, fmt.Errorf("received non-200 HTTP code: %s", resp.Status)
-	}
-	body, err := io.ReadAll(resp.Body)
+	return b.do(req)
+}
+
+func (b *promBackend) QueryAt(qs string, t time.Time) (float64, error) {
+	body, err := b.get("", url.Values{
+		"query": []string{qs},
+		"time":  []string{strconv.FormatInt(t.Unix(), 10)},
+	})
 	if err != nil {
-		return 0, fmt.Errorf("io.ReadAll failed: %w", err)
+		return 0, err
 	}
 	var j promResponse
 	if err := json.Unmarshal(body, &j); err != nil {
 		return 0, fmt.Errorf("json.Unmarshal failed: %w", err)
 	}
+	if len(j.Data.Result) == 0 {
+		return 0, fmt.Errorf("query returned no series")
+	}
 	f, err := strconv.ParseFloat(j.Data.Result[0].Value.Value, 64)
 	if err != nil {
 		return 0, fmt.Errorf("strconv.ParseFloat64 failed: %w", err)
@@ -70,6 +115,63 @@ func promQueryAt(cfg *Config, qs string, t *time.Time) (float64, error) {
 	return f, nil
 }
 
+func (b *promBackend) QueryRange(qs string, start, end time.Time, step time.Duration) ([]Sample, error) {
+	if err := checkStep(step); err != nil {
+		return nil, err
+	}
+	body, err := b.get("_range", url.Values{
+		"query": []string{qs},
+		"start": []string{strconv.FormatInt(start.Unix(), 10)},
+		"end":   []string{strconv.FormatInt(end.Unix(), 10)},
+		// fractional seconds, so that a sub-second step is not sent as 0
+		"step": []string{strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var j promRangeResponse
+	if err := json.Unmarshal(body, &j); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal failed: %w", err)
+	}
+	if len(j.Data.Result) == 0 {
+		return nil, fmt.Errorf("query returned no series")
+	}
+	if n := len(j.Data.Result); n > 1 {
+		return nil, fmt.Errorf("query returned %d series, want 1", n)
+	}
+	samples := make([]Sample, 0, len(j.Data.Result[0].Values))
+	for _, dp := range j.Data.Result[0].Values {
+		f, err := strconv.ParseFloat(dp.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("strconv.ParseFloat64 failed: %w", err)
+		}
+		samples = append(samples, Sample{Time: time.UnixMilli(int64(math.Round(dp.Timestamp * 1000))), Value: f})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+// Increase uses increase(), which handles counter resets, over every series
+// selected by q.
+func (b *promBackend) Increase(q string, start, end time.Time) (float64, error) {
+	seconds := int64(end.Sub(start).Seconds())
+	if seconds <= 0 {
+		return 0, fmt.Errorf("empty period")
+	}
+	return b.QueryAt(fmt.Sprintf("sum(increase(%s[%ds]))", q, seconds), end)
+}
+
+type promRangeResponse struct {
+	Status string
+	Data   struct {
+		ResultType string
+		Result     []struct {
+			Metric interface{}
+			Values []promDatapoint
+		}
+	}
+}
+
 type promResponse struct {
 	Status string
 	Data   struct {
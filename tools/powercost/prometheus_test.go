@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPromBackendQueryRange(t *testing.T) {
+	var got url.Values
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, got = r.URL.Path, r.URL.Query()
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{},"values":[[1705312800.5,"2"],[1705312800,"1"]]}]}}`))
+	}))
+	defer srv.Close()
+
+	start := time.Unix(1705312800, 0)
+	for _, tc := range []struct {
+		name     string
+		newB     func() (Backend, error)
+		path     string
+		step     time.Duration
+		wantStep string
+		nocache  string
+	}{
+		{
+			name:     "prometheus",
+			newB:     func() (Backend, error) { return NewPrometheusBackend(srv.URL+"/api/v1/query", srv.Client(), nil) },
+			path:     "/api/v1/query_range",
+			step:     time.Minute,
+			wantStep: "60",
+		},
+		{
+			name:     "prometheus sub-second step",
+			newB:     func() (Backend, error) { return NewPrometheusBackend(srv.URL+"/api/v1/query", srv.Client(), nil) },
+			path:     "/api/v1/query_range",
+			step:     500 * time.Millisecond,
+			wantStep: "0.5",
+		},
+		{
+			name: "victoriametrics",
+			newB: func() (Backend, error) {
+				return NewVictoriaMetricsBackend(srv.URL+"/select/0/prometheus", srv.Client(), nil)
+			},
+			path:     "/select/0/prometheus/api/v1/query_range",
+			step:     time.Hour,
+			wantStep: "3600",
+			nocache:  "1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := tc.newB()
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples, err := b.QueryRange("power", start, start.Add(time.Hour), tc.step)
+			if err != nil {
+				t.Fatalf("QueryRange failed: %v", err)
+			}
+			if path != tc.path {
+				t.Errorf("got path %s, want %s", path, tc.path)
+			}
+			if got.Get("query") != "power" || got.Get("start") != "1705312800" || got.Get("end") != "1705316400" {
+				t.Errorf("unexpected parameters %v", got)
+			}
+			if s := got.Get("step"); s != tc.wantStep {
+				t.Errorf("got step %s, want %s", s, tc.wantStep)
+			}
+			if s := got.Get("nocache"); s != tc.nocache {
+				t.Errorf("got nocache %q, want %q", s, tc.nocache)
+			}
+			if len(samples) != 2 || samples[0].Value != 1 || samples[1].Value != 2 {
+				t.Fatalf("got %+v, want the samples 1 and 2 in chronological order", samples)
+			}
+			if d := samples[1].Time.Sub(samples[0].Time); d != 500*time.Millisecond {
+				t.Errorf("the samples are %s apart, want 500ms", d)
+			}
+		})
+	}
+}
+
+func TestPromBackendQueryRangeErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("query") {
+		case "none":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+		case "many":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{"phase":"1"},"values":[[1705312800,"1"]]},
+				{"metric":{"phase":"2"},"values":[[1705312800,"2"]]}]}}`))
+		case "broken":
+			_, _ = w.Write([]byte("not json"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	b, err := NewPrometheusBackend(srv.URL+"/api/v1/query", srv.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1705312800, 0)
+	for _, q := range []string{"none", "many", "broken", "bad"} {
+		if samples, err := b.QueryRange(q, start, start.Add(time.Hour), time.Minute); err == nil {
+			t.Errorf("%s: want an error, got %+v", q, samples)
+		}
+	}
+	if _, err := b.QueryRange("none", start, start.Add(time.Hour), 0); err == nil {
+		t.Error("want an error for a zero step")
+	}
+}
+
+func TestPromBackendIncrease(t *testing.T) {
+	var got url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{},"value":[1705316400,"1500"]}]}}`))
+	}))
+	defer srv.Close()
+
+	b, err := NewPrometheusBackend(srv.URL+"/api/v1/query", srv.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1705312800, 0)
+	v, err := b.Increase("energy_wh", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Increase failed: %v", err)
+	}
+	if v != 1500 {
+		t.Errorf("got %v, want 1500", v)
+	}
+	if q := got.Get("query"); q != "sum(increase(energy_wh[3600s]))" || got.Get("time") != "1705316400" {
+		t.Errorf("unexpected parameters %v", got)
+	}
+}
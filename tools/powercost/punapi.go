@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// hourlyPrice is the price of a zone in the market interval starting at Time
+// and lasting Minutes, 60 or 15, as returned by punapi's /prices endpoint.
+// Prices are in EUR/MWh.
+type hourlyPrice struct {
+	Time    time.Time `json:"time"`
+	Minutes int       `json:"minutes"`
+	Price   float64   `json:"price"`
+}
+
+// End returns the end of the market interval.
+func (p hourlyPrice) End() time.Time {
+	return p.Time.Add(time.Duration(p.Minutes) * time.Minute)
+}
+
+// punapiHourlyPrices returns the hourly or quarter-hourly prices of zone for
+// the market days, in Italian time, from `from` to `to`, both included.
+func punapiHourlyPrices(cfg *Config, zone string, from, to time.Time) ([]hourlyPrice, error) {
+	u, err := url.Parse(cfg.PunAPIURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid punapi URL '%s': %w", cfg.PunAPIURL, err)
+	}
+	u = u.JoinPath("prices")
+	q := u.Query()
+	// punapi takes market days
+	q.Set("from", from.In(market.Location).Format("2006-01-02"))
+	q.Set("to", to.In(market.Location).Format("2006-01-02"))
+	q.Set("zone", zone)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
+	}
+	client := cfg.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	// the backend's credentials are not sent to punapi
+	h := httpBackend{client: client}
+	body, err := h.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var prices []hourlyPrice
+	if err := json.Unmarshal(body, &prices); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal failed: %w", err)
+	}
+	return prices, nil
+}
+
+// punapiHourlyAverages returns the prices of zone in EUR/MWh from `from` to
+// `to`, keyed by the Unix time of the start of the hour. Quarter-hourly
+// prices are averaged over their hour.
+func punapiHourlyAverages(cfg *Config, zone string, from, to time.Time) (map[int64]float64, error) {
+	prices, err := punapiHourlyPrices(cfg, zone, from, to.Add(-time.Nanosecond))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get hourly prices: %w", err)
+	}
+	hourly := make(map[int64]float64)
+	for _, p := range prices {
+		hourly[p.Time.Truncate(time.Hour).Unix()] += p.Price * float64(p.Minutes) / 60
+	}
+	return hourly, nil
+}
+
+// validateMarketZone checks that zone is set and is a market zone, like
+// NORD. The energy fed into the grid is paid at the zonal price, not at the
+// PUN.
+func validateMarketZone(zone string) error {
+	if zone == "" {
+		return fmt.Errorf("zone cannot be empty, e.g. NORD")
+	}
+	if strings.EqualFold(zone, "PUN") {
+		return fmt.Errorf("zone must be a market zone, e.g. NORD, not %s", zone)
+	}
+	return nil
+}
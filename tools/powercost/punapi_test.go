@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPunapiHourlyPrices(t *testing.T) {
+	var path, from, to, zone string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		from, to, zone = r.URL.Query().Get("from"), r.URL.Query().Get("to"), r.URL.Query().Get("zone")
+		_, _ = w.Write([]byte(`[{"time":"2024-01-15T00:00:00+01:00","price":100},{"time":"2024-01-15T01:00:00+01:00","price":90.5}]`))
+	}))
+	defer srv.Close()
+
+	day := time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)
+	prices, err := punapiHourlyPrices(&Config{PunAPIURL: srv.URL + "/punapi"}, "NORD", day, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("punapiHourlyPrices failed: %v", err)
+	}
+	if path != "/punapi/prices" || from != "2024-01-15" || to != "2024-01-16" || zone != "NORD" {
+		t.Errorf("unexpected request %s from %s to %s for %s", path, from, to, zone)
+	}
+	if len(prices) != 2 || prices[0].Price != 100 || prices[1].Price != 90.5 {
+		t.Errorf("unexpected prices %+v", prices)
+	}
+}
+
+func TestPunapiHourlyPricesErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("zone") {
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		case "broken":
+			_, _ = w.Write([]byte("not json"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	// punapi requests use the configured timeout
+	httpCfg := HTTPConfig{Timeout: "50ms"}
+	client, err := httpCfg.NewClient()
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	now := time.Now()
+	for _, zone := range []string{"slow", "broken", "missing"} {
+		if _, err := punapiHourlyPrices(&Config{PunAPIURL: srv.URL, client: client}, zone, now, now); err == nil {
+			t.Errorf("%s: want an error", zone)
+		}
+	}
+}
+
+func TestPunapiHourlyPricesMarketDays(t *testing.T) {
+	var from, to string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, to = r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	// 23:30 UTC is already the next day in Italy
+	start := time.Date(2024, time.January, 15, 23, 30, 0, 0, time.UTC)
+	end := time.Date(2024, time.July, 15, 21, 59, 0, 0, time.UTC)
+	if _, err := punapiHourlyPrices(&Config{PunAPIURL: srv.URL}, "PUN", start, end); err != nil {
+		t.Fatalf("punapiHourlyPrices failed: %v", err)
+	}
+	if from != "2024-01-16" || to != "2024-07-15" {
+		t.Errorf("got from %s and to %s, want 2024-01-16 and 2024-07-15", from, to)
+	}
+}
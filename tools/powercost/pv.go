@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// PVConfig holds the series of a photovoltaic system with a bidirectional
+// meter.
+type PVConfig struct {
+	// Production is the energy produced by the inverter
+	Production EnergySeries `json:"production"`
+	// Import is the energy taken from the grid
+	Import EnergySeries `json:"import"`
+	// Export is the energy fed into the grid
+	Export EnergySeries `json:"export"`
+	// Zone is the market zone of the system, e.g. NORD, whose hourly price
+	// pays the exported energy
+	Zone string `json:"zone"`
+}
+
+// presets returns the production, import and export series as presets.
+func (c *PVConfig) presets() []Preset {
+	return []Preset{
+		c.Production.preset("production"),
+		c.Import.preset("import"),
+		c.Export.preset("export"),
+	}
+}
+
+// Validate checks that all the series and the zone are set.
+func (c *PVConfig) Validate() error {
+	for _, p := range c.presets() {
+		if err := validateHourly(&p); err != nil {
+			return err
+		}
+	}
+	return validateMarketZone(c.Zone)
+}
+
+// pvSummary prints the energy balance of the photovoltaic system over period
+// and its costs. The self-consumed energy of every hour is the production not
+// exported. Imported and self-consumed energy are priced at the retail price,
+// pricePerKwh or, if zero, the hourly price of zone plus spread. Exported
+// energy is paid at the hourly price of the system's zone, like in the Ritiro
+// Dedicato.
+func pvSummary(cfg *Config, zone string, period *Period, pricePerKwh, spread float64) error {
+	if cfg.PV == nil {
+		return fmt.Errorf("no pv series in the configuration file")
+	}
+	from := period.From.Truncate(time.Hour)
+	to := period.To.Truncate(time.Hour)
+	if !to.After(from) {
+		return fmt.Errorf("no complete hour in the period")
+	}
+	var series [3]map[int64]float64
+	for idx, p := range cfg.PV.presets() {
+		energy, err := hourlyEnergy(cfg.backend, &p, from, to, *flagStep, *flagMaxGap)
+		if err != nil {
+			return fmt.Errorf("%s query failed: %w", p.Name, err)
+		}
+		series[idx] = energy
+	}
+	production, imports, exports := series[0], series[1], series[2]
+
+	zonal, err := punapiHourlyAverages(cfg, cfg.PV.Zone, from, to)
+	if err != nil {
+		return err
+	}
+	var retail map[int64]float64
+	if pricePerKwh == 0 {
+		if retail, err = punapiHourlyAverages(cfg, zone, from, to); err != nil {
+			return err
+		}
+	}
+
+	var (
+		producedKwh, importedKwh, exportedKwh, selfKwh float64
+		importCost, avoidedCost, revenue               float64
+		unpriced                                       int
+	)
+	for hour := from; hour.Before(to); hour = hour.Add(time.Hour) {
+		h := hour.Unix()
+		self := math.Max(production[h]-exports[h], 0)
+		producedKwh += production[h]
+		importedKwh += imports[h]
+		exportedKwh += exports[h]
+		selfKwh += self
+		price, priced := pricePerKwh, true
+		if pricePerKwh == 0 {
+			price, priced = retail[h]
+			price = price/1000 + spread
+		}
+		if priced {
+			importCost += imports[h] * price
+			avoidedCost += self * price
+		}
+		feedIn, feedInPriced := zonal[h]
+		if feedInPriced {
+			revenue += exports[h] * feedIn / 1000
+		}
+		if (!priced && (imports[h] > 0 || self > 0)) || (!feedInPriced && exports[h] > 0) {
+			unpriced++
+		}
+	}
+
+	fmt.Printf("## Photovoltaic (%s)\n", cfg.PV.Zone)
+	fmt.Printf("    period     : %s to %s\n", from.Format("2006-01-02 15:04"), to.Format("2006-01-02 15:04"))
+	fmt.Printf("    production : %.3f kWh\n", producedKwh)
+	fmt.Printf("    self-use   : %.3f kWh", selfKwh)
+	if producedKwh > 0 {
+		fmt.Printf(" (%.1f%% of the production)", selfKwh/producedKwh*100)
+	}
+	fmt.Println()
+	fmt.Printf("    import     : %.3f kWh\n", importedKwh)
+	fmt.Printf("    export     : %.3f kWh\n", exportedKwh)
+	if consumption := importedKwh + selfKwh; consumption > 0 {
+		fmt.Printf("    consumption: %.3f kWh (%.1f%% self-sufficiency)\n", consumption, selfKwh/consumption*100)
+	}
+	if unpriced > 0 && pricePerKwh == 0 && zone != cfg.PV.Zone {
+		fmt.Printf("    unpriced   : %d hours without a %s or %s price\n", unpriced, zone, cfg.PV.Zone)
+	} else if unpriced > 0 {
+		fmt.Printf("    unpriced   : %d hours without a %s price\n", unpriced, cfg.PV.Zone)
+	}
+	fmt.Printf("    import cost: %.3f %s\n", importCost, cfg.Currency)
+	fmt.Printf("    avoided    : %.3f %s\n", avoidedCost, cfg.Currency)
+	fmt.Printf("    revenue    : %.3f %s (Ritiro Dedicato)\n", revenue, cfg.Currency)
+	fmt.Printf("    net cost   : %.3f %s (%.3f %s without PV)\n", importCost-revenue, cfg.Currency, importCost+avoidedCost, cfg.Currency)
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestPVConfigValidate(t *testing.T) {
+	counter := EnergySeries{Query: "energy_wh_total", Unit: UnitWhCounter}
+	for _, tc := range []struct {
+		name string
+		cfg  PVConfig
+		ok   bool
+	}{
+		{"counters", PVConfig{Production: counter, Import: counter, Export: counter, Zone: "NORD"}, true},
+		{
+			"power and counters",
+			PVConfig{Production: EnergySeries{Query: "inverter_w", Unit: UnitWIntegrated}, Import: counter, Export: EnergySeries{Query: "export_kwh_total", Unit: UnitKWhCounter}, Zone: "CSUD"},
+			true,
+		},
+		{"no export", PVConfig{Production: counter, Import: counter, Zone: "NORD"}, false},
+		{"no unit", PVConfig{Production: counter, Import: EnergySeries{Query: "import"}, Export: counter, Zone: "NORD"}, false},
+		{"energy of the hour", PVConfig{Production: EnergySeries{Query: "hour_wh", Unit: UnitWh}, Import: counter, Export: counter, Zone: "NORD"}, true},
+		{"unknown unit", PVConfig{Production: EnergySeries{Query: "inverter", Unit: "W"}, Import: counter, Export: counter, Zone: "NORD"}, false},
+		{"no zone", PVConfig{Production: counter, Import: counter, Export: counter}, false},
+		// the feed-in is paid at the zonal price
+		{"PUN", PVConfig{Production: counter, Import: counter, Export: counter, Zone: "PUN"}, false},
+		{"lowercase PUN", PVConfig{Production: counter, Import: counter, Export: counter, Zone: "pun"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.cfg.Validate(); (err == nil) != tc.ok {
+				t.Errorf("got %v, want ok %v", err, tc.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// deviceLabel returns the device label of a preset's metrics.
+func deviceLabel(p *Preset) string {
+	if p.Device == "" {
+		return "all"
+	}
+	return p.Device
+}
+
+// serveMetrics are the metrics exported in serve mode.
+type serveMetrics struct {
+	energy *prometheus.GaugeVec
+	cost   *prometheus.GaugeVec
+}
+
+// newServeMetrics creates the serve mode metrics and registers them with reg.
+func newServeMetrics(reg prometheus.Registerer) (*serveMetrics, error) {
+	m := serveMetrics{
+		energy: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "powercost_energy_kwh",
+				Help: "Energy used in the period ending now, in kWh",
+			},
+			[]string{"period", "device"},
+		),
+		cost: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "powercost_cost",
+				Help: "Cost of the energy used in the period ending now",
+			},
+			[]string{"period", "device", "currency"},
+		),
+	}
+	for _, c := range []prometheus.Collector{m.energy, m.cost} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register collector: %w", err)
+		}
+	}
+	return &m, nil
+}
+
+// update sets the energy and cost of every preset's period ending at now.
+func (m *serveMetrics) update(cfg *Config, presets []Preset, pricePerKwh float64, now time.Time) {
+	for idx := range presets {
+		p := &presets[idx]
+		labels := []string{p.Period, deviceLabel(p)}
+		energy, err := presetEnergy(cfg, p, now)
+		if err != nil {
+			log.Printf("Failed to compute preset '%s': %v", p.Name, err)
+			// do not export stale values
+			m.energy.DeleteLabelValues(labels...)
+			m.cost.DeleteLabelValues(append(labels, cfg.Currency)...)
+			continue
+		}
+		m.energy.WithLabelValues(labels...).Set(energy.Value)
+		m.cost.WithLabelValues(append(labels, cfg.Currency)...).Set(energy.Value * pricePerKwh)
+	}
+}
+
+// checkServePresets returns an error if two presets would export the same
+// metrics.
+func checkServePresets(presets []Preset) error {
+	seen := make(map[string]string, len(presets))
+	for idx := range presets {
+		p := &presets[idx]
+		k := deviceLabel(p) + "/" + p.Period
+		if other, ok := seen[k]; ok {
+			return fmt.Errorf("presets '%s' and '%s' have the same device and period", other, p.Name)
+		}
+		seen[k] = p.Name
+	}
+	return nil
+}
+
+// serve recomputes the energy and cost of presets every interval, and exports
+// them as Prometheus metrics on listen.
+func serve(cfg *Config, presets []Preset, pricePerKwh float64, listen string, interval time.Duration) error {
+	if err := checkServePresets(presets); err != nil {
+		return err
+	}
+	m, err := newServeMetrics(prometheus.DefaultRegisterer)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		firstrun := true
+		for {
+			if !firstrun {
+				log.Printf("Sleeping %s...", interval)
+				time.Sleep(interval)
+			}
+			firstrun = false
+			m.update(cfg, presets, pricePerKwh, time.Now())
+		}
+	}()
+
+	http.Handle("/metrics", promhttp.Handler())
+	log.Printf("Starting server on %s", listen)
+	return http.ListenAndServe(listen, nil)
+}
+
+// presetEnergy returns the energy of the preset's period ending at t, in kWh.
+func presetEnergy(cfg *Config, p *Preset, t time.Time) (Quantity, error) {
+	window, err := p.Window(t)
+	if err != nil {
+		return Quantity{}, err
+	}
+	energy, _, err := p.Energy(cfg.backend, t.Add(-window), t, *flagStep, *flagMaxGap)
+	if err != nil {
+		return Quantity{}, err
+	}
+	return energy.To(KiloWattHour)
+}
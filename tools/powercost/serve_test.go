@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestDeviceLabel(t *testing.T) {
+	if got := deviceLabel(&Preset{}); got != "all" {
+		t.Errorf("got %q, want all", got)
+	}
+	if got := deviceLabel(&Preset{Device: "boiler"}); got != "boiler" {
+		t.Errorf("got %q, want boiler", got)
+	}
+}
+
+func TestCheckServePresets(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		presets []Preset
+		ok      bool
+	}{
+		{"no presets", nil, true},
+		{"different periods", []Preset{{Name: "a", Period: "today"}, {Name: "b", Period: "7d"}}, true},
+		{"different devices", []Preset{{Name: "a", Period: "today"}, {Name: "b", Period: "today", Device: "boiler"}}, true},
+		{"same period", []Preset{{Name: "a", Period: "today"}, {Name: "b", Period: "today"}}, false},
+		{"same device", []Preset{{Name: "a", Period: "7d", Device: "all"}, {Name: "b", Period: "7d"}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := checkServePresets(tc.presets); (err == nil) != tc.ok {
+				t.Errorf("got %v, want ok %v", err, tc.ok)
+			}
+		})
+	}
+}
+
+func TestPresetEnergyKWh(t *testing.T) {
+	now := time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)
+	cfg := Config{backend: &fakeBackend{series: map[string][]Sample{
+		"power": sampleEvery(now.Add(-2*time.Hour), now, 225*time.Second, func(time.Time) (float64, bool) { return 500, true }),
+	}}}
+	energy, err := presetEnergy(&cfg, &Preset{Query: "power", Unit: UnitWIntegrated, Period: "2h"}, now)
+	if err != nil {
+		t.Fatalf("presetEnergy failed: %v", err)
+	}
+	if energy != (Quantity{1, KiloWattHour}) {
+		t.Errorf("got %s, want 1 kWh", energy)
+	}
+	if _, err := presetEnergy(&cfg, &Preset{Query: "power", Unit: UnitWIntegrated, Period: "week"}, now); err == nil {
+		t.Error("want an error for an invalid period")
+	}
+}
+
+// scrape returns the metrics exposed by reg.
+func scrape(t *testing.T, reg *prometheus.Registry) string {
+	srv := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestServeMetrics(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	power := func(time.Time) (float64, bool) { return 1000, true }
+	b := &fakeBackend{series: map[string][]Sample{
+		"tapo_today": {{Time: now.Add(-time.Minute), Value: 2500}},
+		// every 1/16 of an hour, so that the integral is exact
+		"boiler": sampleEvery(now.Add(-24*time.Hour), now, 225*time.Second, power),
+	}}
+	cfg := Config{Currency: "EUR", backend: b}
+	presets := []Preset{
+		{Name: "today", Query: "tapo_today", Unit: UnitWh, Period: "today"},
+		{Name: "boiler", Query: "boiler", Unit: UnitWIntegrated, Period: "1d", Device: "boiler"},
+		{Name: "broken", Query: "missing", Unit: UnitWh, Period: "7d"},
+	}
+	reg := prometheus.NewRegistry()
+	m, err := newServeMetrics(reg)
+	if err != nil {
+		t.Fatalf("newServeMetrics failed: %v", err)
+	}
+	m.update(&cfg, presets, 0.25, now)
+	got := scrape(t, reg)
+	for _, want := range []string{
+		`powercost_energy_kwh{device="all",period="today"} 2.5`,
+		`powercost_cost{currency="EUR",device="all",period="today"} 0.625`,
+		`powercost_energy_kwh{device="boiler",period="1d"} 24`,
+		`powercost_cost{currency="EUR",device="boiler",period="1d"} 6`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("missing %s in\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `period="7d"`) {
+		t.Errorf("exported a preset that failed:\n%s", got)
+	}
+
+	// a preset that stops working is no longer exported
+	delete(b.series, "boiler")
+	m.update(&cfg, presets, 0.25, now)
+	got = scrape(t, reg)
+	if strings.Contains(got, `device="boiler"`) {
+		t.Errorf("exported a stale value:\n%s", got)
+	}
+	if !strings.Contains(got, `powercost_energy_kwh{device="all",period="today"} 2.5`) {
+		t.Errorf("lost a working preset:\n%s", got)
+	}
+
+	if _, err := newServeMetrics(reg); err == nil {
+		t.Error("want an error registering the metrics twice")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// Tariff types.
+const (
+	// TariffFixed has the same price for every kWh
+	TariffFixed = "fixed"
+	// TariffMonoraria is the hourly zone price plus a spread
+	TariffMonoraria = "monoraria"
+	// TariffBioraria has a price for F1 and one for F2 and F3
+	TariffBioraria = "bioraria"
+	// TariffTrioraria has a price for each of F1, F2 and F3
+	TariffTrioraria = "trioraria"
+)
+
+// Tariff is an electricity offer to compare. Prices are per kWh, in the
+// configured currency.
+type Tariff struct {
+	Name string `json:"name"`
+	// Type is one of fixed, monoraria, bioraria and trioraria
+	Type string `json:"type"`
+	// Price is the price of fixed tariffs
+	Price float64 `json:"price,omitempty"`
+	// Spread is added to the hourly price by monoraria tariffs
+	Spread float64 `json:"spread,omitempty"`
+	// F1, F2, F3 and F23 are the prices of the time bands of bioraria (F1
+	// and F23) and trioraria (F1, F2 and F3) tariffs, or their spreads over
+	// the hourly price if Indexed
+	F1      float64 `json:"f1,omitempty"`
+	F2      float64 `json:"f2,omitempty"`
+	F3      float64 `json:"f3,omitempty"`
+	F23     float64 `json:"f23,omitempty"`
+	Indexed bool    `json:"indexed,omitempty"`
+	// MonthlyFee is charged for every month of the period, prorated for
+	// partial months
+	MonthlyFee float64 `json:"monthly_fee,omitempty"`
+}
+
+// Validate checks the tariff's fields.
+func (t *Tariff) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("tariff name cannot be empty")
+	}
+	switch t.Type {
+	case TariffFixed:
+		if t.Price <= 0 {
+			return fmt.Errorf("tariff '%s': fixed tariffs require a price", t.Name)
+		}
+	case TariffMonoraria:
+	case TariffBioraria:
+		if !t.Indexed && (t.F1 <= 0 || t.F23 <= 0) {
+			return fmt.Errorf("tariff '%s': bioraria tariffs require f1 and f23", t.Name)
+		}
+	case TariffTrioraria:
+		if !t.Indexed && (t.F1 <= 0 || t.F2 <= 0 || t.F3 <= 0) {
+			return fmt.Errorf("tariff '%s': trioraria tariffs require f1, f2 and f3", t.Name)
+		}
+	default:
+		return fmt.Errorf("tariff '%s': type must be one of %s, %s, %s, %s, got '%s'", t.Name, TariffFixed, TariffMonoraria, TariffBioraria, TariffTrioraria, t.Type)
+	}
+	return nil
+}
+
+// PriceOf returns the price of a kWh in the given band, when the zone's
+// price is price EUR/MWh.
+func (t *Tariff) PriceOf(band Band, price float64) float64 {
+	var bandPrice float64
+	switch t.Type {
+	case TariffFixed:
+		return t.Price
+	case TariffMonoraria:
+		return price/1000 + t.Spread
+	case TariffBioraria:
+		bandPrice = t.F23
+		if band == F1 {
+			bandPrice = t.F1
+		}
+	case TariffTrioraria:
+		bandPrice = map[Band]float64{F1: t.F1, F2: t.F2, F3: t.F3}[band]
+	}
+	if t.Indexed {
+		return price/1000 + bandPrice
+	}
+	return bandPrice
+}
+
+// hourlyConsumption returns the energy in kWh used in every hour from `from`
+// to `to`, keyed by the Unix time of the start of the hour. The query q is
+// evaluated at the end of every hour and must return the energy of the hour
+// that just ended, in unit.
+func hourlyConsumption(b Backend, q string, unit Unit, from, to time.Time) (map[int64]float64, error) {
+	consumption := make(map[int64]float64)
+	chunk := maxRangePoints * time.Hour
+	for start := from; start.Before(to); start = start.Add(chunk) {
+		end := start.Add(chunk)
+		if end.After(to) {
+			end = to
+		}
+		samples, err := b.QueryRange(q, start.Add(time.Hour), end, time.Hour)
+		if err != nil {
+			return nil, fmt.Errorf("query failed: %w", err)
+		}
+		for _, s := range samples {
+			// negative values come from counter resets
+			if s.Value < 0 {
+				continue
+			}
+			kwh, err := Quantity{Value: s.Value, Unit: unit}.To(KiloWattHour)
+			if err != nil {
+				return nil, err
+			}
+			consumption[s.Time.Add(-time.Hour).Unix()] = kwh.Value
+		}
+	}
+	return consumption, nil
+}
+
+// tariffCost is the cost of a tariff over the backtest period.
+type tariffCost struct {
+	tariff       *Tariff
+	energy, fees float64
+}
+
+// monthsIn returns the number of months from `from` to `to`, counting partial
+// months by the fraction of the month they cover.
+func monthsIn(from, to time.Time) float64 {
+	var months float64
+	for month := startOfDay(from).AddDate(0, 0, 1-from.Day()); month.Before(to); month = month.AddDate(0, 1, 0) {
+		next := month.AddDate(0, 1, 0)
+		start, end := month, next
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		months += float64(end.Sub(start)) / float64(next.Sub(month))
+	}
+	return months
+}
+
+// tariffsSummary prints what every tariff would have cost for the hourly
+// consumption returned by q over period, priced with the hourly prices of
+// zone from punapi. Hours without a consumption or a price are skipped for
+// all the tariffs.
+func tariffsSummary(cfg *Config, q string, unit Unit, zone string, period *Period) error {
+	if len(cfg.Tariffs) == 0 {
+		return fmt.Errorf("no tariffs in the configuration file")
+	}
+	from := period.From.Truncate(time.Hour)
+	to := period.To.Truncate(time.Hour)
+	if !to.After(from) {
+		return fmt.Errorf("no complete hour in the period")
+	}
+	consumption, err := hourlyConsumption(cfg.backend, q, unit, from, to)
+	if err != nil {
+		return err
+	}
+
+	costs := make([]tariffCost, len(cfg.Tariffs))
+	for idx := range cfg.Tariffs {
+		costs[idx].tariff = &cfg.Tariffs[idx]
+	}
+	var (
+		total  float64
+		bands  = make(map[Band]float64)
+		priced = make(map[int64]bool)
+	)
+	// fetch the prices a month at a time, to keep the requests to punapi small
+	for month := startOfDay(from).AddDate(0, 0, 1-from.Day()); month.Before(to); month = month.AddDate(0, 1, 0) {
+		last := month.AddDate(0, 1, 0).Add(-time.Nanosecond)
+		if last.After(to) {
+			last = to
+		}
+		log.Printf("Fetching %s prices for %s", zone, month.Format("2006-01"))
+		prices, err := punapiHourlyPrices(cfg, zone, month, last)
+		if err != nil {
+			return fmt.Errorf("cannot get hourly prices: %w", err)
+		}
+		for _, p := range prices {
+			if p.Time.Before(from) || p.End().After(to) {
+				continue
+			}
+			hour := p.Time.Truncate(time.Hour).Unix()
+			kwh, ok := consumption[hour]
+			if !ok {
+				continue
+			}
+			priced[hour] = true
+			// quarter-hourly prices get their share of the hour's consumption
+			kwh *= float64(p.Minutes) / 60
+			band := bandOf(p.Time.In(market.Location))
+			bands[band] += kwh
+			total += kwh
+			for idx := range costs {
+				costs[idx].energy += kwh * costs[idx].tariff.PriceOf(band, p.Price)
+			}
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("no consumption with a known price in the period")
+	}
+	for idx := range costs {
+		costs[idx].fees = costs[idx].tariff.MonthlyFee * monthsIn(from, to)
+	}
+	sort.SliceStable(costs, func(i, j int) bool {
+		return costs[i].energy+costs[i].fees < costs[j].energy+costs[j].fees
+	})
+
+	fmt.Printf("## Tariffs (%s)\n", zone)
+	fmt.Printf("    query : %s (%s)\n", q, unit)
+	fmt.Printf("    period: %s to %s\n", from.Format("2006-01-02 15:04"), to.Format("2006-01-02 15:04"))
+	fmt.Printf("    usage : %.3f kWh (F1 %.1f%%, F2 %.1f%%, F3 %.1f%%)\n", total,
+		bands[F1]/total*100, bands[F2]/total*100, bands[F3]/total*100)
+	fmt.Printf("    hours : %d priced, %d without a price\n", len(priced), len(consumption)-len(priced))
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "TARIFF\tTYPE\tENERGY\tFEES\tTOTAL\t%s/kWh\n", cfg.Currency)
+	for _, c := range costs {
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.2f\t%.2f\t%.4f\n",
+			c.tariff.Name, c.tariff.Type, c.energy, c.fees, c.energy+c.fees, (c.energy+c.fees)/total)
+	}
+	return tw.Flush()
+}
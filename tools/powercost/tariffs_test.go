@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTariffValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tariff Tariff
+		ok     bool
+	}{
+		{"fixed", Tariff{Name: "a", Type: TariffFixed, Price: 0.2}, true},
+		{"fixed without a price", Tariff{Name: "a", Type: TariffFixed}, false},
+		{"monoraria", Tariff{Name: "a", Type: TariffMonoraria, Spread: 0.01}, true},
+		{"monoraria without a spread", Tariff{Name: "a", Type: TariffMonoraria}, true},
+		{"bioraria", Tariff{Name: "a", Type: TariffBioraria, F1: 0.2, F23: 0.15}, true},
+		{"bioraria without f23", Tariff{Name: "a", Type: TariffBioraria, F1: 0.2}, false},
+		{"indexed bioraria", Tariff{Name: "a", Type: TariffBioraria, Indexed: true}, true},
+		{"trioraria", Tariff{Name: "a", Type: TariffTrioraria, F1: 0.2, F2: 0.18, F3: 0.15}, true},
+		{"trioraria without f3", Tariff{Name: "a", Type: TariffTrioraria, F1: 0.2, F2: 0.18}, false},
+		{"indexed trioraria", Tariff{Name: "a", Type: TariffTrioraria, F1: 0.02, Indexed: true}, true},
+		{"no name", Tariff{Type: TariffFixed, Price: 0.2}, false},
+		{"unknown type", Tariff{Name: "a", Type: "flat", Price: 0.2}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.tariff.Validate(); (err == nil) != tc.ok {
+				t.Errorf("got %v, want ok %v", err, tc.ok)
+			}
+		})
+	}
+}
+
+func TestTariffPriceOf(t *testing.T) {
+	bioraria := Tariff{Type: TariffBioraria, F1: 0.2, F23: 0.15}
+	trioraria := Tariff{Type: TariffTrioraria, F1: 0.2, F2: 0.18, F3: 0.15}
+	indexed := Tariff{Type: TariffTrioraria, F1: 0.03, F2: 0.02, F3: 0.01, Indexed: true}
+	for _, tc := range []struct {
+		name   string
+		tariff Tariff
+		band   Band
+		want   float64
+	}{
+		{"fixed", Tariff{Type: TariffFixed, Price: 0.25}, F1, 0.25},
+		{"monoraria", Tariff{Type: TariffMonoraria, Spread: 0.01}, F3, 0.11},
+		{"bioraria F1", bioraria, F1, 0.2},
+		{"bioraria F2", bioraria, F2, 0.15},
+		{"bioraria F3", bioraria, F3, 0.15},
+		{"trioraria F2", trioraria, F2, 0.18},
+		{"trioraria F3", trioraria, F3, 0.15},
+		{"indexed F1", indexed, F1, 0.13},
+		{"indexed F3", indexed, F3, 0.11},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the zone's price is 100 EUR/MWh
+			if got := tc.tariff.PriceOf(tc.band, 100); math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHourlyConsumption(t *testing.T) {
+	from := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+	// the energy of every hour, stored at its end, with a counter reset
+	b := &fakeBackend{series: map[string][]Sample{"energy": {
+		{Time: from.Add(time.Hour), Value: 500},
+		{Time: from.Add(2 * time.Hour), Value: 1500},
+		{Time: from.Add(3 * time.Hour), Value: -100},
+		{Time: from.Add(4 * time.Hour), Value: 250},
+		{Time: from.Add(5 * time.Hour), Value: 1000},
+	}}}
+	got, err := hourlyConsumption(b, "energy", WattHour, from, from.Add(4*time.Hour))
+	if err != nil {
+		t.Fatalf("hourlyConsumption failed: %v", err)
+	}
+	want := map[int64]float64{
+		from.Unix():                    0.5,
+		from.Add(time.Hour).Unix():     1.5,
+		from.Add(3 * time.Hour).Unix(): 0.25,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for hour, kwh := range want {
+		if got[hour] != kwh {
+			t.Errorf("%s: got %v kWh, want %v", time.Unix(hour, 0).UTC(), got[hour], kwh)
+		}
+	}
+	if _, err := hourlyConsumption(b, "energy", Watt, from, from.Add(time.Hour)); err == nil {
+		t.Error("want an error for power")
+	}
+	if _, err := hourlyConsumption(b, "power", WattHour, from, from.Add(time.Hour)); err == nil {
+		t.Error("want an error for an unknown series")
+	}
+}
+
+func TestMonthsIn(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	for _, tc := range []struct {
+		name     string
+		from, to time.Time
+		want     float64
+	}{
+		{"one month", date(2024, time.January, 1), date(2024, time.February, 1), 1},
+		{"twelve months", date(2023, time.March, 1), date(2024, time.March, 1), 12},
+		{"half of February", date(2024, time.February, 1), date(2024, time.February, 15).Add(12 * time.Hour), 14.5 / 29},
+		{"across two months", date(2024, time.April, 16), date(2024, time.May, 16), 15.0/30 + 15.0/31},
+		{"empty", date(2024, time.April, 16), date(2024, time.April, 16), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := monthsIn(tc.from, tc.to); math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("got %v months, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "fmt"
+
+// Unit is the unit of measure of a Quantity.
+type Unit string
+
+const (
+	Watt         Unit = "W"
+	WattHour     Unit = "Wh"
+	KiloWattHour Unit = "kWh"
+)
+
+// Quantity is a value with its unit of measure, so that power and energy,
+// and Wh and kWh, are never mixed up.
+type Quantity struct {
+	Value float64
+	Unit  Unit
+}
+
+// To converts an energy to the energy unit u. Power cannot be converted to
+// energy, it must be integrated over time instead.
+func (q Quantity) To(u Unit) (Quantity, error) {
+	if q.Unit == u {
+		return q, nil
+	}
+	switch {
+	case q.Unit == WattHour && u == KiloWattHour:
+		return Quantity{Value: q.Value / 1000, Unit: u}, nil
+	case q.Unit == KiloWattHour && u == WattHour:
+		return Quantity{Value: q.Value * 1000, Unit: u}, nil
+	}
+	return Quantity{}, fmt.Errorf("cannot convert %s to %s", q.Unit, u)
+}
+
+func (q Quantity) String() string {
+	return fmt.Sprintf("%.3f %s", q.Value, q.Unit)
+}
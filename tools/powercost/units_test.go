@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestQuantityTo(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		q    Quantity
+		to   Unit
+		want Quantity
+		ok   bool
+	}{
+		{"Wh to kWh", Quantity{1500, WattHour}, KiloWattHour, Quantity{1.5, KiloWattHour}, true},
+		{"kWh to Wh", Quantity{1.5, KiloWattHour}, WattHour, Quantity{1500, WattHour}, true},
+		{"same energy unit", Quantity{3, KiloWattHour}, KiloWattHour, Quantity{3, KiloWattHour}, true},
+		{"same power unit", Quantity{3, Watt}, Watt, Quantity{3, Watt}, true},
+		{"power to energy", Quantity{100, Watt}, WattHour, Quantity{}, false},
+		{"energy to power", Quantity{100, WattHour}, Watt, Quantity{}, false},
+		{"unknown unit", Quantity{1, "J"}, WattHour, Quantity{}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.q.To(tc.to)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got %s", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("got %s and %v, want %s", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestQuantityString(t *testing.T) {
+	if got := (Quantity{1.23456, KiloWattHour}).String(); got != "1.235 kWh" {
+		t.Errorf("got %q, want 1.235 kWh", got)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// weightedSummary prints the average price of zone over period, weighted by
+// the consumption returned by the query q. The query is evaluated at the end
+// of every hour and must return the consumption of the hour that just ended,
+// e.g. `sum(increase(energy_wh_total[1h]))`. Its unit does not matter, since
+// only the relative consumption of every hour is used.
+func weightedSummary(cfg *Config, q, zone string, period *Period) error {
+	from := period.From.Truncate(time.Hour)
+	end := period.To.Truncate(time.Hour)
+	if !end.After(from) {
+		return fmt.Errorf("no complete hour in the period")
+	}
+	samples, err := cfg.backend.QueryRange(q, from.Add(time.Hour), end, time.Hour)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	consumption := make(map[int64]float64, len(samples))
+	for _, s := range samples {
+		consumption[s.Time.Unix()] = s.Value
+	}
+	prices, err := punapiHourlyPrices(cfg, zone, from, period.To)
+	if err != nil {
+		return fmt.Errorf("cannot get hourly prices: %w", err)
+	}
+	var (
+		sum, weightedSum, total float64
+		intervals               int
+	)
+	for _, p := range prices {
+		if p.End().After(end) {
+			break
+		}
+		c, ok := consumption[p.Time.Truncate(time.Hour).Add(time.Hour).Unix()]
+		// negative values come from counter resets
+		if !ok || c < 0 {
+			continue
+		}
+		// quarter-hourly prices get their share of the hour's consumption
+		c *= float64(p.Minutes) / 60
+		sum += p.Price
+		weightedSum += p.Price * c
+		total += c
+		intervals++
+	}
+	if total == 0 {
+		return fmt.Errorf("no consumption with a known price in the period")
+	}
+	fmt.Printf("## Weighted average price (%s)\n", zone)
+	fmt.Printf("    query   : %s\n", q)
+	fmt.Printf("    period  : %s to %s\n", from.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"))
+	fmt.Printf("    prices  : %d\n", intervals)
+	fmt.Printf("    weighted: %.3f EUR/MWh\n", weightedSum/total)
+	fmt.Printf("    average : %.3f EUR/MWh\n", sum/float64(intervals))
+	return nil
+}
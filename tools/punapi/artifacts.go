@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cdplog "github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// maxConsoleLines is the number of console messages kept per fetch.
+const maxConsoleLines = 1000
+
+// consoleLog collects the console messages, JavaScript exceptions and
+// browser log entries of a tab.
+type consoleLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *consoleLog) add(format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.lines) >= maxConsoleLines {
+		return
+	}
+	c.lines = append(c.lines, fmt.Sprintf("%s "+format, append([]interface{}{time.Now().Format(time.RFC3339Nano)}, args...)...))
+}
+
+// String returns the collected messages, one per line.
+func (c *consoleLog) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.lines) == 0 {
+		return ""
+	}
+	return strings.Join(c.lines, "\n") + "\n"
+}
+
+// listenConsole starts collecting the console messages of the tab.
+func listenConsole(ctx context.Context) *consoleLog {
+	var c consoleLog
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch evt := ev.(type) {
+		case *runtime.EventConsoleAPICalled:
+			args := make([]string, 0, len(evt.Args))
+			for _, arg := range evt.Args {
+				if len(arg.Value) > 0 {
+					args = append(args, string(arg.Value))
+				} else {
+					args = append(args, arg.Description)
+				}
+			}
+			c.add("console.%s: %s", evt.Type, strings.Join(args, " "))
+		case *runtime.EventExceptionThrown:
+			if d := evt.ExceptionDetails; d != nil {
+				text := d.Text
+				if d.Exception != nil && d.Exception.Description != "" {
+					text = d.Exception.Description
+				}
+				c.add("exception at %s:%d: %s", d.URL, d.LineNumber, text)
+			}
+		case *cdplog.EventEntryAdded:
+			if e := evt.Entry; e != nil {
+				c.add("log.%s (%s): %s %s", e.Level, e.Source, e.Text, e.URL)
+			}
+		}
+	})
+	return &c
+}
+
+// ArtifactStore saves debugging artifacts of failed fetches. Each failure
+// gets its own directory, and old ones are removed according to the
+// retention settings.
+type ArtifactStore struct {
+	dir        string
+	retention  time.Duration
+	maxEntries int
+	mu         sync.Mutex
+}
+
+// NewArtifactStore returns an ArtifactStore saving into dir. Artifacts older
+// than retention are removed, and at most maxEntries failures are kept. A
+// zero value disables the corresponding limit.
+func NewArtifactStore(dir string, retention time.Duration, maxEntries int) (*ArtifactStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create artifacts directory '%s': %w", dir, err)
+	}
+	return &ArtifactStore{
+		dir:        dir,
+		retention:  retention,
+		maxEntries: maxEntries,
+	}, nil
+}
+
+var unsafePathChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// Capture saves a screenshot, the page HTML, the console log and the error
+// of a failed step, plus a copy of any file in files. It returns the
+// directory holding the artifacts. Individual artifacts that cannot be
+// captured are logged and skipped.
+func (s *ArtifactStore) Capture(ctx context.Context, step string, stepErr error, console *consoleLog, files ...string) (string, error) {
+	now := time.Now()
+	dir := path.Join(s.dir, now.Format("20060102-150405.000")+"-"+unsafePathChars.ReplaceAllString(step, "_"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	log.Printf("Saving failure artifacts of step '%s' to '%s'", step, dir)
+
+	// the step may have failed because ctx expired, but the tab is still
+	// open, so use a context of its own for the captures
+	captureCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 15*time.Second)
+	defer cancel()
+
+	var location string
+	if err := chromedp.Run(captureCtx, chromedp.Location(&location)); err != nil {
+		log.Printf("Failed to get page location: %v", err)
+	}
+	summary := fmt.Sprintf("time: %s\nstep: %s\nurl: %s\nerror: %v\n", now.Format(time.RFC3339), step, location, stepErr)
+	s.write(dir, "error.txt", []byte(summary))
+
+	var screenshot []byte
+	if err := chromedp.Run(captureCtx, chromedp.FullScreenshot(&screenshot, 90)); err != nil {
+		log.Printf("Failed to capture screenshot: %v", err)
+	} else {
+		s.write(dir, "screenshot.jpg", screenshot)
+	}
+
+	var html string
+	if err := chromedp.Run(captureCtx, chromedp.OuterHTML("html", &html, chromedp.ByQuery)); err != nil {
+		log.Printf("Failed to capture page HTML: %v", err)
+	} else {
+		s.write(dir, "page.html", []byte(html))
+	}
+
+	if console != nil {
+		s.write(dir, "console.log", []byte(console.String()))
+	}
+
+	for _, f := range files {
+		if err := copyFile(f, path.Join(dir, path.Base(f))); err != nil {
+			log.Printf("Failed to copy '%s' to artifacts: %v", f, err)
+		}
+	}
+
+	failureArtifacts.WithLabelValues(step).Inc()
+
+	s.prune()
+	return dir, nil
+}
+
+func (s *ArtifactStore) write(dir, name string, data []byte) {
+	if err := os.WriteFile(path.Join(dir, name), data, 0644); err != nil {
+		log.Printf("Failed to write artifact '%s': %v", name, err)
+	}
+}
+
+// prune removes the artifacts that exceed the retention settings.
+func (s *ArtifactStore) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("Failed to list artifacts directory: %v", err)
+		return
+	}
+	var dirs []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if s.retention > 0 {
+			info, err := e.Info()
+			if err == nil && time.Since(info.ModTime()) > s.retention {
+				s.remove(e.Name())
+				continue
+			}
+		}
+		dirs = append(dirs, e.Name())
+	}
+	if s.maxEntries > 0 && len(dirs) > s.maxEntries {
+		// names start with a timestamp, so they sort chronologically
+		sort.Strings(dirs)
+		for _, name := range dirs[:len(dirs)-s.maxEntries] {
+			s.remove(name)
+		}
+	}
+}
+
+func (s *ArtifactStore) remove(name string) {
+	log.Printf("Removing expired artifacts '%s'", name)
+	if err := os.RemoveAll(path.Join(s.dir, name)); err != nil {
+		log.Printf("Failed to remove artifacts '%s': %v", name, err)
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
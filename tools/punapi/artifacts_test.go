@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestArtifactStorePrune(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name       string
+		retention  time.Duration
+		maxEntries int
+		want       []string
+	}{
+		{name: "no limits", want: []string{"20240101-000000.000-a", "20240102-000000.000-b", "20240103-000000.000-c", "20240104-000000.000-d"}},
+		{name: "retention", retention: 36 * time.Hour, want: []string{"20240103-000000.000-c", "20240104-000000.000-d"}},
+		{name: "max entries", maxEntries: 3, want: []string{"20240102-000000.000-b", "20240103-000000.000-c", "20240104-000000.000-d"}},
+		{name: "both", retention: 60 * time.Hour, maxEntries: 1, want: []string{"20240104-000000.000-d"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := NewArtifactStore(path.Join(dir, "artifacts"), tc.retention, tc.maxEntries)
+			if err != nil {
+				t.Fatalf("NewArtifactStore failed: %v", err)
+			}
+			// one failure a day, the last one today
+			for idx, name := range []string{"20240101-000000.000-a", "20240102-000000.000-b", "20240103-000000.000-c", "20240104-000000.000-d"} {
+				d := path.Join(s.dir, name)
+				if err := os.Mkdir(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+				mtime := now.Add(time.Duration(idx-3) * 24 * time.Hour)
+				if err := os.Chtimes(d, mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// files are not failures, and are left alone
+			if err := os.WriteFile(path.Join(s.dir, "README"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			s.prune()
+			entries, err := os.ReadDir(s.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				if e.IsDir() {
+					got = append(got, e.Name())
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if _, err := os.Stat(path.Join(s.dir, "README")); err != nil {
+				t.Errorf("the file was removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestArtifactStoreCapture(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewArtifactStore(path.Join(dir, "artifacts"), 0, 1)
+	if err != nil {
+		t.Fatalf("NewArtifactStore failed: %v", err)
+	}
+	zip := path.Join(dir, "MGP_Prezzi.zip")
+	if err := os.WriteFile(zip, []byte("PK"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := testutil.ToFloat64(failureArtifacts.WithLabelValues("parse"))
+	var console consoleLog
+	console.add("console.log: hello")
+
+	// without a tab, the screenshot and the page HTML are skipped
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		d, err := s.Capture(context.Background(), "parse", errors.New("bad zip"), &console, zip)
+		if err != nil {
+			t.Fatalf("Capture failed: %v", err)
+		}
+		dirs = append(dirs, d)
+		time.Sleep(2 * time.Millisecond)
+	}
+	for _, name := range []string{"error.txt", "console.log", "MGP_Prezzi.zip"} {
+		if _, err := os.Stat(path.Join(dirs[1], name)); err != nil {
+			t.Errorf("missing artifact: %v", err)
+		}
+	}
+	if data, err := os.ReadFile(path.Join(dirs[1], "error.txt")); err != nil || !strings.Contains(string(data), "error: bad zip") {
+		t.Errorf("got error.txt %q and %v", data, err)
+	}
+	// only the last failure is kept
+	if _, err := os.Stat(dirs[0]); !os.IsNotExist(err) {
+		t.Errorf("the first failure was not pruned: %v", err)
+	}
+	if got := testutil.ToFloat64(failureArtifacts.WithLabelValues("parse")) - before; got != 2 {
+		t.Errorf("counted %v captures, want 2", got)
+	}
+}
+
+func TestConsoleLog(t *testing.T) {
+	var c consoleLog
+	if c.String() != "" {
+		t.Errorf("got %q for an empty log", c.String())
+	}
+	for i := 0; i < maxConsoleLines+10; i++ {
+		c.add("line %d", i)
+	}
+	lines := strings.Split(strings.TrimSuffix(c.String(), "\n"), "\n")
+	if len(lines) != maxConsoleLines {
+		t.Fatalf("got %d lines, want %d", len(lines), maxConsoleLines)
+	}
+	if !strings.HasSuffix(lines[0], " line 0") || !strings.HasSuffix(lines[len(lines)-1], " line 999") {
+		t.Errorf("unexpected lines %q ... %q", lines[0], lines[len(lines)-1])
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := path.Join(dir, "src.zip"), path.Join(dir, "dst.zip")
+	if err := os.WriteFile(src, []byte("PK"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFile(src, dst); err != nil {
+		t.Fatalf("copyFile failed: %v", err)
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "PK" {
+		t.Errorf("got %q and %v, want PK", data, err)
+	}
+	if err := copyFile(path.Join(dir, "missing"), dst); err == nil {
+		t.Error("want an error for a missing file")
+	}
+}
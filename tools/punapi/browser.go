@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+)
+
+// BrowserConfig holds the options used to start Chrome.
+type BrowserConfig struct {
+	ShowBrowser bool
+	Debug       bool
+	ChromePath  string
+	Proxy       string
+	DisableGPU  bool
+	// RemoteURL is the DevTools websocket URL of an already running
+	// browser. If set, the options above that configure the Chrome process
+	// are ignored.
+	RemoteURL string
+}
+
+// Browser is a long-lived Chrome process, or a connection to a remote one.
+// Every fetch runs in a new tab, so the process start-up and the site's
+// consent cookies are paid only once.
+type Browser struct {
+	id  int
+	cfg BrowserConfig
+
+	mu            sync.Mutex
+	browserCtx    context.Context
+	cancelFuncs   []func()
+	lastStartTime time.Time
+	// running is set while a local Chrome process started by us is up. It
+	// is not protected by mu so that metrics never wait for health checks.
+	running atomic.Bool
+}
+
+// NewBrowser returns a Browser. Chrome is started lazily on first use.
+func NewBrowser(id int, cfg BrowserConfig) *Browser {
+	return &Browser{id: id, cfg: cfg}
+}
+
+func (b *Browser) allocatorOptions() []chromedp.ExecAllocatorOption {
+	var allocatorOpts []chromedp.ExecAllocatorOption
+	if b.cfg.ShowBrowser {
+		allocatorOpts = append(allocatorOpts, chromedp.NoFirstRun, chromedp.NoDefaultBrowserCheck)
+	} else {
+		allocatorOpts = append(allocatorOpts, chromedp.Headless)
+	}
+	if b.cfg.ChromePath != "" {
+		allocatorOpts = append(allocatorOpts, chromedp.ExecPath(b.cfg.ChromePath))
+	}
+	if b.cfg.Proxy != "" {
+		allocatorOpts = append(allocatorOpts, chromedp.ProxyServer(b.cfg.Proxy))
+	}
+	if b.cfg.DisableGPU {
+		allocatorOpts = append(allocatorOpts, chromedp.Flag("disable-gpu", b.cfg.DisableGPU))
+	}
+	return allocatorOpts
+}
+
+// start launches Chrome. Must be called with b.mu held.
+func (b *Browser) start() error {
+	log.Printf("Starting browser #%d", b.id)
+	var (
+		cancelFuncs []func()
+		ctx         context.Context
+		cancel      context.CancelFunc
+	)
+	if b.cfg.RemoteURL != "" {
+		ctx, cancel = chromedp.NewRemoteAllocator(context.Background(), b.cfg.RemoteURL)
+	} else {
+		ctx, cancel = chromedp.NewExecAllocator(context.Background(), b.allocatorOptions()...)
+	}
+	cancelFuncs = append(cancelFuncs, cancel)
+
+	var opts []chromedp.ContextOption
+	if b.cfg.Debug {
+		opts = append(opts, chromedp.WithDebugf(log.Printf))
+	}
+	ctx, cancel = chromedp.NewContext(ctx, opts...)
+	cancelFuncs = append(cancelFuncs, cancel)
+
+	// an empty Run starts the browser process and its first tab
+	if err := chromedp.Run(ctx); err != nil {
+		for i := len(cancelFuncs) - 1; i >= 0; i-- {
+			cancelFuncs[i]()
+		}
+		return fmt.Errorf("failed to start browser: %w", err)
+	}
+	b.browserCtx = ctx
+	b.cancelFuncs = cancelFuncs
+	b.lastStartTime = time.Now()
+	b.running.Store(b.cfg.RemoteURL == "")
+	return nil
+}
+
+// stop terminates Chrome. Must be called with b.mu held.
+func (b *Browser) stop() {
+	if b.browserCtx == nil {
+		return
+	}
+	log.Printf("Stopping browser #%d", b.id)
+	if b.cfg.RemoteURL != "" {
+		// a remote browser outlives us, so close our first tab rather
+		// than leaving it behind on every restart
+		if c := chromedp.FromContext(b.browserCtx); c != nil && c.Browser != nil && c.Target != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			if err := target.CloseTarget(c.Target.TargetID).Do(cdp.WithExecutor(ctx, c.Browser)); err != nil {
+				log.Printf("Failed to close tab of remote browser #%d: %v", b.id, err)
+			}
+			cancel()
+		}
+	}
+	for i := len(b.cancelFuncs) - 1; i >= 0; i-- {
+		b.cancelFuncs[i]()
+	}
+	b.browserCtx = nil
+	b.cancelFuncs = nil
+	b.running.Store(false)
+}
+
+// Stop terminates Chrome, if running.
+func (b *Browser) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stop()
+}
+
+// Restart terminates Chrome, if running, and starts it again.
+func (b *Browser) Restart() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stop()
+	return b.start()
+}
+
+// NewTab opens a new tab on the browser, starting Chrome if needed. The
+// returned context expires after the given timeout, and the tab is closed
+// when the returned cancel function is called.
+func (b *Browser) NewTab(timeout time.Duration) (context.Context, func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.browserCtx == nil || b.browserCtx.Err() != nil {
+		b.stop()
+		if err := b.start(); err != nil {
+			return nil, nil, err
+		}
+	}
+	tabCtx, cancelTab := chromedp.NewContext(b.browserCtx)
+	ctx, cancelTimeout := context.WithTimeout(tabCtx, timeout)
+	return ctx, func() {
+		cancelTimeout()
+		cancelTab()
+	}, nil
+}
+
+// HealthCheck verifies that the browser responds to DevTools commands. A
+// browser that was never started is considered healthy.
+func (b *Browser) HealthCheck(timeout time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.browserCtx == nil {
+		return nil
+	}
+	if err := b.browserCtx.Err(); err != nil {
+		return fmt.Errorf("browser context is done: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(b.browserCtx, timeout)
+	defer cancel()
+	return chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		_, _, _, _, _, err := browser.GetVersion().Do(ctx)
+		return err
+	}))
+}
+
+// RetryConfig describes how failed fetches are retried.
+type RetryConfig struct {
+	// Retries is the number of retries after the first attempt.
+	Retries int
+	// Backoff is the wait time before the first retry. It doubles at every
+	// retry.
+	Backoff time.Duration
+}
+
+// BrowserPool is a fixed-size set of browsers. Each browser serves one fetch
+// at a time.
+type BrowserPool struct {
+	browsers []*Browser
+	idle     chan *Browser
+	fetch    FetchConfig
+}
+
+// FetchConfig holds the settings of the fetches run by a BrowserPool.
+type FetchConfig struct {
+	Download DownloadConfig
+	Retry    RetryConfig
+	// Scraper is the navigation flow.
+	Scraper *ScraperConfig
+	// Artifacts, if not nil, saves debugging artifacts of failed steps.
+	Artifacts *ArtifactStore
+	// Timeout bounds every single fetch attempt.
+	Timeout time.Duration
+}
+
+// NewBrowserPool returns a pool of `size` browsers, running fetches as
+// described by fc.
+func NewBrowserPool(size int, cfg BrowserConfig, fc FetchConfig) (*BrowserPool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("pool size must be at least 1, got %d", size)
+	}
+	p := BrowserPool{
+		idle:  make(chan *Browser, size),
+		fetch: fc,
+	}
+	for i := 0; i < size; i++ {
+		b := NewBrowser(i, cfg)
+		p.browsers = append(p.browsers, b)
+		p.idle <- b
+	}
+	return &p, nil
+}
+
+// Fetch runs Fetch in a new tab of an idle browser, waiting for one to be
+// available. Failed attempts are retried with exponential backoff, each in a
+// new tab, until ctx is done.
+func (p *BrowserPool) Fetch(ctx context.Context, start, end time.Time) ([]PUNXML, error) {
+	backoff := p.fetch.Retry.Backoff
+	var err error
+	for attempt := 0; attempt <= p.fetch.Retry.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Fetch attempt %d/%d failed, retrying in %s: %v", attempt, p.fetch.Retry.Retries+1, backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			backoff *= 2
+		}
+		var puns []PUNXML
+		puns, err = p.fetchOnce(ctx, start, end)
+		if err == nil {
+			fetches.WithLabelValues("success").Inc()
+			return puns, nil
+		}
+		fetches.WithLabelValues("failure").Inc()
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("giving up after %d attempts: %w", p.fetch.Retry.Retries+1, err)
+}
+
+// fetchOnce runs a single fetch attempt.
+func (p *BrowserPool) fetchOnce(ctx context.Context, start, end time.Time) ([]PUNXML, error) {
+	var puns []PUNXML
+	err := p.withTab(ctx, func(tabCtx context.Context) error {
+		var err error
+		puns, err = Fetch(tabCtx, p.fetch, start, end)
+		return err
+	})
+	return puns, err
+}
+
+// withTab runs f in a new tab of an idle browser, waiting for one to be
+// available. The tab expires after the fetch timeout. If f fails and the
+// browser does not pass a health check afterwards, it is restarted.
+func (p *BrowserPool) withTab(ctx context.Context, f func(tabCtx context.Context) error) error {
+	var b *Browser
+	select {
+	case b = <-p.idle:
+	case <-ctx.Done():
+		return fmt.Errorf("no idle browser: %w", ctx.Err())
+	}
+	defer func() { p.idle <- b }()
+
+	tabCtx, cancel, err := b.NewTab(p.fetch.Timeout)
+	if err != nil {
+		return err
+	}
+	// also stop if the caller goes away
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	err = f(tabCtx)
+	cancel()
+	if err != nil {
+		if herr := b.HealthCheck(10 * time.Second); herr != nil {
+			log.Printf("Browser #%d failed health check after failure, restarting: %v", b.id, herr)
+			browserRestarts.Inc()
+			if rerr := b.Restart(); rerr != nil {
+				log.Printf("Failed to restart browser #%d: %v", b.id, rerr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// RunHealthChecks periodically checks every browser of the pool once and
+// restarts the ones that do not respond, until ctx is done. Busy browsers are
+// checked too: a browser that does not respond would fail its fetch anyway.
+func (p *BrowserPool) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, b := range p.browsers {
+			if err := b.HealthCheck(10 * time.Second); err != nil {
+				log.Printf("Browser #%d failed health check, restarting: %v", b.id, err)
+				browserRestarts.Inc()
+				if err := b.Restart(); err != nil {
+					log.Printf("Failed to restart browser #%d: %v", b.id, err)
+				}
+			}
+		}
+	}
+}
+
+// RunningProcesses returns the number of local Chrome processes that are
+// running. Remote browsers are not counted.
+func (p *BrowserPool) RunningProcesses() int {
+	n := 0
+	for _, b := range p.browsers {
+		if b.running.Load() {
+			n++
+		}
+	}
+	return n
+}
+
+// Close stops all the browsers.
+func (p *BrowserPool) Close() {
+	for _, b := range p.browsers {
+		b.Stop()
+	}
+}
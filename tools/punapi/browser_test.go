@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewBrowserPool(t *testing.T) {
+	if _, err := NewBrowserPool(0, BrowserConfig{}, FetchConfig{Timeout: time.Minute}); err == nil {
+		t.Error("want an error for an empty pool")
+	}
+	p, err := NewBrowserPool(3, BrowserConfig{}, FetchConfig{Timeout: time.Minute})
+	if err != nil {
+		t.Fatalf("NewBrowserPool failed: %v", err)
+	}
+	if len(p.browsers) != 3 || len(p.idle) != 3 {
+		t.Errorf("got %d browsers and %d idle ones, want 3 and 3", len(p.browsers), len(p.idle))
+	}
+	// Chrome is started lazily, so a new browser is healthy
+	for _, b := range p.browsers {
+		if err := b.HealthCheck(time.Second); err != nil {
+			t.Errorf("browser #%d: %v", b.id, err)
+		}
+	}
+}
+
+func TestBrowserPoolFetchNoIdleBrowser(t *testing.T) {
+	p, err := NewBrowserPool(1, BrowserConfig{}, FetchConfig{Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the only browser is busy
+	<-p.idle
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Fetch(ctx, time.Now(), time.Now()); err == nil {
+		t.Error("want an error when no browser is idle")
+	}
+}
+
+func TestBrowserPoolFetchGivesUpWithContext(t *testing.T) {
+	p, err := NewBrowserPool(1, BrowserConfig{}, FetchConfig{Retry: RetryConfig{Retries: 5, Backoff: time.Hour}, Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-p.idle
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.Fetch(ctx, time.Now(), time.Now()); err == nil {
+		t.Error("want an error when no browser is idle")
+	}
+	// no retry is attempted once ctx is done
+	if d := time.Since(start); d > time.Minute {
+		t.Errorf("gave up after %s", d)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// PublishSchedule tells when GME publishes the day-ahead prices: every day at
+// Hour:Minute Italian time, the prices of the following day are published.
+type PublishSchedule struct {
+	Hour, Minute int
+}
+
+// ParsePublishSchedule parses a publication time in the format hh:mm.
+func ParsePublishSchedule(s string) (PublishSchedule, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return PublishSchedule{}, fmt.Errorf("publication time must be hh:mm: %w", err)
+	}
+	return PublishSchedule{Hour: t.Hour(), Minute: t.Minute()}, nil
+}
+
+// LastPublication returns the last publication before now.
+func (p PublishSchedule) LastPublication(now time.Time) time.Time {
+	y, m, d := now.In(market.Location).Date()
+	t := time.Date(y, m, d, p.Hour, p.Minute, 0, 0, market.Location)
+	if t.After(now) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// CacheEntry holds the data of the market days from From to To.
+type CacheEntry struct {
+	Key        string    `json:"key"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	PUN        []PUNXML  `json:"-"`
+	Ts         time.Time `json:"stored"`
+	LastAccess time.Time `json:"last_access"`
+	Rows       int       `json:"rows"`
+}
+
+// Cache is an LRU cache of market data. Only published days are stored, and
+// published prices never change, so entries do not expire and are only
+// evicted when the cache is full.
+type Cache struct {
+	entries  map[string]*list.Element
+	lru      *list.List
+	Capacity int
+	mu       sync.Mutex
+}
+
+// NewCache returns a cache holding up to capacity entries.
+func NewCache(capacity int) *Cache {
+	return &Cache{
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		Capacity: capacity,
+	}
+}
+
+func (c *Cache) Get(k string) ([]PUNXML, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[k]
+	if !ok {
+		cacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	e := el.Value.(*CacheEntry)
+	e.LastAccess = time.Now()
+	c.lru.MoveToFront(el)
+	cacheRequests.WithLabelValues("hit").Inc()
+	return e.PUN, true
+}
+
+// Put stores the data of the market days from `from` to `to` under key k,
+// evicting the least recently used entries if the cache is full.
+func (c *Cache) Put(k string, from, to time.Time, v []PUNXML) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	rows := 0
+	for _, pun := range v {
+		rows += len(pun.Prezzi)
+	}
+	e := &CacheEntry{
+		Key:        k,
+		From:       from,
+		To:         to,
+		PUN:        v,
+		Ts:         now,
+		LastAccess: now,
+		Rows:       rows,
+	}
+	if el, ok := c.entries[k]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[k] = c.lru.PushFront(e)
+	}
+	for c.Capacity > 0 && c.lru.Len() > c.Capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*CacheEntry).Key)
+		cacheEvictions.Inc()
+	}
+	updateLastStoredDay(v)
+}
+
+// Purge removes the entry with key k, or every entry if k is empty. It
+// returns the number of removed entries.
+func (c *Cache) Purge(k string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if k == "" {
+		n := c.lru.Len()
+		c.entries = make(map[string]*list.Element)
+		c.lru.Init()
+		return n
+	}
+	el, ok := c.entries[k]
+	if !ok {
+		return 0
+	}
+	c.lru.Remove(el)
+	delete(c.entries, k)
+	return 1
+}
+
+// Entries returns a copy of every entry, sorted by key.
+func (c *Cache) Entries() []CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]CacheEntry, 0, c.lru.Len())
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		entries = append(entries, *el.Value.(*CacheEntry))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// Len returns the number of entries.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// makeCacheAdminHandler lists the cache entries on GET, and purges them on
+// DELETE, either all of them or only the one in the `key` query parameter.
+func makeCacheAdminHandler(cache *Cache) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			data, err := json.Marshal(cache.Entries())
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(fmt.Sprintf("Failed to marshal cache entries: %v", err)))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(data)
+		case http.MethodDelete:
+			n := cache.Purge(r.URL.Query().Get("key"))
+			_, _ = w.Write([]byte(fmt.Sprintf("Purged %d entries", n)))
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
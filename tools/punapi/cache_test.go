@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublishSchedule(t *testing.T) {
+	if _, err := ParsePublishSchedule("1pm"); err == nil {
+		t.Error("want an error for an invalid publication time")
+	}
+	p, err := ParsePublishSchedule("13:30")
+	if err != nil {
+		t.Fatalf("ParsePublishSchedule failed: %v", err)
+	}
+	// the schedule is in Italian time, whatever the host's time zone
+	for _, tc := range []struct {
+		now, want time.Time
+	}{
+		{time.Date(2024, time.January, 15, 12, 29, 0, 0, time.UTC), time.Date(2024, time.January, 14, 12, 30, 0, 0, time.UTC)},
+		{time.Date(2024, time.January, 15, 12, 30, 0, 0, time.UTC), time.Date(2024, time.January, 15, 12, 30, 0, 0, time.UTC)},
+		// summer time
+		{time.Date(2024, time.July, 15, 11, 30, 0, 0, time.UTC), time.Date(2024, time.July, 15, 11, 30, 0, 0, time.UTC)},
+	} {
+		if got := p.LastPublication(tc.now); !got.Equal(tc.want) {
+			t.Errorf("LastPublication(%s) got %s, want %s", tc.now, got, tc.want)
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache := NewCache(2)
+	day := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+	cache.Put("a", day, day, nil)
+	cache.Put("b", day, day, nil)
+	// a becomes the most recently used entry
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("a is not cached")
+	}
+	cache.Put("c", day, day, nil)
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := cache.Get(k); !ok {
+			t.Errorf("%s was evicted", k)
+		}
+	}
+	if n := cache.Len(); n != 2 {
+		t.Errorf("got %d entries, want 2", n)
+	}
+	if n := cache.Purge("a"); n != 1 {
+		t.Errorf("purged %d entries, want 1", n)
+	}
+	if n := cache.Purge(""); n != 1 {
+		t.Errorf("purged %d entries, want 1", n)
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("got %d entries after purging them all", n)
+	}
+}
+
+func TestCacheAdminHandler(t *testing.T) {
+	cache := NewCache(0)
+	day := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+	cache.Put("b", day, day, []PUNXML{{Prezzi: []Prezzi{{Data: "20240115", Ora: 1}}}})
+	cache.Put("a", day, day, nil)
+	h := makeCacheAdminHandler(cache)
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/admin/cache", nil))
+	var entries []CacheEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+	}
+	if len(entries) != 2 || entries[0].Key != "a" || entries[1].Key != "b" || entries[1].Rows != 1 {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("DELETE", "/admin/cache?key=a", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "Purged 1 entries" {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("POST", "/admin/cache", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
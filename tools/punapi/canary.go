@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Canary modes.
+const (
+	// CanaryModeWalk runs the navigation flow up to the download button,
+	// without clicking on it.
+	CanaryModeWalk = "walk"
+	// CanaryModeDownload downloads a known past day and compares the
+	// checksum of the parsed result with the stored one.
+	CanaryModeDownload = "download"
+)
+
+// CanaryState is the outcome of the last canary check.
+type CanaryState struct {
+	// Checked is false until the first check completes.
+	Checked    bool      `json:"checked"`
+	Healthy    bool      `json:"healthy"`
+	FailedStep string    `json:"failed_step,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	LastCheck  time.Time `json:"last_check"`
+	Checksum   string    `json:"checksum,omitempty"`
+}
+
+// Canary periodically verifies that the navigation flow still works, so that
+// changes to the GME site are noticed before the exporter goes stale.
+type Canary struct {
+	pool *BrowserPool
+	mode string
+	day  time.Time
+	// checksumFile stores the checksum of the canary day's data. If it does
+	// not exist, it is created with the checksum of the first successful
+	// download.
+	checksumFile string
+
+	mu       sync.Mutex
+	state    CanaryState
+	checksum string
+}
+
+// NewCanary returns a canary running the flow of pool in the given mode. In
+// download mode, day is the known past day to download and checksumFile is
+// required, so that the reference checksum survives restarts rather than
+// being taken again from whatever the first download after a restart returns.
+func NewCanary(pool *BrowserPool, mode string, day time.Time, checksumFile string) (*Canary, error) {
+	if mode != CanaryModeWalk && mode != CanaryModeDownload {
+		return nil, fmt.Errorf("unknown canary mode '%s'", mode)
+	}
+	if mode == CanaryModeDownload && checksumFile == "" {
+		return nil, fmt.Errorf("canary mode '%s' requires a checksum file", mode)
+	}
+	c := Canary{
+		pool:         pool,
+		mode:         mode,
+		day:          day,
+		checksumFile: checksumFile,
+	}
+	if checksumFile != "" {
+		data, err := os.ReadFile(checksumFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read canary checksum file: %w", err)
+		}
+		c.checksum = strings.TrimSpace(string(data))
+	}
+	return &c, nil
+}
+
+// checksumPUNs returns a checksum of the parsed data. encoding/json sorts
+// map keys, so the result does not depend on the order of the columns.
+func checksumPUNs(puns []PUNXML) (string, error) {
+	data, err := json.Marshal(puns)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal PUNs: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// walk runs every step of the flow, but only waits for the download button
+// instead of clicking on it.
+func (c *Canary) walk(ctx context.Context) error {
+	return c.pool.withTab(ctx, func(tabCtx context.Context) error {
+		console := listenConsole(tabCtx)
+		scraper := c.pool.fetch.Scraper
+		for _, step := range scraper.Steps {
+			if step.Action == ActionDownload {
+				step.Action = ActionWait
+			}
+			if err := chromedp.Run(tabCtx, scraper.Action(step, c.day, c.day, nil)); err != nil {
+				return stepFailed(tabCtx, c.pool.fetch.Artifacts, console, step.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// download fetches the canary day and verifies its checksum against the
+// stored one. If there is none yet, the checksum is stored in the checksum
+// file and becomes the reference.
+func (c *Canary) download(ctx context.Context) (string, error) {
+	puns, err := c.pool.fetchOnce(ctx, c.day, c.day)
+	if err != nil {
+		return "", err
+	}
+	sum, err := checksumPUNs(puns)
+	if err != nil {
+		return "", &StepError{Step: "checksum", Err: err}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checksum == "" {
+		log.Printf("Storing canary checksum %s for %s in %s", sum, c.day.Format("2006-01-02"), c.checksumFile)
+		if err := os.WriteFile(c.checksumFile, []byte(sum+"\n"), 0644); err != nil {
+			return sum, &StepError{Step: "checksum", Err: fmt.Errorf("failed to write canary checksum file: %w", err)}
+		}
+		c.checksum = sum
+		return sum, nil
+	}
+	if sum != c.checksum {
+		return sum, &StepError{Step: "checksum", Err: fmt.Errorf("checksum mismatch: got %s, want %s", sum, c.checksum)}
+	}
+	return sum, nil
+}
+
+// Check runs the canary once and updates its state.
+func (c *Canary) Check(ctx context.Context) error {
+	log.Printf("Running scraper canary in %s mode", c.mode)
+	var (
+		sum string
+		err error
+	)
+	if c.mode == CanaryModeDownload {
+		sum, err = c.download(ctx)
+	} else {
+		err = c.walk(ctx)
+	}
+	state := CanaryState{
+		Checked:   true,
+		Healthy:   err == nil,
+		LastCheck: time.Now(),
+		Checksum:  sum,
+	}
+	if err != nil {
+		state.Error = err.Error()
+		state.FailedStep = "unknown"
+		var serr *StepError
+		if errors.As(err, &serr) {
+			state.FailedStep = serr.Step
+		}
+		log.Printf("Scraper canary failed at step '%s': %v", state.FailedStep, err)
+	}
+	c.mu.Lock()
+	c.state = state
+	c.mu.Unlock()
+
+	scraperFailedStep.Reset()
+	if state.Healthy {
+		scraperHealthy.Set(1)
+	} else {
+		scraperHealthy.Set(0)
+		scraperFailedStep.WithLabelValues(state.FailedStep).Set(1)
+	}
+	scraperLastCheck.Set(float64(state.LastCheck.Unix()))
+	return err
+}
+
+// State returns the outcome of the last check.
+func (c *Canary) State() CanaryState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Run checks the scraper every interval. It does not return.
+func (c *Canary) Run(interval time.Duration) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		_ = c.Check(ctx)
+		cancel()
+		time.Sleep(interval)
+	}
+}
+
+// makeReadyzHandler reports whether the scraper works, according to the
+// canary. If canary is nil, punapi is always ready.
+func makeReadyzHandler(canary *Canary) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if canary == nil {
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+		state := canary.State()
+		data, err := json.Marshal(state)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(fmt.Sprintf("Failed to marshal canary state: %v", err)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !state.Checked || !state.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write(data)
+	}
+}
+
+// healthzHandler reports that the process is alive.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("ok"))
+}
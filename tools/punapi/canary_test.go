@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewCanary(t *testing.T) {
+	day := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+	if _, err := NewCanary(nil, CanaryModeDownload, day, ""); err == nil {
+		t.Error("want an error in download mode without a checksum file")
+	}
+	if _, err := NewCanary(nil, "fetch", day, ""); err == nil {
+		t.Error("want an error for an unknown mode")
+	}
+	if _, err := NewCanary(nil, CanaryModeWalk, day, ""); err != nil {
+		t.Errorf("walk mode without a checksum file failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "canary.sum")
+	c, err := NewCanary(nil, CanaryModeDownload, day, path)
+	if err != nil {
+		t.Fatalf("NewCanary with a missing checksum file failed: %v", err)
+	}
+	if c.checksum != "" {
+		t.Errorf("got checksum %q before the first download", c.checksum)
+	}
+	if err := os.WriteFile(path, []byte("abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = NewCanary(nil, CanaryModeDownload, day, path); err != nil {
+		t.Fatalf("NewCanary failed: %v", err)
+	}
+	if c.checksum != "abc" {
+		t.Errorf("got checksum %q, want abc", c.checksum)
+	}
+}
+
+func TestChecksumPUNs(t *testing.T) {
+	a := []PUNXML{{Prezzi: []Prezzi{{Data: "20240115", Ora: 1, Prices: map[string]Price{"PUN": 1, "NORD": 2}}}}}
+	b := []PUNXML{{Prezzi: []Prezzi{{Data: "20240115", Ora: 1, Prices: map[string]Price{"NORD": 2, "PUN": 1}}}}}
+	c := []PUNXML{{Prezzi: []Prezzi{{Data: "20240115", Ora: 1, Prices: map[string]Price{"PUN": 1, "NORD": 3}}}}}
+	sumA, err := checksumPUNs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sumB, _ := checksumPUNs(b); sumB != sumA {
+		t.Errorf("the order of the columns changed the checksum: %s and %s", sumA, sumB)
+	}
+	if sumC, _ := checksumPUNs(c); sumC == sumA {
+		t.Error("a different price has the same checksum")
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	canary, err := NewCanary(nil, CanaryModeWalk, time.Now(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		canary *Canary
+		state  CanaryState
+		want   int
+	}{
+		{name: "no canary", want: http.StatusOK},
+		{name: "not checked yet", canary: canary, want: http.StatusServiceUnavailable},
+		{name: "healthy", canary: canary, state: CanaryState{Checked: true, Healthy: true}, want: http.StatusOK},
+		{name: "unhealthy", canary: canary, state: CanaryState{Checked: true, FailedStep: "download"}, want: http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.canary != nil {
+				tc.canary.state = tc.state
+			}
+			w := httptest.NewRecorder()
+			makeReadyzHandler(tc.canary)(w, httptest.NewRequest("GET", "/readyz", nil))
+			if w.Code != tc.want {
+				t.Errorf("got status %d, want %d", w.Code, tc.want)
+			}
+			if tc.canary == nil {
+				return
+			}
+			var got CanaryState
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+			}
+			if got.FailedStep != tc.state.FailedStep {
+				t.Errorf("got failed step %q, want %q", got.FailedStep, tc.state.FailedStep)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// dayKey returns the cache key of the market day of t.
+func dayKey(t time.Time) string {
+	year, month, day := t.In(market.Location).Date()
+	return fmt.Sprintf("%d-%d-%d", year, month, day)
+}
+
+// midnight returns the start of the market day of t, in Italian time.
+func midnight(t time.Time) time.Time {
+	year, month, day := t.In(market.Location).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, market.Location)
+}
+
+// PublishedThrough returns the last market day whose prices are published at
+// the time now.
+func (p PublishSchedule) PublishedThrough(now time.Time) time.Time {
+	return midnight(p.LastPublication(now)).AddDate(0, 0, 1)
+}
+
+// splitDays groups the rows of the fetched data by market day, keyed by the
+// day's cache key. The data of a range of days may come in one or more XML
+// files, so rows are grouped by their Data field rather than by file.
+func splitDays(puns []PUNXML) (map[string]PUNXML, error) {
+	days := make(map[string]PUNXML)
+	for _, pun := range puns {
+		for _, p := range pun.Prezzi {
+			day, err := time.ParseInLocation("20060102", p.Data, market.Location)
+			if err != nil {
+				return nil, fmt.Errorf("invalid market day '%s': %w", p.Data, err)
+			}
+			k := dayKey(day)
+			d := days[k]
+			d.XMLName = pun.XMLName
+			d.Prezzi = append(d.Prezzi, p)
+			days[k] = d
+		}
+	}
+	for k, d := range days {
+		sort.Slice(d.Prezzi, func(i, j int) bool {
+			if d.Prezzi[i].Ora != d.Prezzi[j].Ora {
+				return d.Prezzi[i].Ora < d.Prezzi[j].Ora
+			}
+			return d.Prezzi[i].Periodo < d.Prezzi[j].Periodo
+		})
+		days[k] = d
+	}
+	return days, nil
+}
+
+// DayStore returns the market data of single days. Days are cached one by
+// one, so that any period can be assembled from the cached days, fetching only
+// the missing ones.
+type DayStore struct {
+	cache   *Cache
+	publish PublishSchedule
+	fetch   func(ctx context.Context, start, end time.Time) ([]PUNXML, error)
+
+	mu sync.Mutex
+	// inflight holds the running fetches by range, so that concurrent
+	// requests missing the same days wait for one fetch
+	inflight map[string]*dayFetch
+}
+
+// NewDayStore returns a DayStore caching into cache and fetching with pool
+// the days published according to publish.
+func NewDayStore(cache *Cache, pool *BrowserPool, publish PublishSchedule) *DayStore {
+	return &DayStore{cache: cache, publish: publish, fetch: pool.Fetch, inflight: make(map[string]*dayFetch)}
+}
+
+// dayRange is a range of consecutive days.
+type dayRange struct {
+	from, to time.Time
+}
+
+// dayFetch is a running fetch of a range of days, shared by the requests
+// missing them.
+type dayFetch struct {
+	done chan struct{}
+	days map[string]PUNXML
+	err  error
+}
+
+// Days returns the data of every published market day from `from` to `to`,
+// one PUNXML per day, in chronological order. Days after the last published
+// one are ignored. Missing days are fetched, one fetch per range of
+// consecutive missing days.
+func (s *DayStore) Days(ctx context.Context, from, to time.Time) ([]PUNXML, error) {
+	from, to = midnight(from), midnight(to)
+	if last := s.publish.PublishedThrough(time.Now()); to.After(last) {
+		to = last
+	}
+	if from.After(to) {
+		return nil, nil
+	}
+
+	found := make(map[string]PUNXML)
+	var (
+		missing []dayRange
+		current *dayRange
+	)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		k := dayKey(day)
+		if puns, ok := s.cache.Get(k); ok {
+			if len(puns) == 1 {
+				found[k] = puns[0]
+			}
+			current = nil
+			continue
+		}
+		if current == nil {
+			missing = append(missing, dayRange{from: day, to: day})
+			current = &missing[len(missing)-1]
+		} else {
+			current.to = day
+		}
+	}
+
+	for _, r := range missing {
+		days, err := s.fetchShared(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		for k, d := range days {
+			found[k] = d
+		}
+	}
+
+	var result []PUNXML
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		if d, ok := found[dayKey(day)]; ok {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+// fetchShared fetches and caches the days of r, returning them by cache key.
+// Concurrent calls for the same range share one fetch, and its outcome: the
+// callers that did not start it stop waiting when their ctx is done.
+func (s *DayStore) fetchShared(ctx context.Context, r dayRange) (map[string]PUNXML, error) {
+	k := dayKey(r.from) + "/" + dayKey(r.to)
+	s.mu.Lock()
+	if f, ok := s.inflight[k]; ok {
+		s.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.days, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	f := &dayFetch{done: make(chan struct{})}
+	s.inflight[k] = f
+	s.mu.Unlock()
+
+	f.days, f.err = s.fetchDays(ctx, r)
+	s.mu.Lock()
+	delete(s.inflight, k)
+	s.mu.Unlock()
+	close(f.done)
+	return f.days, f.err
+}
+
+// fetchDays fetches the days of r and caches them, returning them by cache
+// key. Days missing from the fetched data are logged and left out.
+func (s *DayStore) fetchDays(ctx context.Context, r dayRange) (map[string]PUNXML, error) {
+	log.Printf("Cache miss for %s to %s", r.from.Format("2006-01-02"), r.to.Format("2006-01-02"))
+	puns, err := s.fetch(ctx, r.from, r.to)
+	if err != nil {
+		return nil, err
+	}
+	days, err := splitDays(puns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split fetched data by day: %w", err)
+	}
+	found := make(map[string]PUNXML)
+	for day := r.from; !day.After(r.to); day = day.AddDate(0, 0, 1) {
+		k := dayKey(day)
+		d, ok := days[k]
+		if !ok {
+			log.Printf("Warning: no data for %s in the fetched data", day.Format("2006-01-02"))
+			continue
+		}
+		s.cache.Put(k, day, day, []PUNXML{d})
+		found[k] = d
+	}
+	return found, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+func TestSplitDays(t *testing.T) {
+	puns := []PUNXML{
+		{Prezzi: []Prezzi{
+			{Data: "20240331", Ora: 2},
+			{Data: "20240330", Ora: 24},
+			{Data: "20240331", Ora: 1},
+		}},
+		{Prezzi: []Prezzi{
+			{Data: "20240330", Ora: 1},
+			{Data: "20240401", Ora: 2},
+			{Data: "20240401", Ora: 1},
+			{Data: "20240331", Ora: 23},
+		}},
+	}
+	days, err := splitDays(puns)
+	if err != nil {
+		t.Fatalf("splitDays failed: %v", err)
+	}
+	for _, tc := range []struct {
+		key  string
+		rows []int
+	}{
+		{"2024-3-30", []int{1, 24}},
+		{"2024-3-31", []int{1, 2, 23}},
+		{"2024-4-1", []int{1, 2}},
+	} {
+		t.Run(tc.key, func(t *testing.T) {
+			d, ok := days[tc.key]
+			if !ok {
+				t.Fatalf("no day %s in %v", tc.key, days)
+			}
+			if len(d.Prezzi) != len(tc.rows) {
+				t.Fatalf("got %d rows, want %d", len(d.Prezzi), len(tc.rows))
+			}
+			for idx, p := range d.Prezzi {
+				if p.Ora != tc.rows[idx] {
+					t.Errorf("row #%d is Ora %d, want %d", idx, p.Ora, tc.rows[idx])
+				}
+			}
+		})
+	}
+	if len(days) != 3 {
+		t.Errorf("got %d days, want 3", len(days))
+	}
+	if _, err := splitDays([]PUNXML{{Prezzi: []Prezzi{{Data: "31/03/2024"}}}}); err == nil {
+		t.Error("want an error for an invalid market day")
+	}
+}
+
+func TestMarketDay(t *testing.T) {
+	for _, tc := range []struct {
+		t        string
+		key      string
+		midnight string
+	}{
+		{"2024-01-14T22:59:00Z", "2024-1-14", "2024-01-13T23:00:00Z"},
+		// midnight in Italy is 23:00 UTC in winter and 22:00 UTC in summer
+		{"2024-01-14T23:00:00Z", "2024-1-15", "2024-01-14T23:00:00Z"},
+		{"2024-07-14T22:30:00Z", "2024-7-15", "2024-07-14T22:00:00Z"},
+		{"2024-10-27T23:30:00Z", "2024-10-28", "2024-10-27T23:00:00Z"},
+	} {
+		t.Run(tc.t, func(t *testing.T) {
+			ts, err := time.Parse(time.RFC3339, tc.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dayKey(ts); got != tc.key {
+				t.Errorf("got key %s, want %s", got, tc.key)
+			}
+			if got := midnight(ts).UTC().Format(time.RFC3339); got != tc.midnight {
+				t.Errorf("got midnight %s, want %s", got, tc.midnight)
+			}
+		})
+	}
+}
+
+func TestPublishedThrough(t *testing.T) {
+	publish := PublishSchedule{Hour: 13}
+	for _, tc := range []struct {
+		now  time.Time
+		want string
+	}{
+		// 12:59 and 13:00 in Italy
+		{time.Date(2024, time.January, 15, 11, 59, 0, 0, time.UTC), "2024-01-15"},
+		{time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC), "2024-01-16"},
+	} {
+		t.Run(tc.now.Format(time.RFC3339), func(t *testing.T) {
+			if got := publish.PublishedThrough(tc.now).Format("2006-01-02"); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDayStoreCachedDays(t *testing.T) {
+	cache := NewCache(0)
+	for _, data := range []string{"20240330", "20240331", "20240401"} {
+		day, err := time.ParseInLocation("20060102", data, market.Location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Put(dayKey(day), day, day, []PUNXML{{Prezzi: []Prezzi{{Data: data, Ora: 1}}}})
+	}
+	// no pool: every day must come from the cache
+	store := NewDayStore(cache, nil, PublishSchedule{Hour: 13})
+	from := time.Date(2024, time.March, 30, 12, 0, 0, 0, market.Location)
+	to := time.Date(2024, time.April, 1, 12, 0, 0, 0, market.Location)
+	days, err := store.Days(context.Background(), from, to)
+	if err != nil {
+		t.Fatalf("Days failed: %v", err)
+	}
+	var got []string
+	for _, d := range days {
+		got = append(got, d.Prezzi[0].Data)
+	}
+	if len(got) != 3 || got[0] != "20240330" || got[1] != "20240331" || got[2] != "20240401" {
+		t.Errorf("got days %v, want 20240330, 20240331 and 20240401", got)
+	}
+
+	// days that are not published yet are left out
+	days, err = store.Days(context.Background(), time.Now().AddDate(0, 0, 3), time.Now().AddDate(0, 0, 5))
+	if err != nil || len(days) != 0 {
+		t.Errorf("got %d days and %v for unpublished days, want none", len(days), err)
+	}
+}
+
+func TestDayStoreSharedFetch(t *testing.T) {
+	store := NewDayStore(NewCache(0), nil, PublishSchedule{Hour: 13})
+	var calls atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	store.fetch = func(ctx context.Context, start, end time.Time) ([]PUNXML, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return []PUNXML{{Prezzi: []Prezzi{{Data: "20240330", Ora: 1}, {Data: "20240331", Ora: 1}}}}, nil
+	}
+	from := time.Date(2024, time.March, 30, 0, 0, 0, 0, market.Location)
+	to := from.AddDate(0, 0, 1)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			days, err := store.Days(context.Background(), from, to)
+			if err == nil && len(days) != 2 {
+				err = errors.New("wrong number of days")
+			}
+			errs <- err
+		}()
+	}
+	<-started
+	// let the other requests find the running fetch
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Days failed: %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fetched %d times, want once", n)
+	}
+}
+
+func TestDayStoreSharedFetchError(t *testing.T) {
+	store := NewDayStore(NewCache(0), nil, PublishSchedule{Hour: 13})
+	fetchErr := errors.New("site down")
+	started, release := make(chan struct{}), make(chan struct{})
+	store.fetch = func(ctx context.Context, start, end time.Time) ([]PUNXML, error) {
+		close(started)
+		<-release
+		return nil, fetchErr
+	}
+	day := time.Date(2024, time.March, 30, 0, 0, 0, 0, market.Location)
+	leader := make(chan error)
+	go func() {
+		_, err := store.Days(context.Background(), day, day)
+		leader <- err
+	}()
+	<-started
+
+	// a waiting request gives up with its own context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := store.Days(ctx, day, day); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a deadline error", err)
+	}
+	follower := make(chan error)
+	go func() {
+		_, err := store.Days(context.Background(), day, day)
+		follower <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	// the failure is shared, and not cached
+	for _, ch := range []chan error{leader, follower} {
+		if err := <-ch; !errors.Is(err, fetchErr) {
+			t.Errorf("got %v, want %v", err, fetchErr)
+		}
+	}
+	if len(store.inflight) != 0 {
+		t.Errorf("%d fetches still running", len(store.inflight))
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// DownloadMode selects how the files downloaded by the browser reach punapi.
+type DownloadMode string
+
+const (
+	// DownloadModeAuto picks DownloadModeBrowser for a local browser,
+	// DownloadModeShared for a remote browser with a shared download
+	// directory and DownloadModeIntercept otherwise.
+	DownloadModeAuto DownloadMode = "auto"
+	// DownloadModeBrowser lets Chrome save the file into a local directory.
+	DownloadModeBrowser DownloadMode = "browser"
+	// DownloadModeShared lets a remote Chrome save the file into a
+	// directory that is shared with punapi, e.g. a volume mounted in both
+	// containers.
+	DownloadModeShared DownloadMode = "shared"
+	// DownloadModeIntercept intercepts the download response via the
+	// DevTools protocol and saves its body locally, so it works with a
+	// browser running on any filesystem.
+	DownloadModeIntercept DownloadMode = "intercept"
+)
+
+// DownloadConfig describes where downloaded files are saved.
+type DownloadConfig struct {
+	Mode DownloadMode
+	// Dir is the local directory where the per-fetch download directories
+	// are created. If empty, the default temporary directory is used.
+	Dir string
+	// RemoteDir is Dir as seen by a remote browser. Only used with
+	// DownloadModeShared.
+	RemoteDir string
+	// Timeout is how long to wait for a download to complete after the
+	// download button is clicked.
+	Timeout time.Duration
+}
+
+// Resolve returns a copy of the configuration with DownloadModeAuto replaced
+// by the concrete mode, and validates it.
+func (c DownloadConfig) Resolve(remote bool) (DownloadConfig, error) {
+	if c.Mode == "" || c.Mode == DownloadModeAuto {
+		switch {
+		case !remote:
+			c.Mode = DownloadModeBrowser
+		case c.RemoteDir != "":
+			c.Mode = DownloadModeShared
+		default:
+			c.Mode = DownloadModeIntercept
+		}
+	}
+	switch c.Mode {
+	case DownloadModeBrowser, DownloadModeIntercept:
+	case DownloadModeShared:
+		if c.Dir == "" || c.RemoteDir == "" {
+			return c, fmt.Errorf("download mode %q requires both the local and the remote download directory", c.Mode)
+		}
+	default:
+		return c, fmt.Errorf("unknown download mode %q", c.Mode)
+	}
+	return c, nil
+}
+
+// browserDownloadDir returns the path of the local directory dir as seen by
+// the browser.
+func (c DownloadConfig) browserDownloadDir(dir string) string {
+	if c.Mode == DownloadModeShared {
+		return path.Join(c.RemoteDir, path.Base(dir))
+	}
+	return dir
+}
+
+// errDownloadCanceled is returned when the browser reports that the download
+// was canceled, which is also how interrupted downloads are reported.
+var errDownloadCanceled = errors.New("download canceled")
+
+type downloadResult struct {
+	name string
+	err  error
+}
+
+// downloadWaiter collects the outcome of a single download. Only the first
+// outcome is kept, so repeated or late events are harmless.
+type downloadWaiter struct {
+	once   sync.Once
+	result chan downloadResult
+}
+
+func newDownloadWaiter() *downloadWaiter {
+	return &downloadWaiter{result: make(chan downloadResult, 1)}
+}
+
+// finish records the outcome of the download: the name of the downloaded file
+// in the download directory, or an error.
+func (w *downloadWaiter) finish(name string, err error) {
+	w.once.Do(func() {
+		w.result <- downloadResult{name: name, err: err}
+	})
+}
+
+// Wait returns the name of the downloaded file once the download is over. It
+// gives up when ctx is done or after timeout.
+func (w *downloadWaiter) Wait(ctx context.Context, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	select {
+	case r := <-w.result:
+		return r.name, r.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("download did not complete: %w", ctx.Err())
+	}
+}
+
+// listenDownload tracks the browser's download events on the tab and reports
+// the outcome of the first download to w.
+func listenDownload(ctx context.Context, w *downloadWaiter) {
+	chromedp.ListenTarget(ctx, downloadListener(w))
+}
+
+// downloadListener returns a listener of the browser's download events that
+// reports the outcome of the first download to w.
+func downloadListener(w *downloadWaiter) func(ev interface{}) {
+	var (
+		mu   sync.Mutex
+		guid string
+	)
+	return func(ev interface{}) {
+		switch evt := ev.(type) {
+		case *browser.EventDownloadWillBegin:
+			log.Printf("Download of '%s' started", evt.SuggestedFilename)
+			mu.Lock()
+			if guid == "" {
+				guid = evt.GUID
+			}
+			mu.Unlock()
+		case *browser.EventDownloadProgress:
+			mu.Lock()
+			ignore := guid != "" && evt.GUID != guid
+			mu.Unlock()
+			if ignore {
+				return
+			}
+			completed := "(unknown)"
+			if evt.TotalBytes != 0 {
+				completed = fmt.Sprintf("%0.2f%%", evt.ReceivedBytes/evt.TotalBytes*100.0)
+			}
+			log.Printf("state: %s, completed: %s", evt.State.String(), completed)
+			switch evt.State {
+			case browser.DownloadProgressStateCompleted:
+				w.finish(evt.GUID, nil)
+			case browser.DownloadProgressStateCanceled:
+				w.finish("", errDownloadCanceled)
+			}
+		}
+	}
+}
+
+// interceptDownload returns an action that enables request interception on
+// the tab. The first response that looks like a file download is saved into
+// dir and aborted, so the browser does not download it itself. The outcome is
+// reported to w.
+func interceptDownload(ctx context.Context, dir string, w *downloadWaiter) chromedp.Action {
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		evt, ok := ev.(*fetch.EventRequestPaused)
+		if !ok {
+			return
+		}
+		// the listener must not block, so the DevTools commands are sent
+		// from a separate goroutine
+		go func() {
+			c := chromedp.FromContext(ctx)
+			execCtx := cdp.WithExecutor(ctx, c.Target)
+			if !isDownloadResponse(evt.ResponseHeaders) {
+				if err := fetch.ContinueRequest(evt.RequestID).Do(execCtx); err != nil {
+					log.Printf("Failed to continue intercepted request: %v", err)
+				}
+				return
+			}
+			log.Printf("Intercepted download from %s", evt.Request.URL)
+			body, err := fetch.GetResponseBody(evt.RequestID).Do(execCtx)
+			if err != nil {
+				w.finish("", fmt.Errorf("failed to get intercepted download body: %w", err))
+				return
+			}
+			name := string(evt.RequestID)
+			if err := os.WriteFile(path.Join(dir, name), body, 0644); err != nil {
+				w.finish("", fmt.Errorf("failed to save intercepted download: %w", err))
+				return
+			}
+			// the file is saved, no need to let the browser download it
+			if err := fetch.FailRequest(evt.RequestID, network.ErrorReasonAborted).Do(execCtx); err != nil {
+				log.Printf("Failed to abort intercepted download: %v", err)
+			}
+			w.finish(name, nil)
+		}()
+	})
+	return fetch.Enable().WithPatterns([]*fetch.RequestPattern{
+		{URLPattern: "*", RequestStage: fetch.RequestStageResponse},
+	})
+}
+
+// isDownloadResponse tells whether the response headers describe a file
+// download rather than a page.
+func isDownloadResponse(headers []*fetch.HeaderEntry) bool {
+	for _, h := range headers {
+		switch strings.ToLower(h.Name) {
+		case "content-disposition":
+			disposition, _, err := mime.ParseMediaType(h.Value)
+			if err == nil && disposition == "attachment" {
+				return true
+			}
+		case "content-type":
+			mediaType, _, err := mime.ParseMediaType(h.Value)
+			if err == nil && (mediaType == "application/zip" || mediaType == "application/x-zip-compressed" || mediaType == "application/octet-stream") {
+				return true
+			}
+		}
+	}
+	return false
+}
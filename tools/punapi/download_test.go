@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/fetch"
+)
+
+func TestDownloadConfigResolve(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    DownloadConfig
+		remote bool
+		want   DownloadMode
+		ok     bool
+	}{
+		{name: "local default", cfg: DownloadConfig{}, want: DownloadModeBrowser, ok: true},
+		{name: "local auto", cfg: DownloadConfig{Mode: DownloadModeAuto, RemoteDir: "/downloads"}, want: DownloadModeBrowser, ok: true},
+		{name: "remote default", cfg: DownloadConfig{}, remote: true, want: DownloadModeIntercept, ok: true},
+		{
+			name:   "remote with a shared directory",
+			cfg:    DownloadConfig{Dir: "/srv/downloads", RemoteDir: "/downloads"},
+			remote: true,
+			want:   DownloadModeShared,
+			ok:     true,
+		},
+		{name: "explicit intercept", cfg: DownloadConfig{Mode: DownloadModeIntercept}, want: DownloadModeIntercept, ok: true},
+		{name: "shared without the local directory", cfg: DownloadConfig{Mode: DownloadModeShared, RemoteDir: "/downloads"}, remote: true},
+		{name: "shared without the remote directory", cfg: DownloadConfig{Mode: DownloadModeShared, Dir: "/srv/downloads"}, remote: true},
+		{name: "unknown mode", cfg: DownloadConfig{Mode: "ftp"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.cfg.Resolve(tc.remote)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got mode %q", got.Mode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve failed: %v", err)
+			}
+			if got.Mode != tc.want {
+				t.Errorf("got mode %q, want %q", got.Mode, tc.want)
+			}
+		})
+	}
+}
+
+func TestBrowserDownloadDir(t *testing.T) {
+	shared := DownloadConfig{Mode: DownloadModeShared, Dir: "/srv/downloads", RemoteDir: "/downloads"}
+	if got := shared.browserDownloadDir("/srv/downloads/punapi123"); got != "/downloads/punapi123" {
+		t.Errorf("shared: got %s, want /downloads/punapi123", got)
+	}
+	local := DownloadConfig{Mode: DownloadModeBrowser}
+	if got := local.browserDownloadDir("/tmp/punapi123"); got != "/tmp/punapi123" {
+		t.Errorf("browser: got %s, want /tmp/punapi123", got)
+	}
+}
+
+func TestIsDownloadResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		headers []*fetch.HeaderEntry
+		want    bool
+	}{
+		{name: "no headers"},
+		{name: "page", headers: []*fetch.HeaderEntry{{Name: "Content-Type", Value: "text/html; charset=utf-8"}}},
+		{
+			name:    "attachment",
+			headers: []*fetch.HeaderEntry{{Name: "Content-Disposition", Value: `attachment; filename="MGP_Prezzi.zip"`}},
+			want:    true,
+		},
+		{name: "inline", headers: []*fetch.HeaderEntry{{Name: "content-disposition", Value: "inline"}}},
+		{name: "invalid disposition", headers: []*fetch.HeaderEntry{{Name: "Content-Disposition", Value: `attachment; filename="`}}},
+		{name: "zip", headers: []*fetch.HeaderEntry{{Name: "content-type", Value: "application/zip"}}, want: true},
+		{name: "legacy zip", headers: []*fetch.HeaderEntry{{Name: "Content-Type", Value: "application/x-zip-compressed"}}, want: true},
+		{name: "binary", headers: []*fetch.HeaderEntry{{Name: "CONTENT-TYPE", Value: "application/octet-stream"}}, want: true},
+		{
+			name: "html page with an attachment",
+			headers: []*fetch.HeaderEntry{
+				{Name: "Content-Type", Value: "text/html"},
+				{Name: "Content-Disposition", Value: "attachment"},
+			},
+			want: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isDownloadResponse(tc.headers); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDownloadListener(t *testing.T) {
+	begin := func(guid string) *browser.EventDownloadWillBegin {
+		return &browser.EventDownloadWillBegin{GUID: guid, SuggestedFilename: guid + ".zip"}
+	}
+	progress := func(guid string, state browser.DownloadProgressState) *browser.EventDownloadProgress {
+		return &browser.EventDownloadProgress{GUID: guid, State: state, ReceivedBytes: 10, TotalBytes: 10}
+	}
+	for _, tc := range []struct {
+		name     string
+		events   []interface{}
+		wantName string
+		wantErr  error
+		pending  bool
+	}{
+		{
+			name:     "completed",
+			events:   []interface{}{begin("a"), progress("a", browser.DownloadProgressStateInProgress), progress("a", browser.DownloadProgressStateCompleted)},
+			wantName: "a",
+		},
+		{
+			name:    "canceled",
+			events:  []interface{}{begin("a"), progress("a", browser.DownloadProgressStateCanceled)},
+			wantErr: errDownloadCanceled,
+		},
+		{
+			name:    "in progress",
+			events:  []interface{}{begin("a"), progress("a", browser.DownloadProgressStateInProgress)},
+			pending: true,
+		},
+		{
+			name:     "other downloads are ignored",
+			events:   []interface{}{begin("a"), begin("b"), progress("b", browser.DownloadProgressStateCanceled), progress("a", browser.DownloadProgressStateCompleted)},
+			wantName: "a",
+		},
+		{
+			name:     "only the first outcome counts",
+			events:   []interface{}{begin("a"), progress("a", browser.DownloadProgressStateCompleted), progress("a", browser.DownloadProgressStateCanceled)},
+			wantName: "a",
+		},
+		{
+			name:    "unrelated events",
+			events:  []interface{}{&fetch.EventRequestPaused{}},
+			pending: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newDownloadWaiter()
+			listen := downloadListener(w)
+			for _, ev := range tc.events {
+				listen(ev)
+			}
+			timeout := time.Second
+			if tc.pending {
+				timeout = 10 * time.Millisecond
+			}
+			name, err := w.Wait(context.Background(), timeout)
+			switch {
+			case tc.pending:
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("got %q and %v, want a timeout", name, err)
+				}
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("got %q and %v, want %v", name, err, tc.wantErr)
+				}
+			default:
+				if err != nil || name != tc.wantName {
+					t.Errorf("got %q and %v, want %q", name, err, tc.wantName)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadWaiter(t *testing.T) {
+	t.Run("first outcome wins", func(t *testing.T) {
+		w := newDownloadWaiter()
+		// finish must not block, however many times it is called
+		w.finish("a", nil)
+		w.finish("", errDownloadCanceled)
+		w.finish("b", nil)
+		name, err := w.Wait(context.Background(), time.Second)
+		if err != nil || name != "a" {
+			t.Errorf("got %q and %v, want a", name, err)
+		}
+	})
+	t.Run("concurrent outcomes", func(t *testing.T) {
+		w := newDownloadWaiter()
+		for i := 0; i < 10; i++ {
+			go w.finish("a", nil)
+		}
+		if name, err := w.Wait(context.Background(), time.Second); err != nil || name != "a" {
+			t.Errorf("got %q and %v, want a", name, err)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		w := newDownloadWaiter()
+		start := time.Now()
+		_, err := w.Wait(context.Background(), 20*time.Millisecond)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want a deadline error", err)
+		}
+		if d := time.Since(start); d < 20*time.Millisecond {
+			t.Errorf("gave up after %s, before the timeout", d)
+		}
+		// a late outcome does not block
+		w.finish("a", nil)
+	})
+	t.Run("canceled context", func(t *testing.T) {
+		w := newDownloadWaiter()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := w.Wait(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want a cancellation error", err)
+		}
+	})
+}
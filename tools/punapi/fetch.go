@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+// StepError is returned by Fetch when a step fails.
+type StepError struct {
+	Step string
+	// Artifacts is the directory holding the failure artifacts, if any
+	// were captured.
+	Artifacts string
+	Err       error
+}
+
+func (e *StepError) Error() string {
+	if e.Artifacts != "" {
+		return fmt.Sprintf("step '%s' failed: %v (artifacts in '%s')", e.Step, e.Err, e.Artifacts)
+	}
+	return fmt.Sprintf("step '%s' failed: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// stepFailed captures the failure artifacts, if enabled, and returns the
+// error for the failed step. Any file in files is saved with the artifacts.
+func stepFailed(ctx context.Context, artifacts *ArtifactStore, console *consoleLog, step string, err error, files ...string) error {
+	serr := StepError{Step: step, Err: err}
+	if artifacts != nil {
+		dir, cerr := artifacts.Capture(ctx, step, err, console, files...)
+		if cerr != nil {
+			log.Printf("Failed to capture artifacts for step '%s': %v", step, cerr)
+		}
+		serr.Artifacts = dir
+	}
+	return &serr
+}
+
+// Fetch the PUN data from mercatoelettrico.org for the provided date, running
+// the navigation flow of fc.Scraper. If fc.Artifacts is not nil, a
+// screenshot, the page HTML and the console log are saved when a step fails.
+func Fetch(ctx context.Context, fc FetchConfig, start, end time.Time) ([]PUNXML, error) {
+	log.Printf("Fetching PUN XML from %s to %s", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	console := listenConsole(ctx)
+	dl := fc.Download
+	waiter := newDownloadWaiter()
+
+	// download the zipped XML
+	tmpdir, err := os.MkdirTemp(dl.Dir, progname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer func() {
+		log.Printf("Removing temporary directory '%s'", tmpdir)
+		if err := os.RemoveAll(tmpdir); err != nil {
+			log.Printf("Failed to remove temporary directory '%s': %v", tmpdir, err)
+		}
+	}()
+
+	var setupDownload chromedp.Action
+	if dl.Mode == DownloadModeIntercept {
+		setupDownload = interceptDownload(ctx, tmpdir, waiter)
+	} else {
+		if dl.Mode == DownloadModeShared {
+			// the remote browser may run as a different user
+			if err := os.Chmod(tmpdir, 0777); err != nil {
+				return nil, fmt.Errorf("failed to make shared download dir writable: %w", err)
+			}
+		}
+		listenDownload(ctx, waiter)
+		setupDownload = browser.SetDownloadBehavior(
+			browser.SetDownloadBehaviorBehaviorAllowAndName).
+			WithDownloadPath(dl.browserDownloadDir(tmpdir)).
+			WithEventsEnabled(true)
+	}
+
+	for _, step := range fc.Scraper.Steps {
+		stepStart := time.Now()
+		err := chromedp.Run(ctx, fc.Scraper.Action(step, start, end, setupDownload))
+		observeStep(step.Name, stepStart, err)
+		if err != nil {
+			return nil, stepFailed(ctx, fc.Artifacts, console, step.Name, err)
+		}
+	}
+	stepStart := time.Now()
+	guid, err := waiter.Wait(ctx, dl.Timeout)
+	observeStep("wait download", stepStart, err)
+	if err != nil {
+		return nil, stepFailed(ctx, fc.Artifacts, console, "wait download", fmt.Errorf("download failed: %w", err))
+	}
+	zipfile := path.Join(tmpdir, guid)
+	log.Printf("download finished. File name is '%s'", zipfile)
+	if fi, err := os.Stat(zipfile); err == nil {
+		downloadBytes.Add(float64(fi.Size()))
+	}
+	stepStart = time.Now()
+	puns, err := ZipToPUNs(zipfile)
+	observeStep("parse", stepStart, err)
+	if err != nil {
+		parseErrors.Inc()
+		return nil, stepFailed(ctx, fc.Artifacts, console, "parse", fmt.Errorf("failed to analyze ZIP file: %w", err), zipfile)
+	}
+
+	return puns, nil
+}
@@ -4,45 +4,62 @@ package main
 import (
 	"archive/zip"
 	"context"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"path"
+	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
-	"github.com/chromedp/cdproto/browser"
-	"github.com/chromedp/chromedp"
-
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/pflag"
 )
 
-const (
-	progname = "punapi"
-	startURL = "https://www.mercatoelettrico.org/En/Tools/Accessodati.aspx?ReturnUrl=%2fEn%2fDownload%2fDownloadDati.aspx%3fval%3dMGP_Prezzi&val=MGP_Prezzi"
-)
+const progname = "punapi"
 
 var (
 	flagDebug         = pflag.BoolP("debug", "d", false, "Enable debug log")
 	flagShowBrowser   = pflag.BoolP("show-browser", "b", false, "show browser, useful for debugging")
 	flagChromePath    = pflag.StringP("chrome-path", "C", "", "Custom path for chrome browser")
 	flagProxy         = pflag.StringP("proxy", "P", "", "HTTP proxy")
-	flagTimeout       = pflag.DurationP("timeout", "t", 2*time.Minute, "Global timeout as a parsable duration (e.g. 1h12m)")
+	flagTimeout       = pflag.DurationP("timeout", "t", 2*time.Minute, "Timeout of a single fetch attempt as a parsable duration (e.g. 1h12m)")
 	flagDisableGPU    = pflag.BoolP("disable-gpu", "g", false, "Pass --disable-gpu to chrome")
 	flagListenAddress = pflag.StringP("listen-address", "l", ":8080", "HTTP listen address")
+	flagAdminListen   = pflag.String("admin-listen", "", "HTTP listen address of the admin endpoints, separate from --listen-address, e.g. 127.0.0.1:8081. If empty, the admin endpoints are disabled")
+	flagBrowsers      = pflag.IntP("browsers", "n", 1, "Number of long-lived browsers to keep running. Each serves one fetch at a time")
+	flagHealthCheck   = pflag.Duration("browser-health-interval", time.Minute, "Interval between health checks of the browsers")
+	flagRemoteChrome  = pflag.String("remote-chrome", "", "DevTools websocket URL of a running Chrome to use instead of starting one, e.g. ws://chrome:9222. If the URL has no path, the browser's websocket is discovered via /json/version")
+	flagDownloadMode  = pflag.String("download-mode", string(DownloadModeAuto), "How downloaded files reach punapi: browser (local download directory), shared (download directory shared with a remote browser), intercept (intercept the response via DevTools), or auto")
+	flagDownloadDir   = pflag.String("download-dir", "", "Local directory for downloads. Defaults to the system temporary directory")
+	flagRemoteDlDir   = pflag.String("remote-download-dir", "", "The download directory as seen by the remote browser, for --download-mode=shared")
+	flagDownloadTime  = pflag.Duration("download-timeout", time.Minute, "How long to wait for a download to complete once started")
+	flagFetchRetries  = pflag.Int("fetch-retries", 2, "How many times a failed fetch is retried")
+	flagFetchBackoff  = pflag.Duration("fetch-backoff", 5*time.Second, "Wait time before the first retry of a failed fetch. It doubles at every retry")
+	flagArtifactsDir  = pflag.String("artifacts-dir", "", "Directory where a screenshot, the page HTML and the console log are saved when a fetch step fails. If empty, no artifacts are saved")
+	flagArtifactsKeep = pflag.Duration("artifacts-retention", 7*24*time.Hour, "How long failure artifacts are kept. 0 means forever")
+	flagArtifactsMax  = pflag.Int("artifacts-max", 50, "Maximum number of failures whose artifacts are kept. 0 means no limit")
+	flagCanaryEvery   = pflag.Duration("canary-interval", time.Hour, "Interval between scraper canary checks. 0 disables the canary")
+	flagCanaryMode    = pflag.String("canary-mode", CanaryModeWalk, "Scraper canary mode: walk (run the navigation flow without downloading) or download (download --canary-day and verify its checksum)")
+	flagCanaryDay     = pflag.String("canary-day", "2024-01-15", "Known past day downloaded by the canary in download mode, as yyyy-mm-dd")
+	flagCanarySum     = pflag.String("canary-checksum-file", "", "File storing the checksum of the canary day's data, required in download mode. If it does not exist, it is created with the checksum of the first download")
+	flagCacheSize     = pflag.Int("cache-capacity", 1000, "Maximum number of cache entries. The least recently used ones are evicted. 0 means no limit")
+	flagPublishTime   = pflag.String("publish-time", "13:00", "Italian time at which GME publishes the prices of the following day, as hh:mm. Days are fetched only once published")
+	flagScraperConfig = pflag.String("scraper-config", "", "Path to the JSON file describing the navigation flow on mercatoelettrico.org. If empty, the built-in one is used")
 )
 
 func getTimeFromQuery(w http.ResponseWriter, r *http.Request) *time.Time {
-	t := time.Now()
+	t := time.Now().In(market.Location)
 	var err error
 	ts := r.URL.Query().Get("time")
 	if ts != "" {
-		t, err = time.Parse("2006-01-02 15:04", ts)
+		t, err = time.ParseInLocation("2006-01-02 15:04", ts, market.Location)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			_, _ = w.Write([]byte("Time parameter format must be yyyy-mm-dd hh:mm"))
@@ -52,34 +69,34 @@ func getTimeFromQuery(w http.ResponseWriter, r *http.Request) *time.Time {
 	return &t
 }
 
-func makeMonthHandler(cache *Cache, timeout time.Duration, showBrowser bool, doDebug bool, chromePath string, proxy string, disableGPU bool) func(http.ResponseWriter, *http.Request) {
+// getZoneFromQuery returns the price column requested via the `zone` query
+// parameter, defaulting to the PUN.
+func getZoneFromQuery(r *http.Request) string {
+	zone := strings.ToUpper(r.URL.Query().Get("zone"))
+	if zone == "" {
+		return "PUN"
+	}
+	return zone
+}
+
+func makeMonthHandler(store *DayStore) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		t := getTimeFromQuery(w, r)
 		if t == nil {
 			return
 		}
+		zone := getZoneFromQuery(r)
 		year, month, _ := t.Date()
-		k := fmt.Sprintf("%d-%d", year, month)
-		loc := time.Now().Location()
-		firstDay := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		firstDay := time.Date(year, month, 1, 0, 0, 0, 0, market.Location)
 		lastDay := firstDay.AddDate(0, 1, -1)
 		log.Printf("from %s to %s", firstDay, lastDay)
-		puns, ok := cache.Get(k)
-		if !ok {
-			log.Printf("Cache miss or expired for %s", k)
-			ctx, cancelFuncs := WithCancel(context.Background(), timeout, showBrowser, doDebug, chromePath, proxy, disableGPU)
-			for _, cancel := range cancelFuncs {
-				defer cancel()
-			}
-			// FIXME lock concurrent use of Fetch
-			v, err := Fetch(ctx, firstDay, lastDay)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				_, _ = w.Write([]byte(fmt.Sprintf("Fetch failed: %v", err)))
-				return
-			}
-			cache.Put(k, v)
-			puns = v
+		// the month is assembled from the cached days, so at most the
+		// days published since the last request are fetched
+		puns, err := store.Days(r.Context(), firstDay, lastDay)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(fmt.Sprintf("Fetch failed: %v", err)))
+			return
 		}
 		var (
 			sum   float64
@@ -87,108 +104,109 @@ func makeMonthHandler(cache *Cache, timeout time.Duration, showBrowser bool, doD
 		)
 		for _, pun := range puns {
 			for _, p := range pun.Prezzi {
-				sum += float64(p.PUN)
+				price, ok := p.Price(zone)
+				if !ok {
+					continue
+				}
+				sum += float64(price)
 				count++
 			}
 		}
 		if count == 0 {
 			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(fmt.Sprintf("No PUN found for %s", t)))
+			_, _ = w.Write([]byte(fmt.Sprintf("No %s found for %s", zone, t)))
+			return
 		}
 		_, _ = w.Write([]byte(fmt.Sprintf("%.6f", sum/(float64(count)))))
 	}
 }
 
-func makeHandler(cache *Cache, timeout time.Duration, showBrowser bool, doDebug bool, chromePath string, proxy string, disableGPU bool) func(http.ResponseWriter, *http.Request) {
+// getDayRow returns the row of the market interval containing t, an hour or a
+// quarter-hour in the 15-minute market, fetching the day if it is not cached.
+// Rows are matched by their start time, so the 23 and 25 hour days of the DST
+// changes are handled. On failure it writes the error to w and returns nil.
+func getDayRow(w http.ResponseWriter, r *http.Request, store *DayStore, t *time.Time) *Prezzi {
+	puns, err := store.Days(r.Context(), *t, *t)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("Fetch failed: %v", err)))
+		return nil
+	}
+	if len(puns) != 1 {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("Want exactly 1 PUN, got %d", len(puns))))
+		return nil
+	}
+	pun := puns[0]
+	for idx := range pun.Prezzi {
+		p := &pun.Prezzi[idx]
+		start, err := rowTime(p)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(err.Error()))
+			return nil
+		}
+		if !t.Before(start) && t.Before(start.Add(rowInterval(p))) {
+			return p
+		}
+	}
+	// if we are here, no row was found for the requested hour
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = w.Write([]byte(fmt.Sprintf("No PUN found for %s", t)))
+	return nil
+}
+
+func makeHandler(store *DayStore) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		t := getTimeFromQuery(w, r)
 		if t == nil {
 			return
 		}
-		// TODO ensure that time zones do not cause an off-by-one
-		year, month, day := t.Date()
-		// FIXME during DST changes there are days with 25 items (Ora == 25) and
-		// days with 23 items (Ora == 23 but not 24). This case is not handled
-		// yet
-		k := fmt.Sprintf("%d-%d-%d", year, month, day)
-		puns, ok := cache.Get(k)
-		// cache miss if:
-		// * the entry is not in the cache
-		// * the entry has expired
-		// * we are at the minute 0 of the hour (expecting an update of the PUN value)
-		if !ok || time.Now().Minute() == 0 {
-			log.Printf("Cache miss or expired for %s", k)
-			ctx, cancelFuncs := WithCancel(context.Background(), timeout, showBrowser, doDebug, chromePath, proxy, disableGPU)
-			for _, cancel := range cancelFuncs {
-				defer cancel()
-			}
-			// FIXME lock concurrent use of Fetch
-			v, err := Fetch(ctx, *t, *t)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				_, _ = w.Write([]byte(fmt.Sprintf("Fetch failed: %v", err)))
-				return
-			}
-			cache.Put(k, v)
-			puns = v
-		}
-		if len(puns) != 1 {
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(fmt.Sprintf("Want exactly 1 PUN, got %d", len(puns))))
+		zone := getZoneFromQuery(r)
+		row := getDayRow(w, r, store, t)
+		if row == nil {
 			return
 		}
-		pun := puns[0]
-		for _, p := range pun.Prezzi {
-			// Ora starts at 1, Hour starts at 0
-			if p.Ora == t.Hour()+1 {
-				_, _ = w.Write([]byte(fmt.Sprintf("%.6f", p.PUN)))
-				return
-			}
+		price, ok := row.Price(zone)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(fmt.Sprintf("No %s found for %s", zone, t)))
+			return
 		}
-		// if we are here, no PUN was found for the requested hour
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf("No PUN found for %s", t)))
+		_, _ = w.Write([]byte(fmt.Sprintf("%.6f", price)))
 	}
 }
 
-type CacheEntry struct {
-	PUN []PUNXML
-	Ts  time.Time
+// ZonePrice is a price column of a single hour, as returned by /zones.
+type ZonePrice struct {
+	Zone
+	Price float64 `json:"price"`
 }
 
-type Cache struct {
-	entries map[string]*CacheEntry
-	TTL     time.Duration
-	mu      sync.Mutex
-}
-
-func (c *Cache) Get(k string) ([]PUNXML, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	// TODO check if cache expired
-	e, ok := c.entries[k]
-	if ok {
-		if time.Since(e.Ts) > c.TTL {
-			return nil, false
+// makeZonesHandler returns every price column of the requested hour, known or
+// not, together with its metadata.
+func makeZonesHandler(store *DayStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := getTimeFromQuery(w, r)
+		if t == nil {
+			return
 		}
-		return e.PUN, true
-	}
-	return nil, false
-}
-
-func (c *Cache) Put(k string, v []PUNXML) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.entries[k] = &CacheEntry{
-		PUN: v,
-		Ts:  time.Now(),
-	}
-}
-
-func NewCache(ttl time.Duration) *Cache {
-	return &Cache{
-		entries: make(map[string]*CacheEntry),
-		TTL:     ttl,
+		row := getDayRow(w, r, store, t)
+		if row == nil {
+			return
+		}
+		zones := make([]ZonePrice, 0, len(row.Prices))
+		for _, column := range SortedColumns(row.Prices) {
+			zones = append(zones, ZonePrice{Zone: LookupZone(column), Price: float64(row.Prices[column])})
+		}
+		data, err := json.Marshal(zones)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(fmt.Sprintf("Failed to marshal zones: %v", err)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
 	}
 }
 
@@ -201,129 +219,220 @@ func main() {
 	}
 	pflag.Parse()
 
-	// TODO make TTL configurable
-	cache := NewCache(time.Hour)
-	http.HandleFunc("/", makeHandler(cache, *flagTimeout, *flagShowBrowser, *flagDebug, *flagChromePath, *flagProxy, *flagDisableGPU))
-	http.HandleFunc("/month", makeMonthHandler(cache, *flagTimeout, *flagShowBrowser, *flagDebug, *flagChromePath, *flagProxy, *flagDisableGPU))
-	log.Printf("Listening on %s", *flagListenAddress)
-	log.Fatal(http.ListenAndServe(*flagListenAddress, nil))
-}
-
-// Fetch the PUN data from mercatoelettrico.org for the provided date.
-func Fetch(ctx context.Context, start, end time.Time) ([]PUNXML, error) {
-	log.Printf("Fetching PUN XML from %s to %s", start.Format("2006-01-02"), end.Format("2006-01-02"))
-	tasks := chromedp.Tasks{
-		chromedp.Navigate(startURL),
-	}
-	acceptBox1 := `//*[@id="ContentPlaceHolder1_CBAccetto1"]`
-	acceptBox2 := `//*[@id="ContentPlaceHolder1_CBAccetto2"]`
-	acceptButton := `//*[@id="ContentPlaceHolder1_Button1"]`
-	tasks = append(tasks,
-		chromedp.WaitVisible(acceptBox1, chromedp.BySearch),
-		chromedp.Click(acceptBox1),
-		chromedp.WaitVisible(acceptBox2, chromedp.BySearch),
-		chromedp.Click(acceptBox2),
-		chromedp.WaitVisible(acceptButton, chromedp.BySearch),
-		chromedp.Click(acceptButton),
-	)
-	done := make(chan string, 1)
-
-	// add download listener
-	chromedp.ListenTarget(ctx, func(ev interface{}) {
-		select {
-		// TODO make timeout configurable
-		case <-time.After(time.Minute):
-			log.Printf("Download timed out")
-			return
-		default:
-			if evt, ok := ev.(*browser.EventDownloadProgress); ok {
-				completed := "(unknown)"
-				if evt.TotalBytes != 0 {
-					completed = fmt.Sprintf("%0.2f%%", evt.ReceivedBytes/evt.TotalBytes*100.0)
-				}
-				log.Printf("state: %s, completed: %s\n", evt.State.String(), completed)
-				if evt.State == browser.DownloadProgressStateCompleted {
-					done <- evt.GUID
-					close(done)
-				}
-			}
-		}
-	})
-
-	// download the zipped XML
-	startDateInput := `//*[@id="ContentPlaceHolder1_tbDataStart"]`
-	endDateInput := `//*[@id="ContentPlaceHolder1_tbDataStop"]`
-	downloadButton := `//*[@id="ContentPlaceHolder1_btnScarica"]`
-	loc := time.Now().Location()
-	startDate := start.In(loc).Format("02/01/2006")
-	endDate := end.In(loc).Format("02/01/2006")
-	tmpdir, err := os.MkdirTemp("", progname)
+	dl, err := DownloadConfig{
+		Mode:      DownloadMode(*flagDownloadMode),
+		Dir:       *flagDownloadDir,
+		RemoteDir: *flagRemoteDlDir,
+		Timeout:   *flagDownloadTime,
+	}.Resolve(*flagRemoteChrome != "")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp dir: %w", err)
-	}
-	tasks = append(tasks,
-		chromedp.WaitVisible(startDateInput, chromedp.BySearch),
-		chromedp.SendKeys(startDateInput, startDate),
-		chromedp.WaitVisible(endDateInput, chromedp.BySearch),
-		chromedp.SendKeys(endDateInput, endDate),
-		chromedp.WaitVisible(downloadButton, chromedp.BySearch),
-		browser.SetDownloadBehavior(
-			browser.SetDownloadBehaviorBehaviorAllowAndName).
-			WithDownloadPath(tmpdir).
-			WithEventsEnabled(true),
-		chromedp.Click(downloadButton),
-	)
-	defer func() {
-		log.Printf("Removing temporary directory '%s'", tmpdir)
-		if err := os.RemoveAll(tmpdir); err != nil {
-			log.Printf("Failed to remove temporary directory '%s': %v", tmpdir, err)
+		log.Fatalf("Invalid download configuration: %v", err)
+	}
+	if *flagRemoteChrome != "" {
+		if *flagShowBrowser || *flagChromePath != "" || *flagProxy != "" || *flagDisableGPU {
+			log.Printf("Warning: --show-browser, --chrome-path, --proxy and --disable-gpu are ignored with --remote-chrome")
 		}
-	}()
-	err = chromedp.Run(ctx, tasks)
-	guid := <-done
+		// the download directory is a browser-wide setting, so concurrent
+		// fetches on the same browser would overwrite each other's
+		if *flagBrowsers > 1 && dl.Mode != DownloadModeIntercept {
+			log.Fatalf("--browsers > 1 with --remote-chrome requires --download-mode=%s, got %s", DownloadModeIntercept, dl.Mode)
+		}
+		log.Printf("Using remote browser at %s, download mode: %s", *flagRemoteChrome, dl.Mode)
+	}
+	scraper, err := LoadScraperConfig(*flagScraperConfig)
 	if err != nil {
-		return nil, fmt.Errorf("download failed: %w", err)
+		log.Fatalf("Failed to load scraper configuration: %v", err)
+	}
+	var artifacts *ArtifactStore
+	if *flagArtifactsDir != "" {
+		artifacts, err = NewArtifactStore(*flagArtifactsDir, *flagArtifactsKeep, *flagArtifactsMax)
+		if err != nil {
+			log.Fatalf("Failed to create artifact store: %v", err)
+		}
 	}
-	zipfile := path.Join(tmpdir, guid)
-	log.Printf("download finished. File name is '%s'", zipfile)
-	puns, err := ZipToPUNs(zipfile)
+	pool, err := NewBrowserPool(*flagBrowsers, BrowserConfig{
+		ShowBrowser: *flagShowBrowser,
+		Debug:       *flagDebug,
+		ChromePath:  *flagChromePath,
+		Proxy:       *flagProxy,
+		DisableGPU:  *flagDisableGPU,
+		RemoteURL:   *flagRemoteChrome,
+	}, FetchConfig{
+		Download: dl,
+		Scraper:  scraper,
+		Retry: RetryConfig{
+			Retries: *flagFetchRetries,
+			Backoff: *flagFetchBackoff,
+		},
+		Artifacts: artifacts,
+		Timeout:   *flagTimeout,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to analyze ZIP file: %w", err)
+		log.Fatalf("Failed to create browser pool: %v", err)
+	}
+	// Chrome processes are stopped on SIGINT and SIGTERM, after the server
+	// has shut down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go pool.RunHealthChecks(ctx, *flagHealthCheck)
+
+	var canary *Canary
+	if *flagCanaryEvery > 0 {
+		day, err := time.ParseInLocation("2006-01-02", *flagCanaryDay, market.Location)
+		if err != nil {
+			log.Fatalf("Invalid canary day: %v", err)
+		}
+		canary, err = NewCanary(pool, *flagCanaryMode, day, *flagCanarySum)
+		if err != nil {
+			log.Fatalf("Failed to create scraper canary: %v", err)
+		}
+		go canary.Run(*flagCanaryEvery)
 	}
 
-	return puns, nil
+	publish, err := ParsePublishSchedule(*flagPublishTime)
+	if err != nil {
+		log.Fatalf("Invalid publication time: %v", err)
+	}
+	cache := NewCache(*flagCacheSize)
+	store := NewDayStore(cache, pool, publish)
+	profiles := NewProfileStore()
+	if err := registerMetrics(pool, cache); err != nil {
+		log.Fatalf("Failed to register metrics: %v", err)
+	}
+	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/healthz", instrumentHandler("healthz", healthzHandler))
+	http.Handle("/readyz", instrumentHandler("readyz", makeReadyzHandler(canary)))
+	http.Handle("/", instrumentHandler("price", makeHandler(store)))
+	http.Handle("/month", instrumentHandler("month", makeMonthHandler(store)))
+	http.Handle("/zones", instrumentHandler("zones", makeZonesHandler(store)))
+	http.Handle("/stats", instrumentHandler("stats", makeStatsHandler(store)))
+	http.Handle("/prices", instrumentHandler("prices", makePricesHandler(store)))
+	http.Handle("/weighted", instrumentHandler("weighted", makeWeightedHandler(store, profiles)))
+	http.Handle("/profiles/", instrumentHandler("profiles", makeProfilesHandler(profiles)))
+	http.Handle("/plan", instrumentHandler("plan", makePlanHandler(store, profiles)))
+	servers := []*http.Server{{Addr: *flagListenAddress}}
+	// the admin endpoints can purge the cache and force new downloads, so
+	// they are never served on the public listener
+	if *flagAdminListen != "" {
+		admin := http.NewServeMux()
+		admin.Handle("/admin/cache", instrumentHandler("admin_cache", makeCacheAdminHandler(cache)))
+		servers = append(servers, &http.Server{Addr: *flagAdminListen, Handler: admin})
+	}
+	serveErr := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			log.Printf("Listening on %s", server.Addr)
+			serveErr <- server.ListenAndServe()
+		}(server)
+	}
+	select {
+	case err := <-serveErr:
+		pool.Close()
+		log.Fatalf("HTTP server failed: %v", err)
+	case <-ctx.Done():
+	}
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down the HTTP server on %s: %v", server.Addr, err)
+		}
+	}
+	pool.Close()
 }
 
 type PUNXML struct {
 	XMLName xml.Name `xml:"NewDataSet"`
-	Prezzi  []struct {
-		XMLName xml.Name `xml:"Prezzi"`
-		Data    string
-		Mercato string
-		Ora     int
-		PUN     Price `xml:"PUN"`
-		NAT     Price `xml:"NAT"`
-		CALA    Price `xml:"CALA"`
-		CNOR    Price `xml:"CNOR"`
-		CSUD    Price `xml:"CSUD"`
-		NORD    Price `xml:"NORD"`
-		SARD    Price `xml:"SARD"`
-		SICI    Price `xml:"SICI"`
-		SUD     Price `xml:"SUD"`
-		AUST    Price `xml:"AUST"`
-		COAC    Price `xml:"COAC"`
-		COUP    Price `xml:"COUP"`
-		CORS    Price `xml:"CORS"`
-		FRAN    Price `xml:"FRAN"`
-		GREC    Price `xml:"GREC"`
-		SLOV    Price `xml:"SLOV"`
-		SVIZ    Price `xml:"SVIZ"`
-		BSP     Price `xml:"BSP"`
-		MALT    Price `xml:"MALT"`
-		XAUS    Price `xml:"XAUS"`
-		XFRA    Price `xml:"XFRA"`
-		MONT    Price `xml:"MONT"`
-		XGRE    Price `xml:"XGRE"`
+	Prezzi  []Prezzi `xml:"Prezzi"`
+}
+
+// Prezzi is a single row of the MGP prices XML. Data, Mercato, Ora and
+// Periodo are metadata, every other element is a price column keyed by its
+// element name (PUN, NORD, XGRE, ...), so that columns added or removed by GME
+// are picked up without changes here.
+type Prezzi struct {
+	Data    string
+	Mercato string
+	Ora     int
+	// Periodo is the quarter-hour of the day, starting at 1, in the files of
+	// the 15-minute market. It is zero in hourly files.
+	Periodo int
+	Prices  map[string]Price
+}
+
+// Price returns the price for the given column, e.g. PUN or NORD.
+func (p *Prezzi) Price(column string) (Price, bool) {
+	v, ok := p.Prices[column]
+	return v, ok
+}
+
+func (p Prezzi) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := e.EncodeElement(p.Data, xml.StartElement{Name: xml.Name{Local: "Data"}}); err != nil {
+		return err
+	}
+	if err := e.EncodeElement(p.Mercato, xml.StartElement{Name: xml.Name{Local: "Mercato"}}); err != nil {
+		return err
+	}
+	if err := e.EncodeElement(p.Ora, xml.StartElement{Name: xml.Name{Local: "Ora"}}); err != nil {
+		return err
+	}
+	if p.Periodo > 0 {
+		if err := e.EncodeElement(p.Periodo, xml.StartElement{Name: xml.Name{Local: "Periodo"}}); err != nil {
+			return err
+		}
+	}
+	for _, column := range SortedColumns(p.Prices) {
+		if err := p.Prices[column].MarshalXML(e, xml.StartElement{Name: xml.Name{Local: column}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func (p *Prezzi) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	p.Prices = make(map[string]Price)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("failed to read token: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var err error
+			switch t.Name.Local {
+			case "Data":
+				err = d.DecodeElement(&p.Data, &t)
+			case "Mercato":
+				err = d.DecodeElement(&p.Mercato, &t)
+			case "Ora":
+				err = d.DecodeElement(&p.Ora, &t)
+			case "Periodo":
+				err = d.DecodeElement(&p.Periodo, &t)
+			default:
+				var fs string
+				if err := d.DecodeElement(&fs, &t); err != nil {
+					return fmt.Errorf("failed to decode column %s: %w", t.Name.Local, err)
+				}
+				// an empty column means no price, not a zero price
+				if strings.TrimSpace(fs) == "" {
+					continue
+				}
+				price, perr := ParsePrice(fs)
+				if perr != nil {
+					return fmt.Errorf("failed to parse column %s: %w", t.Name.Local, perr)
+				}
+				p.Prices[t.Name.Local] = price
+			}
+			if err != nil {
+				return fmt.Errorf("failed to decode %s: %w", t.Name.Local, err)
+			}
+		case xml.EndElement:
+			return nil
+		}
 	}
 }
 
@@ -347,15 +456,25 @@ func (p *Price) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	if err := d.DecodeElement(&fs, &start); err != nil {
 		return fmt.Errorf("failed to decode element: %w", err)
 	}
-	fs = strings.Replace(fs, ",", ".", -1)
-	f, err := strconv.ParseFloat(fs, 64)
+	price, err := ParsePrice(fs)
 	if err != nil {
-		return fmt.Errorf("strconv.ParseFloat failed: %w", err)
+		return err
 	}
-	*p = Price(f)
+	*p = price
 	return nil
 }
 
+// ParsePrice parses a price in the format used by GME, with a comma as
+// decimal separator.
+func ParsePrice(s string) (Price, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", ".", -1)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("strconv.ParseFloat failed: %w", err)
+	}
+	return Price(f), nil
+}
+
 func ZipToPUNs(zipfile string) ([]PUNXML, error) {
 	// extract zip file
 	archive, err := zip.OpenReader(zipfile)
@@ -396,38 +515,3 @@ func ZipToPUNs(zipfile string) ([]PUNXML, error) {
 	}
 	return punlist, nil
 }
-
-// WithCancel returns a chromedp context with a cancellation function.
-func WithCancel(ctx context.Context, timeout time.Duration, showBrowser, doDebug bool, chromePath, proxyURL string, disableGPU bool) (context.Context, []func()) {
-	var cancelFuncs []func()
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	cancelFuncs = append(cancelFuncs, cancel)
-
-	// show browser
-	var allocatorOpts []chromedp.ExecAllocatorOption
-	if showBrowser {
-		allocatorOpts = append(allocatorOpts, chromedp.NoFirstRun, chromedp.NoDefaultBrowserCheck)
-	} else {
-		allocatorOpts = append(allocatorOpts, chromedp.Headless)
-	}
-	if chromePath != "" {
-		allocatorOpts = append(allocatorOpts, chromedp.ExecPath(chromePath))
-	}
-	if proxyURL != "" {
-		allocatorOpts = append(allocatorOpts, chromedp.ProxyServer(proxyURL))
-	}
-	if disableGPU {
-		allocatorOpts = append(allocatorOpts, chromedp.Flag("disable-gpu", disableGPU))
-	}
-	ctx, cancel = chromedp.NewExecAllocator(ctx, allocatorOpts...)
-	cancelFuncs = append(cancelFuncs, cancel)
-
-	var opts []chromedp.ContextOption
-	if doDebug {
-		opts = append(opts, chromedp.WithDebugf(log.Printf))
-	}
-
-	ctx, cancel = chromedp.NewContext(ctx, opts...)
-	cancelFuncs = append(cancelFuncs, cancel)
-	return ctx, cancelFuncs
-}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+func TestPrezziUnmarshalXML(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		xml  string
+		want []Prezzi
+	}{
+		{
+			name: "hourly",
+			xml: `<NewDataSet><Prezzi><Data>20240115</Data><Mercato>MGP</Mercato><Ora>1</Ora>
+				<PUN>99,5</PUN><NORD>98,123456</NORD><XGRE> 1,5 </XGRE></Prezzi></NewDataSet>`,
+			want: []Prezzi{{
+				Data: "20240115", Mercato: "MGP", Ora: 1,
+				Prices: map[string]Price{"PUN": 99.5, "NORD": 98.123456, "XGRE": 1.5},
+			}},
+		},
+		{
+			name: "quarter-hourly",
+			xml: `<NewDataSet><Prezzi><Data>20251001</Data><Mercato>MGP</Mercato><Ora>2</Ora><Periodo>5</Periodo>
+				<PUN>100</PUN></Prezzi><Prezzi><Data>20251001</Data><Mercato>MGP</Mercato><Ora>2</Ora><Periodo>6</Periodo>
+				<PUN>101,25</PUN></Prezzi></NewDataSet>`,
+			want: []Prezzi{
+				{Data: "20251001", Mercato: "MGP", Ora: 2, Periodo: 5, Prices: map[string]Price{"PUN": 100}},
+				{Data: "20251001", Mercato: "MGP", Ora: 2, Periodo: 6, Prices: map[string]Price{"PUN": 101.25}},
+			},
+		},
+		{
+			name: "empty column",
+			xml:  `<NewDataSet><Prezzi><Data>20240115</Data><Ora>3</Ora><PUN>10</PUN><NEWZONE></NEWZONE><SUD> </SUD></Prezzi></NewDataSet>`,
+			want: []Prezzi{{Data: "20240115", Ora: 3, Prices: map[string]Price{"PUN": 10}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var pun PUNXML
+			if err := xml.Unmarshal([]byte(tc.xml), &pun); err != nil {
+				t.Fatalf("xml.Unmarshal failed: %v", err)
+			}
+			if !reflect.DeepEqual(pun.Prezzi, tc.want) {
+				t.Errorf("got %+v, want %+v", pun.Prezzi, tc.want)
+			}
+		})
+	}
+}
+
+func TestPrezziUnmarshalXMLInvalidPrice(t *testing.T) {
+	var pun PUNXML
+	err := xml.Unmarshal([]byte(`<NewDataSet><Prezzi><Data>20240115</Data><Ora>1</Ora><PUN>n/a</PUN></Prezzi></NewDataSet>`), &pun)
+	if err == nil {
+		t.Error("want an error for an invalid price")
+	}
+}
+
+func TestPrezziMarshalXMLRoundTrip(t *testing.T) {
+	want := PUNXML{Prezzi: []Prezzi{
+		{Data: "20240115", Mercato: "MGP", Ora: 1, Prices: map[string]Price{"PUN": 99.5, "NORD": 98.1234}},
+		{Data: "20251001", Mercato: "MGP", Ora: 2, Periodo: 6, Prices: map[string]Price{"PUN": 101.25}},
+	}}
+	data, err := xml.Marshal(want)
+	if err != nil {
+		t.Fatalf("xml.Marshal failed: %v", err)
+	}
+	var got PUNXML
+	if err := xml.Unmarshal(data, &got); err != nil {
+		t.Fatalf("xml.Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(got.Prezzi, want.Prezzi) {
+		t.Errorf("got %+v, want %+v from %s", got.Prezzi, want.Prezzi, data)
+	}
+}
+
+func TestGetTimeFromQuery(t *testing.T) {
+	w := httptest.NewRecorder()
+	got := getTimeFromQuery(w, httptest.NewRequest("GET", "/?time=2024-07-15+14:00", nil))
+	if got == nil {
+		t.Fatalf("no time: %d %s", w.Code, w.Body.String())
+	}
+	// the time is in Italian time, whatever the host's time zone
+	if want := time.Date(2024, 7, 15, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	w = httptest.NewRecorder()
+	if got := getTimeFromQuery(w, httptest.NewRequest("GET", "/?time=2024-07-15", nil)); got != nil || w.Code != 400 {
+		t.Errorf("got %v and status %d, want status 400", got, w.Code)
+	}
+}
+
+func TestGetDayRowDST(t *testing.T) {
+	cache := NewCache(0)
+	for _, d := range []struct {
+		data  string
+		hours int
+	}{{"20241027", 25}, {"20240331", 23}} {
+		day, err := time.ParseInLocation("20060102", d.data, market.Location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Put(dayKey(day), day, day, []PUNXML{{Prezzi: dayRows(d.data, d.hours)}})
+	}
+	store := NewDayStore(cache, nil, PublishSchedule{Hour: 13})
+
+	for _, tc := range []struct {
+		t   string
+		ora int
+	}{
+		{"2024-10-26T22:00:00Z", 1},
+		// 02:30 CEST and 02:30 CET
+		{"2024-10-27T00:30:00Z", 3},
+		{"2024-10-27T01:30:00Z", 4},
+		{"2024-10-27T22:59:00Z", 25},
+		// 01:30 CET and 03:30 CEST
+		{"2024-03-31T00:30:00Z", 2},
+		{"2024-03-31T01:30:00Z", 3},
+		{"2024-03-31T21:00:00Z", 23},
+	} {
+		t.Run(tc.t, func(t *testing.T) {
+			ts, err := time.Parse(time.RFC3339, tc.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the handlers pass times in Italian time
+			ts = ts.In(market.Location)
+			w := httptest.NewRecorder()
+			row := getDayRow(w, httptest.NewRequest("GET", "/", nil), store, &ts)
+			if row == nil {
+				t.Fatalf("no row: %d %s", w.Code, w.Body.String())
+			}
+			if row.Ora != tc.ora {
+				t.Errorf("got Ora %d, want %d", row.Ora, tc.ora)
+			}
+		})
+	}
+}
+
+func TestMonthHandler(t *testing.T) {
+	cache := NewCache(0)
+	for day := time.Date(2024, time.February, 1, 0, 0, 0, 0, market.Location); day.Month() == time.February; day = day.AddDate(0, 0, 1) {
+		cache.Put(dayKey(day), day, day, []PUNXML{{Prezzi: dayRows(day.Format("20060102"), 24)}})
+	}
+	// only February is cached, so asking for any other day would fail
+	h := makeMonthHandler(NewDayStore(cache, nil, PublishSchedule{Hour: 13}))
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/month?time=2024-02-29+23:30", nil))
+	if w.Code != 200 || w.Body.String() != "12.500000" {
+		t.Errorf("got %d %q, want 12.500000", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var failureArtifacts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "punapi_fetch_failure_artifacts_total",
+		Help: "Number of failed fetch steps whose artifacts were saved, by step",
+	},
+	[]string{"step"},
+)
+
+var (
+	scraperHealthy = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "punapi_scraper_healthy",
+			Help: "Whether the last scraper canary check succeeded",
+		},
+	)
+	scraperFailedStep = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "punapi_scraper_failed_step",
+			Help: "Set to 1 for the step that failed the last scraper canary check",
+		},
+		[]string{"step"},
+	)
+	scraperLastCheck = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "punapi_scraper_last_check_timestamp_seconds",
+			Help: "Timestamp of the last scraper canary check",
+		},
+	)
+)
+
+var (
+	cacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "punapi_cache_requests_total",
+			Help: "Cache lookups, by result (hit or miss)",
+		},
+		[]string{"result"},
+	)
+	cacheEvictions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "punapi_cache_evictions_total",
+			Help: "Cache entries evicted because the cache was full",
+		},
+	)
+	fetches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "punapi_fetches_total",
+			Help: "Fetch attempts, by outcome (success or failure)",
+		},
+		[]string{"outcome"},
+	)
+	fetchStepDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "punapi_fetch_step_duration_seconds",
+			Help:    "Duration of each fetch step, by step and outcome",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		},
+		[]string{"step", "outcome"},
+	)
+	browserRestarts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "punapi_browser_restarts_total",
+			Help: "Browsers restarted after failing a health check",
+		},
+	)
+	downloadBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "punapi_download_bytes_total",
+			Help: "Bytes of the downloaded ZIP files",
+		},
+	)
+	parseErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "punapi_parse_errors_total",
+			Help: "Downloaded files that could not be parsed",
+		},
+	)
+	lastStoredDay = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "punapi_last_stored_market_day_timestamp_seconds",
+			Help: "Midnight, Italian time, of the most recent market day stored in the cache",
+		},
+	)
+	httpRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "punapi_http_requests_total",
+			Help: "HTTP requests, by handler, method and status code",
+		},
+		[]string{"handler", "method", "code"},
+	)
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "punapi_http_request_duration_seconds",
+			Help:    "Duration of HTTP requests, by handler, method and status code",
+			Buckets: []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120},
+		},
+		[]string{"handler", "method", "code"},
+	)
+)
+
+// observeStep records the duration and outcome of a fetch step.
+func observeStep(step string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	fetchStepDuration.WithLabelValues(step, outcome).Observe(time.Since(start).Seconds())
+}
+
+var (
+	lastStoredDayMu    sync.Mutex
+	lastStoredDayValue time.Time
+)
+
+// updateLastStoredDay moves the last stored market day forward to the most
+// recent day in puns.
+func updateLastStoredDay(puns []PUNXML) {
+	var last time.Time
+	for _, pun := range puns {
+		for _, p := range pun.Prezzi {
+			day, err := time.ParseInLocation("20060102", p.Data, market.Location)
+			if err == nil && day.After(last) {
+				last = day
+			}
+		}
+	}
+	lastStoredDayMu.Lock()
+	defer lastStoredDayMu.Unlock()
+	if last.After(lastStoredDayValue) {
+		lastStoredDayValue = last
+		lastStoredDay.Set(float64(last.Unix()))
+	}
+}
+
+// instrumentHandler wraps h with the HTTP request metrics, labelled with the
+// handler's name.
+func instrumentHandler(name string, h http.HandlerFunc) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerDuration(
+		httpRequestDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels), h),
+	)
+}
+
+func registerMetrics(pool *BrowserPool, cache *Cache) error {
+	cacheEntries := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "punapi_cache_entries",
+			Help: "Number of cache entries",
+		},
+		func() float64 { return float64(cache.Len()) },
+	)
+	chromeProcesses := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "punapi_chrome_processes",
+			Help: "Number of Chrome processes started by punapi that are running",
+		},
+		func() float64 { return float64(pool.RunningProcesses()) },
+	)
+	for _, c := range []prometheus.Collector{
+		failureArtifacts,
+		scraperHealthy,
+		scraperFailedStep,
+		scraperLastCheck,
+		cacheRequests,
+		cacheEvictions,
+		cacheEntries,
+		fetches,
+		fetchStepDuration,
+		browserRestarts,
+		chromeProcesses,
+		downloadBytes,
+		parseErrors,
+		lastStoredDay,
+		httpRequests,
+		httpRequestDuration,
+	} {
+		if err := prometheus.Register(c); err != nil {
+			return fmt.Errorf("failed to register collector: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentHandler(t *testing.T) {
+	h := instrumentHandler("test", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	for _, target := range []string{"/", "/", "/?fail=1"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+	for _, tc := range []struct {
+		code string
+		want float64
+	}{{"200", 2}, {"400", 1}, {"500", 0}} {
+		if got := testutil.ToFloat64(httpRequests.WithLabelValues("test", "get", tc.code)); got != tc.want {
+			t.Errorf("got %v requests with status %s, want %v", got, tc.code, tc.want)
+		}
+	}
+	// the duration histogram has one series per status code
+	if got := testutil.CollectAndCount(httpRequestDuration, "punapi_http_request_duration_seconds"); got < 2 {
+		t.Errorf("got %d duration series, want at least 2", got)
+	}
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("other", "get", "200")); got != 0 {
+		t.Errorf("got %v requests for another handler, want 0", got)
+	}
+}
+
+func TestObserveStep(t *testing.T) {
+	before := testutil.CollectAndCount(fetchStepDuration)
+	observeStep("test step", time.Now(), nil)
+	observeStep("test step", time.Now(), errors.New("failed"))
+	if got := testutil.CollectAndCount(fetchStepDuration); got != before+2 {
+		t.Errorf("got %d series, want %d", got, before+2)
+	}
+}
+
+func TestUpdateLastStoredDay(t *testing.T) {
+	// other tests may have stored more recent days
+	lastStoredDayMu.Lock()
+	lastStoredDayValue = time.Time{}
+	lastStoredDayMu.Unlock()
+	updateLastStoredDay([]PUNXML{{Prezzi: []Prezzi{{Data: "20240114"}, {Data: "20240115"}, {Data: "invalid"}}}})
+	// midnight in Italy, whatever the host's time zone
+	want := time.Date(2024, time.January, 14, 23, 0, 0, 0, time.UTC)
+	if got := testutil.ToFloat64(lastStoredDay); got != float64(want.Unix()) {
+		t.Errorf("got %v, want %v", got, want.Unix())
+	}
+	// the last stored day never goes back
+	updateLastStoredDay([]PUNXML{{Prezzi: []Prezzi{{Data: "20240101"}}}})
+	if got := testutil.ToFloat64(lastStoredDay); got != float64(want.Unix()) {
+		t.Errorf("got %v after an older day, want %v", got, want.Unix())
+	}
+}
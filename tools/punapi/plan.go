@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// planLevels is the number of steps the battery capacity is split into
+	// by the optimiser
+	planLevels = 200
+	// defaultPlanHours and maxPlanHours are the default and maximum length
+	// of a plan. Plans are shorter when the prices are not published yet.
+	defaultPlanHours = 36
+	maxPlanHours     = 48
+)
+
+// Battery describes a battery, or an electric vehicle, and its state.
+// Energies are in kWh and powers in kW.
+type Battery struct {
+	Capacity       float64 `json:"capacity"`
+	ChargePower    float64 `json:"charge_power"`
+	DischargePower float64 `json:"discharge_power"`
+	// Efficiency is the round-trip efficiency, split evenly between
+	// charging and discharging
+	Efficiency float64 `json:"efficiency"`
+	// SOC is the state of charge at the start of the plan
+	SOC float64 `json:"soc"`
+	// MinSOC is the charge the battery is never discharged below
+	MinSOC float64 `json:"min_soc"`
+	// EndSOC is the minimum charge at the end of the plan
+	EndSOC float64 `json:"end_soc"`
+}
+
+// PlanStep is an hour of a charge plan.
+type PlanStep struct {
+	Time time.Time `json:"time"`
+	// Price is the average price of the hour, in EUR/MWh
+	Price float64 `json:"price"`
+	// Load is the consumption forecast of the hour in kWh, if known
+	Load *float64 `json:"load,omitempty"`
+	// Power is the average power of the hour in kW, positive when charging
+	// from the grid and negative when discharging to the load
+	Power float64 `json:"power"`
+	// SOC is the state of charge at the end of the hour
+	SOC float64 `json:"soc"`
+}
+
+// Plan is an hourly charge and discharge plan.
+type Plan struct {
+	Zone    string     `json:"zone"`
+	Battery Battery    `json:"battery"`
+	Steps   []PlanStep `json:"plan"`
+	// Savings is the cost avoided by following the plan, in EUR
+	Savings float64 `json:"savings"`
+}
+
+// optimisePlan returns the plan minimising the cost of the energy bought from
+// the grid, with dynamic programming over the state of charge. The battery
+// can charge from the grid and discharge to cover the load of every hour.
+// Energy discharged beyond the load is not sold. If the load of an hour is
+// unknown (NaN), the battery can discharge up to its discharge power and the
+// energy is valued at feedIn, in EUR/MWh, rather than at the price of the
+// hour.
+func optimisePlan(b Battery, prices []HourlyPrice, load []float64, feedIn float64) ([]PlanStep, float64, error) {
+	step := b.Capacity / planLevels
+	eff := math.Sqrt(b.Efficiency)
+	// level returns the level of kwh, rounded with round unless it is a
+	// whole level but for the errors of the division
+	level := func(kwh float64, round func(float64) float64) int {
+		x := kwh / step
+		if r := math.Round(x); math.Abs(x-r) < 1e-9 {
+			return int(r)
+		}
+		return int(round(x))
+	}
+	start := level(b.SOC, math.Round)
+	minLevel := level(b.MinSOC, math.Ceil)
+	endLevel := level(b.EndSOC, math.Ceil)
+	maxUp := level(b.ChargePower*eff, math.Floor)
+
+	inf := math.Inf(1)
+	cost := make([]float64, planLevels+1)
+	for s := range cost {
+		cost[s] = inf
+	}
+	cost[start] = 0
+	// from[t][s] is the level before the hour t that leads to s
+	from := make([][]int, len(prices))
+	for t, p := range prices {
+		maxPower, value := b.DischargePower, feedIn
+		if !math.IsNaN(load[t]) {
+			maxPower, value = math.Min(maxPower, load[t]), p.Price
+		}
+		maxDown := level(maxPower/eff, math.Floor)
+		next := make([]float64, planLevels+1)
+		from[t] = make([]int, planLevels+1)
+		for s := range next {
+			next[s] = inf
+		}
+		for s, c := range cost {
+			if math.IsInf(c, 1) {
+				continue
+			}
+			lo, hi := s-maxDown, s+maxUp
+			// never discharge below the minimum
+			if lo < minLevel {
+				lo = minLevel
+			}
+			if lo > s {
+				lo = s
+			}
+			if hi > planLevels {
+				hi = planLevels
+			}
+			for n := lo; n <= hi; n++ {
+				stored := float64(n-s) * step
+				v := c + p.Price*stored/eff
+				if stored < 0 {
+					v = c + value*stored*eff
+				}
+				// idle first, then strictly cheaper moves only
+				if v < next[n]-1e-12 || (v <= next[n] && n == s) {
+					next[n] = v
+					from[t][n] = s
+				}
+			}
+		}
+		cost = next
+	}
+
+	best := -1
+	for s := endLevel; s <= planLevels; s++ {
+		if !math.IsInf(cost[s], 1) && (best < 0 || cost[s] < cost[best]) {
+			best = s
+		}
+	}
+	if best < 0 {
+		return nil, 0, fmt.Errorf("the end state of charge cannot be reached")
+	}
+	steps := make([]PlanStep, len(prices))
+	s := best
+	for t := len(prices) - 1; t >= 0; t-- {
+		prev := from[t][s]
+		stored := float64(s-prev) * step
+		power := stored / eff
+		if stored < 0 {
+			power = stored * eff
+		}
+		steps[t] = PlanStep{Time: prices[t].Time, Price: prices[t].Price, Power: power, SOC: float64(s) * step}
+		if !math.IsNaN(load[t]) {
+			l := load[t]
+			steps[t].Load = &l
+		}
+		s = prev
+	}
+	// prices are per MWh
+	return steps, -cost[best] / 1000, nil
+}
+
+// floatParam returns the value of the query parameter name, or def if not
+// set.
+func floatParam(q url.Values, name string, def float64) (float64, error) {
+	s := q.Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid %s '%s'", name, s)
+	}
+	return v, nil
+}
+
+// parseBattery reads the battery from the query parameters.
+func parseBattery(q url.Values) (Battery, error) {
+	var (
+		b   Battery
+		err error
+	)
+	for _, p := range []struct {
+		name string
+		v    *float64
+		def  float64
+	}{
+		{"capacity", &b.Capacity, 0},
+		{"charge_power", &b.ChargePower, 0},
+		{"discharge_power", &b.DischargePower, 0},
+		{"efficiency", &b.Efficiency, 0.9},
+		{"soc", &b.SOC, 0},
+		{"min_soc", &b.MinSOC, 0},
+	} {
+		if *p.v, err = floatParam(q, p.name, p.def); err != nil {
+			return b, err
+		}
+	}
+	if b.EndSOC, err = floatParam(q, "end_soc", b.MinSOC); err != nil {
+		return b, err
+	}
+	switch {
+	case b.Capacity <= 0:
+		return b, fmt.Errorf("capacity must be positive")
+	case b.ChargePower < 0 || b.DischargePower < 0:
+		return b, fmt.Errorf("charge_power and discharge_power cannot be negative")
+	case b.Efficiency <= 0 || b.Efficiency > 1:
+		return b, fmt.Errorf("efficiency must be in (0, 1]")
+	case b.SOC < 0 || b.SOC > b.Capacity:
+		return b, fmt.Errorf("soc must be between 0 and capacity")
+	case b.MinSOC < 0 || b.MinSOC > b.Capacity:
+		return b, fmt.Errorf("min_soc must be between 0 and capacity")
+	case b.EndSOC < 0 || b.EndSOC > b.Capacity:
+		return b, fmt.Errorf("end_soc must be between 0 and capacity")
+	}
+	return b, nil
+}
+
+// planLoad returns the load forecast of every hour of the plan, in kWh: the
+// values of the load parameter, in order, or the daily_load parameter split
+// according to a load profile. Hours without a forecast are NaN.
+func planLoad(q url.Values, profiles *ProfileStore, hours []HourlyPrice) ([]float64, error) {
+	load := make([]float64, len(hours))
+	for idx := range load {
+		load[idx] = math.NaN()
+	}
+	if s := q.Get("load"); s != "" {
+		for idx, f := range strings.Split(s, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid load value #%d '%s'", idx, f)
+			}
+			if idx < len(load) {
+				load[idx] = v
+			}
+		}
+		return load, nil
+	}
+	daily, err := floatParam(q, "daily_load", 0)
+	if err != nil {
+		return nil, err
+	}
+	if daily <= 0 {
+		return load, nil
+	}
+	name := q.Get("profile")
+	if name == "" {
+		name = defaultProfile
+	}
+	profile, ok := profiles.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("no such profile '%s'", name)
+	}
+	var sum float64
+	for _, v := range profile.Values {
+		sum += v
+	}
+	for idx, h := range hours {
+		load[idx] = daily * profile.Weight(h.Time, time.Hour) / sum
+	}
+	return load, nil
+}
+
+// makePlanHandler returns the cheapest hourly charge and discharge plan of a
+// battery for the hours with published prices, starting at the current hour.
+func makePlanHandler(store *DayStore, profiles *ProfileStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := getTimeFromQuery(w, r)
+		if t == nil {
+			return
+		}
+		q := r.URL.Query()
+		battery, err := parseBattery(q)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		hours := defaultPlanHours
+		if s := q.Get("hours"); s != "" {
+			if hours, err = strconv.Atoi(s); err != nil || hours < 1 || hours > maxPlanHours {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(fmt.Sprintf("hours must be between 1 and %d", maxPlanHours)))
+				return
+			}
+		}
+		zone := getZoneFromQuery(r)
+		start := t.Truncate(time.Hour)
+		prices, ok := getHourlyPrices(w, r, store, midnight(start), midnight(start).AddDate(0, 0, 2), zone)
+		if !ok {
+			return
+		}
+		var plan []HourlyPrice
+		for _, p := range hourlyAverages(prices) {
+			if !p.Time.Before(start) && len(plan) < hours {
+				plan = append(plan, p)
+			}
+		}
+		if len(plan) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(fmt.Sprintf("No %s prices published after %s", zone, start.Format("2006-01-02 15:04"))))
+			return
+		}
+		load, err := planLoad(q, profiles, plan)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		feedIn, err := floatParam(q, "feed_in", 0)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		steps, savings, err := optimisePlan(battery, plan, load, feedIn)
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		writeJSON(w, Plan{Zone: zone, Battery: battery, Steps: steps, Savings: savings})
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// planPrices returns hourly prices starting at 00:00 of a winter day.
+func planPrices(values ...float64) []HourlyPrice {
+	start := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.Local)
+	prices := make([]HourlyPrice, len(values))
+	for idx, v := range values {
+		prices[idx] = HourlyPrice{Time: start.Add(time.Duration(idx) * time.Hour), Minutes: 60, Price: v}
+	}
+	return prices
+}
+
+// unknownLoad returns n hours without a load forecast.
+func unknownLoad(n int) []float64 {
+	load := make([]float64, n)
+	for idx := range load {
+		load[idx] = math.NaN()
+	}
+	return load
+}
+
+func TestOptimisePlan(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		battery Battery
+		prices  []float64
+		load    []float64
+		feedIn  float64
+		power   []float64
+		savings float64
+	}{
+		{
+			name:    "arbitrage",
+			battery: Battery{Capacity: 10, ChargePower: 10, DischargePower: 10, Efficiency: 1},
+			prices:  []float64{10, 100},
+			load:    []float64{0, 10},
+			power:   []float64{10, -10},
+			savings: 0.9,
+		},
+		{
+			name:    "discharge capped by the load",
+			battery: Battery{Capacity: 10, ChargePower: 10, DischargePower: 10, Efficiency: 1},
+			prices:  []float64{10, 100},
+			load:    []float64{0, 4},
+			power:   []float64{4, -4},
+			savings: 0.36,
+		},
+		{
+			name:    "unknown load is not sold",
+			battery: Battery{Capacity: 10, ChargePower: 10, DischargePower: 10, Efficiency: 1},
+			prices:  []float64{10, 100},
+			power:   []float64{0, 0},
+		},
+		{
+			name:    "unknown load at the feed-in price",
+			battery: Battery{Capacity: 10, ChargePower: 10, DischargePower: 10, Efficiency: 1},
+			prices:  []float64{10, 100},
+			feedIn:  50,
+			power:   []float64{10, -10},
+			savings: 0.4,
+		},
+		{
+			name:    "feed-in below the charge price",
+			battery: Battery{Capacity: 10, ChargePower: 10, DischargePower: 10, Efficiency: 1},
+			prices:  []float64{60, 100},
+			feedIn:  50,
+			power:   []float64{0, 0},
+		},
+		{
+			name:    "losses larger than the spread",
+			battery: Battery{Capacity: 10, ChargePower: 10, DischargePower: 10, Efficiency: 0.81},
+			prices:  []float64{100, 110},
+			load:    []float64{10, 10},
+			power:   []float64{0, 0},
+		},
+		{
+			name:    "charge power",
+			battery: Battery{Capacity: 10, ChargePower: 2, DischargePower: 10, Efficiency: 1},
+			prices:  []float64{10, 20, 100},
+			load:    []float64{0, 0, 10},
+			power:   []float64{2, 2, -4},
+			savings: (2*90 + 2*80) / 1000.0,
+		},
+		{
+			name:    "min_soc",
+			battery: Battery{Capacity: 10, ChargePower: 0, DischargePower: 10, Efficiency: 1, SOC: 5, MinSOC: 3},
+			prices:  []float64{100},
+			load:    []float64{10},
+			power:   []float64{-2},
+			savings: 0.2,
+		},
+		{
+			name:    "end_soc",
+			battery: Battery{Capacity: 10, ChargePower: 10, DischargePower: 10, Efficiency: 1, EndSOC: 10},
+			prices:  []float64{10, 100},
+			load:    []float64{0, 10},
+			power:   []float64{10, 0},
+			savings: -0.1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			load := tc.load
+			if load == nil {
+				load = unknownLoad(len(tc.prices))
+			}
+			steps, savings, err := optimisePlan(tc.battery, planPrices(tc.prices...), load, tc.feedIn)
+			if err != nil {
+				t.Fatalf("optimisePlan failed: %v", err)
+			}
+			if len(steps) != len(tc.power) {
+				t.Fatalf("got %d steps, want %d", len(steps), len(tc.power))
+			}
+			for idx, s := range steps {
+				if math.Abs(s.Power-tc.power[idx]) > 1e-6 {
+					t.Errorf("step #%d: got power %v, want %v", idx, s.Power, tc.power[idx])
+				}
+			}
+			if math.Abs(savings-tc.savings) > 1e-6 {
+				t.Errorf("got savings %v, want %v", savings, tc.savings)
+			}
+		})
+	}
+}
+
+func TestOptimisePlanSOCBounds(t *testing.T) {
+	b := Battery{Capacity: 13.5, ChargePower: 5, DischargePower: 4, Efficiency: 0.9, SOC: 6, MinSOC: 2, EndSOC: 8}
+	prices := planPrices(120, 80, 40, 35, 60, 150, 200, 90, 30, 25, 180, 210)
+	load := make([]float64, len(prices))
+	for idx := range load {
+		load[idx] = 3
+	}
+	steps, _, err := optimisePlan(b, prices, load, 0)
+	if err != nil {
+		t.Fatalf("optimisePlan failed: %v", err)
+	}
+	soc := b.SOC
+	eff := math.Sqrt(b.Efficiency)
+	for idx, s := range steps {
+		if s.SOC < b.MinSOC-1e-9 || s.SOC > b.Capacity+1e-9 {
+			t.Errorf("step #%d: state of charge %v out of [%v, %v]", idx, s.SOC, b.MinSOC, b.Capacity)
+		}
+		if s.Power > b.ChargePower+1e-9 || s.Power < -b.DischargePower-1e-9 {
+			t.Errorf("step #%d: power %v beyond the battery's", idx, s.Power)
+		}
+		// the power moves the state of charge, net of the losses
+		stored := s.Power * eff
+		if s.Power < 0 {
+			stored = s.Power / eff
+		}
+		if math.Abs(soc+stored-s.SOC) > b.Capacity/planLevels {
+			t.Errorf("step #%d: %v kW from %v kWh leads to %v kWh", idx, s.Power, soc, s.SOC)
+		}
+		soc = s.SOC
+	}
+	if last := steps[len(steps)-1].SOC; last < b.EndSOC-1e-9 {
+		t.Errorf("the plan ends at %v kWh, want at least %v", last, b.EndSOC)
+	}
+}
+
+func TestOptimisePlanUnreachableEndSOC(t *testing.T) {
+	b := Battery{Capacity: 10, ChargePower: 1, DischargePower: 1, Efficiency: 1, EndSOC: 5}
+	if steps, _, err := optimisePlan(b, planPrices(10, 20), unknownLoad(2), 0); err == nil {
+		t.Errorf("want an error, got %+v", steps)
+	}
+}
+
+func TestParseBattery(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		want  Battery
+		ok    bool
+	}{
+		{
+			query: "capacity=10&charge_power=3&discharge_power=4&soc=5&min_soc=1",
+			want:  Battery{Capacity: 10, ChargePower: 3, DischargePower: 4, Efficiency: 0.9, SOC: 5, MinSOC: 1, EndSOC: 1},
+			ok:    true,
+		},
+		{
+			query: "capacity=10&efficiency=1&end_soc=8",
+			want:  Battery{Capacity: 10, Efficiency: 1, EndSOC: 8},
+			ok:    true,
+		},
+		{query: ""},
+		{query: "capacity=-1"},
+		{query: "capacity=ten"},
+		{query: "capacity=NaN"},
+		{query: "capacity=10&charge_power=-1"},
+		{query: "capacity=10&efficiency=0"},
+		{query: "capacity=10&efficiency=1.1"},
+		{query: "capacity=10&soc=11"},
+		{query: "capacity=10&min_soc=-1"},
+		{query: "capacity=10&end_soc=11"},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			q, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseBattery(q)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("got %+v and %v, want %+v", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestPlanLoad(t *testing.T) {
+	hours := planPrices(1, 2, 3)
+	profiles := NewProfileStore()
+	for _, tc := range []struct {
+		query string
+		want  []float64
+		ok    bool
+	}{
+		{query: "", want: []float64{math.NaN(), math.NaN(), math.NaN()}, ok: true},
+		{query: "load=1,2.5", want: []float64{1, 2.5, math.NaN()}, ok: true},
+		// values beyond the plan are ignored
+		{query: "load=1,2,3,4", want: []float64{1, 2, 3}, ok: true},
+		{query: "daily_load=24&profile=flat", want: []float64{1, 1, 1}, ok: true},
+		{query: "load=1,x"},
+		{query: "load=-1"},
+		{query: "daily_load=x"},
+		{query: "daily_load=24&profile=missing"},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			q, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := planLoad(q, profiles, hours)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("want an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("planLoad failed: %v", err)
+			}
+			for idx, want := range tc.want {
+				if math.IsNaN(want) != math.IsNaN(got[idx]) || (!math.IsNaN(want) && math.Abs(got[idx]-want) > 1e-9) {
+					t.Errorf("hour #%d: got %v, want %v", idx, got[idx], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// LoadProfile is a daily consumption curve used to weight hourly prices. It
+// has either 24 hourly or 96 quarter-hourly values, starting at midnight Italian
+// time. Only the relative size of the values matters.
+type LoadProfile struct {
+	Name    string    `json:"name"`
+	Values  []float64 `json:"values"`
+	BuiltIn bool      `json:"built_in"`
+}
+
+// defaultProfile is the profile used when none is requested.
+const defaultProfile = "flat"
+
+var flatProfile = []float64{
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+}
+
+// Weight returns the weight of the market interval starting at t and lasting
+// d, one hour or 15 minutes, according to the Italian wall clock. Quarter-hourly
+// profiles are summed over an hour, and hourly ones are split evenly over its
+// quarters. During the DST changes the repeated hour gets the same weight
+// twice, and the skipped hour is not used.
+func (p *LoadProfile) Weight(t time.Time, d time.Duration) float64 {
+	t = t.In(market.Location)
+	hour, quarter := t.Hour(), t.Hour()*4+t.Minute()/15
+	switch {
+	case len(p.Values) == 96 && d < time.Hour:
+		return p.Values[quarter]
+	case len(p.Values) == 96:
+		return p.Values[hour*4] + p.Values[hour*4+1] + p.Values[hour*4+2] + p.Values[hour*4+3]
+	case d < time.Hour:
+		return p.Values[hour] / 4
+	}
+	return p.Values[hour]
+}
+
+// ParseLoadProfile parses 24 or 96 non-negative numbers separated by commas,
+// semicolons or white space. A comma is treated as a separator, so decimals
+// must use a dot.
+func ParseLoadProfile(name, s string) (*LoadProfile, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(fields) != 24 && len(fields) != 96 {
+		return nil, fmt.Errorf("want 24 or 96 values, got %d", len(fields))
+	}
+	values := make([]float64, 0, len(fields))
+	var sum float64
+	for idx, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value #%d: %w", idx, err)
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("value #%d is not a finite number", idx)
+		}
+		if v < 0 {
+			return nil, fmt.Errorf("value #%d is negative", idx)
+		}
+		values = append(values, v)
+		sum += v
+	}
+	if sum == 0 {
+		return nil, fmt.Errorf("all values are zero")
+	}
+	if math.IsInf(sum, 0) {
+		return nil, fmt.Errorf("the values are too large")
+	}
+	return &LoadProfile{Name: name, Values: values}, nil
+}
+
+// ProfileStore holds the built-in and the uploaded load profiles.
+type ProfileStore struct {
+	mu       sync.Mutex
+	profiles map[string]*LoadProfile
+}
+
+// NewProfileStore returns a store with the built-in flat profile.
+func NewProfileStore() *ProfileStore {
+	return &ProfileStore{
+		profiles: map[string]*LoadProfile{
+			"flat": {Name: "flat", Values: flatProfile, BuiltIn: true},
+		},
+	}
+}
+
+// Get returns the profile with the given name.
+func (s *ProfileStore) Get(name string) (*LoadProfile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.profiles[name]
+	return p, ok
+}
+
+// Put stores an uploaded profile. Built-in profiles cannot be replaced.
+func (s *ProfileStore) Put(p *LoadProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.profiles[p.Name]; ok && old.BuiltIn {
+		return fmt.Errorf("cannot replace built-in profile '%s'", p.Name)
+	}
+	s.profiles[p.Name] = p
+	return nil
+}
+
+// Delete removes an uploaded profile.
+func (s *ProfileStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.profiles[name]
+	if !ok {
+		return fmt.Errorf("no such profile '%s'", name)
+	}
+	if p.BuiltIn {
+		return fmt.Errorf("cannot delete built-in profile '%s'", name)
+	}
+	delete(s.profiles, name)
+	return nil
+}
+
+// List returns all the profiles, sorted by name.
+func (s *ProfileStore) List() []*LoadProfile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*LoadProfile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+var validProfileName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// makeProfilesHandler serves /profiles/. GET /profiles/ lists all the
+// profiles, GET /profiles/<name> returns one, PUT /profiles/<name> uploads
+// one as 24 or 96 values in the request body, and DELETE /profiles/<name>
+// removes it.
+func makeProfilesHandler(profiles *ProfileStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/profiles/")
+		if name == "" {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", "GET")
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			writeJSON(w, profiles.List())
+			return
+		}
+		if !validProfileName.MatchString(name) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("Profile names can only contain letters, digits, '-' and '_'"))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			p, ok := profiles.Get(name)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(fmt.Sprintf("No such profile '%s'", name)))
+				return
+			}
+			writeJSON(w, p)
+		case http.MethodPut:
+			body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(fmt.Sprintf("Failed to read body: %v", err)))
+				return
+			}
+			p, err := ParseLoadProfile(name, string(body))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(fmt.Sprintf("Invalid profile: %v", err)))
+				return
+			}
+			if err := profiles.Put(p); err != nil {
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			if err := profiles.Delete(name); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// WeightedAverage is the response of /weighted.
+type WeightedAverage struct {
+	Profile         string  `json:"profile"`
+	Zone            string  `json:"zone"`
+	From            string  `json:"from"`
+	To              string  `json:"to"`
+	WeightedAverage float64 `json:"weighted_average"`
+	SimpleAverage   float64 `json:"simple_average"`
+	Intervals       int     `json:"intervals"`
+}
+
+// weightedAverage returns the average of prices weighted by the consumption
+// of profile in each hour, and the plain average.
+func weightedAverage(prices []HourlyPrice, profile *LoadProfile) (weighted, simple float64, err error) {
+	var sum, weightedSum, weights float64
+	for _, p := range prices {
+		w := profile.Weight(p.Time, time.Duration(p.Minutes)*time.Minute)
+		sum += p.Price
+		weightedSum += p.Price * w
+		weights += w
+	}
+	if len(prices) == 0 || weights == 0 {
+		return 0, 0, fmt.Errorf("no consumption in the period")
+	}
+	return weightedSum / weights, sum / float64(len(prices)), nil
+}
+
+// makeWeightedHandler returns the consumption-weighted average price of a
+// zone over a period, using the load profile in the `profile` parameter
+// (default: flat).
+func makeWeightedHandler(store *DayStore, profiles *ProfileStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, ok := getPeriodFromQuery(w, r)
+		if !ok {
+			return
+		}
+		zone := getZoneFromQuery(r)
+		name := r.URL.Query().Get("profile")
+		if name == "" {
+			name = defaultProfile
+		}
+		profile, ok := profiles.Get(name)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(fmt.Sprintf("No such profile '%s'", name)))
+			return
+		}
+		prices, ok := getHourlyPrices(w, r, store, from, to, zone)
+		if !ok {
+			return
+		}
+		weighted, simple, err := weightedAverage(prices, profile)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		writeJSON(w, WeightedAverage{
+			Profile:         name,
+			Zone:            zone,
+			From:            from.Format("2006-01-02"),
+			To:              to.Format("2006-01-02"),
+			WeightedAverage: weighted,
+			SimpleAverage:   simple,
+			Intervals:       len(prices),
+		})
+	}
+}
+
+// writeJSON writes v to w as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("Failed to marshal JSON: %v", err)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
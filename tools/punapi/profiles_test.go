@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+func TestParseLoadProfile(t *testing.T) {
+	hourly := strings.TrimSpace(strings.Repeat("1, ", 24))
+	for _, tc := range []struct {
+		name   string
+		values string
+		ok     bool
+	}{
+		{"24 values", hourly, true},
+		{"96 values", strings.Repeat("0.5 ", 96), true},
+		{"semicolons and newlines", strings.Repeat("1;\n", 24), true},
+		{"some zeros", "0 " + strings.Repeat("1 ", 23), true},
+		{"too few", strings.Repeat("1 ", 23), false},
+		{"too many", strings.Repeat("1 ", 25), false},
+		{"not a number", "x " + strings.Repeat("1 ", 23), false},
+		{"negative", "-1 " + strings.Repeat("1 ", 23), false},
+		{"all zeros", strings.Repeat("0 ", 24), false},
+		{"NaN", "NaN " + strings.Repeat("1 ", 23), false},
+		{"infinite", "+Inf " + strings.Repeat("1 ", 23), false},
+		{"too large", "1e308 1e308 " + strings.Repeat("1 ", 22), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParseLoadProfile("test", tc.values)
+			if tc.ok && err != nil {
+				t.Errorf("ParseLoadProfile failed: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Errorf("want an error, got %v", p.Values)
+			}
+		})
+	}
+}
+
+func TestBuiltInProfiles(t *testing.T) {
+	profiles := NewProfileStore()
+	for _, name := range []string{"flat"} {
+		p, ok := profiles.Get(name)
+		if !ok {
+			t.Fatalf("no built-in profile %s", name)
+		}
+		fields := make([]string, len(p.Values))
+		for idx, v := range p.Values {
+			fields[idx] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		if _, err := ParseLoadProfile(name, strings.Join(fields, ",")); err != nil {
+			t.Errorf("built-in profile %s is not valid: %v", name, err)
+		}
+		if err := profiles.Put(&LoadProfile{Name: name, Values: flatProfile}); err == nil {
+			t.Errorf("built-in profile %s was replaced", name)
+		}
+		if err := profiles.Delete(name); err == nil {
+			t.Errorf("built-in profile %s was deleted", name)
+		}
+	}
+}
+
+func TestProfilesHandler(t *testing.T) {
+	profiles := NewProfileStore()
+	h := makeProfilesHandler(profiles)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	if w := do(http.MethodPut, "/profiles/home", strings.Repeat("2 ", 24)); w.Code != http.StatusCreated {
+		t.Fatalf("PUT got %d: %s", w.Code, w.Body.String())
+	}
+	w := do(http.MethodGet, "/profiles/home", "")
+	var p LoadProfile
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || len(p.Values) != 24 || p.BuiltIn {
+		t.Errorf("GET got %q and %v", w.Body.String(), err)
+	}
+	w = do(http.MethodGet, "/profiles/", "")
+	var list []LoadProfile
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 2 || list[1].Name != "home" {
+		t.Errorf("list got %q and %v", w.Body.String(), err)
+	}
+	for _, tc := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodPut, "/profiles/home", "1 2 3", http.StatusBadRequest},
+		{http.MethodPut, "/profiles/flat", strings.Repeat("2 ", 24), http.StatusConflict},
+		{http.MethodPut, "/profiles/a.b", strings.Repeat("2 ", 24), http.StatusBadRequest},
+		{http.MethodGet, "/profiles/missing", "", http.StatusNotFound},
+		{http.MethodDelete, "/profiles/flat", "", http.StatusBadRequest},
+		{http.MethodPost, "/profiles/home", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/profiles/", "", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/profiles/home", "", http.StatusOK},
+		{http.MethodGet, "/profiles/home", "", http.StatusNotFound},
+	} {
+		if w := do(tc.method, tc.target, tc.body); w.Code != tc.code {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.target, w.Code, tc.code)
+		}
+	}
+}
+
+func TestWeightedAverage(t *testing.T) {
+	start := time.Date(2024, time.January, 15, 0, 0, 0, 0, market.Location)
+	var prices []HourlyPrice
+	for hour := 0; hour < 24; hour++ {
+		prices = append(prices, HourlyPrice{Time: start.Add(time.Duration(hour) * time.Hour), Price: float64(hour)})
+	}
+	// only the consumption between 10:00 and 12:00 counts
+	values := make([]float64, 24)
+	values[10], values[11] = 1, 3
+	weighted, simple, err := weightedAverage(prices, &LoadProfile{Name: "test", Values: values})
+	if err != nil {
+		t.Fatalf("weightedAverage failed: %v", err)
+	}
+	if math.Abs(weighted-10.75) > 1e-9 || math.Abs(simple-11.5) > 1e-9 {
+		t.Errorf("got %v and %v, want 10.75 and 11.5", weighted, simple)
+	}
+	if _, _, err := weightedAverage(nil, &LoadProfile{Name: "flat", Values: flatProfile}); err == nil {
+		t.Error("want an error without prices")
+	}
+}
+
+func TestProfileWeight(t *testing.T) {
+	hourly := make([]float64, 24)
+	quarterly := make([]float64, 96)
+	for idx := range hourly {
+		hourly[idx] = float64(idx)
+	}
+	for idx := range quarterly {
+		quarterly[idx] = float64(idx)
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, time.January, 15, hour, minute, 0, 0, market.Location)
+	}
+	for _, tc := range []struct {
+		name   string
+		values []float64
+		t      time.Time
+		d      time.Duration
+		want   float64
+	}{
+		{"hourly profile, hour", hourly, at(9, 0), time.Hour, 9},
+		{"hourly profile, quarter-hour", hourly, at(9, 45), 15 * time.Minute, 9.0 / 4},
+		{"quarter-hourly profile, hour", quarterly, at(1, 0), time.Hour, 4 + 5 + 6 + 7},
+		{"quarter-hourly profile, quarter-hour", quarterly, at(1, 30), 15 * time.Minute, 6},
+		// 08:00 UTC is 09:00 in Italy
+		{"UTC time", hourly, time.Date(2024, time.January, 15, 8, 0, 0, 0, time.UTC), time.Hour, 9},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &LoadProfile{Name: "test", Values: tc.values}
+			if got := p.Weight(tc.t, tc.d); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// defaultScraperConfig is the navigation flow used when no scraper
+// configuration file is specified.
+//
+//go:embed scraper.json
+var defaultScraperConfig []byte
+
+// scraperConfigVersion is the only supported version of the scraper
+// configuration format.
+const scraperConfigVersion = 1
+
+// selectorPollInterval is how often a step looks for its selectors.
+const selectorPollInterval = 250 * time.Millisecond
+
+// Step actions.
+const (
+	// ActionNavigate loads the step's URL, or the start URL.
+	ActionNavigate = "navigate"
+	// ActionWait waits for the selector to be visible.
+	ActionWait = "wait"
+	// ActionClick clicks on the selector.
+	ActionClick = "click"
+	// ActionTypeDate types the start or end date of the requested period
+	// into the selector.
+	ActionTypeDate = "type_date"
+	// ActionDownload clicks on the selector and expects a file download.
+	ActionDownload = "download"
+)
+
+// ScraperStep is a single step of the navigation flow.
+type ScraperStep struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	// URL is only used by navigate steps. If empty, the start URL is used.
+	URL string `json:"url,omitempty"`
+	// Selector is what the step acts on. Fallback is tried too, in case the
+	// site changed and Selector does not match anymore.
+	Selector string `json:"selector,omitempty"`
+	Fallback string `json:"fallback,omitempty"`
+	// By is the selector type: search (default, XPath or CSS), xpath,
+	// query (CSS) or id.
+	By string `json:"by,omitempty"`
+	// Date is the date typed by type_date steps: start or end.
+	Date string `json:"date,omitempty"`
+	// DateFormat overrides the configuration's date format for this step.
+	DateFormat string `json:"date_format,omitempty"`
+	// Optional steps are skipped if neither selector is on the page, e.g.
+	// a consent form that is only shown once.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// ScraperConfig describes the navigation flow that downloads the prices
+// from mercatoelettrico.org.
+type ScraperConfig struct {
+	Version    int           `json:"version"`
+	StartURL   string        `json:"start_url"`
+	DateFormat string        `json:"date_format"`
+	Steps      []ScraperStep `json:"steps"`
+}
+
+// LoadScraperConfig loads and validates the scraper configuration at
+// configFile, or the default one if configFile is empty.
+func LoadScraperConfig(configFile string) (*ScraperConfig, error) {
+	data := defaultScraperConfig
+	if configFile != "" {
+		var err error
+		data, err = os.ReadFile(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scraper configuration: %w", err)
+		}
+	}
+	var cfg ScraperConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal scraper configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scraper configuration: %w", err)
+	}
+	return &cfg, nil
+}
+
+func validateDateFormat(format string) error {
+	ref := time.Date(2006, time.January, 2, 0, 0, 0, 0, time.UTC)
+	t, err := time.Parse(format, ref.Format(format))
+	if err != nil {
+		return fmt.Errorf("date format '%s' cannot be parsed back: %w", format, err)
+	}
+	if !t.Equal(ref) {
+		return fmt.Errorf("date format '%s' must include year, month and day", format)
+	}
+	return nil
+}
+
+// Validate checks that the configuration is complete and consistent.
+func (c *ScraperConfig) Validate() error {
+	if c.Version != scraperConfigVersion {
+		return fmt.Errorf("unsupported version %d, want %d", c.Version, scraperConfigVersion)
+	}
+	u, err := url.Parse(c.StartURL)
+	if err != nil {
+		return fmt.Errorf("invalid start URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("start URL must have scheme and host")
+	}
+	if err := validateDateFormat(c.DateFormat); err != nil {
+		return err
+	}
+	if len(c.Steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+	names := make(map[string]bool)
+	downloads := 0
+	for idx, s := range c.Steps {
+		if s.Name == "" {
+			return fmt.Errorf("step #%d has no name", idx)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("duplicate step name '%s'", s.Name)
+		}
+		names[s.Name] = true
+		switch s.Action {
+		case ActionNavigate:
+			if s.URL != "" {
+				if _, err := url.Parse(s.URL); err != nil {
+					return fmt.Errorf("step '%s': invalid URL: %w", s.Name, err)
+				}
+			}
+		case ActionWait, ActionClick, ActionTypeDate, ActionDownload:
+			if s.Selector == "" {
+				return fmt.Errorf("step '%s': action '%s' requires a selector", s.Name, s.Action)
+			}
+		default:
+			return fmt.Errorf("step '%s': unknown action '%s'", s.Name, s.Action)
+		}
+		switch s.By {
+		case "", "search", "xpath", "query", "id":
+		default:
+			return fmt.Errorf("step '%s': unknown selector type '%s'", s.Name, s.By)
+		}
+		if s.Action == ActionTypeDate {
+			if s.Date != "start" && s.Date != "end" {
+				return fmt.Errorf("step '%s': date must be 'start' or 'end', got '%s'", s.Name, s.Date)
+			}
+			if s.DateFormat != "" {
+				if err := validateDateFormat(s.DateFormat); err != nil {
+					return fmt.Errorf("step '%s': %w", s.Name, err)
+				}
+			}
+		}
+		if s.Action == ActionDownload {
+			downloads++
+			if s.Optional {
+				return fmt.Errorf("step '%s': download steps cannot be optional", s.Name)
+			}
+		}
+	}
+	if downloads != 1 {
+		return fmt.Errorf("want exactly one download step, got %d", downloads)
+	}
+	if c.Steps[len(c.Steps)-1].Action != ActionDownload {
+		return fmt.Errorf("the download step must be the last one")
+	}
+	return nil
+}
+
+func (s *ScraperStep) queryOption() chromedp.QueryOption {
+	switch s.By {
+	case "xpath":
+		return chromedp.BySearch
+	case "query":
+		return chromedp.ByQuery
+	case "id":
+		return chromedp.ByID
+	default:
+		return chromedp.BySearch
+	}
+}
+
+// resolveSelector returns the first of the step's selectors that matches a
+// node on the page, polling until ctx is done. Optional steps do not poll,
+// and return an empty selector if nothing matches.
+func (s *ScraperStep) resolveSelector(ctx context.Context) (string, error) {
+	selectors := []string{s.Selector}
+	if s.Fallback != "" {
+		selectors = append(selectors, s.Fallback)
+	}
+	for {
+		for idx, sel := range selectors {
+			var nodes []*cdp.Node
+			if err := chromedp.Nodes(sel, &nodes, s.queryOption(), chromedp.AtLeast(0)).Do(ctx); err != nil {
+				return "", fmt.Errorf("failed to look up selector '%s': %w", sel, err)
+			}
+			if len(nodes) > 0 {
+				if idx > 0 {
+					log.Printf("Warning: step '%s' matched its fallback selector, the primary one may be outdated", s.Name)
+				}
+				return sel, nil
+			}
+		}
+		if s.Optional {
+			return "", nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no node matches '%s' or its fallback: %w", s.Selector, ctx.Err())
+		case <-time.After(selectorPollInterval):
+		}
+	}
+}
+
+// Action returns the chromedp action of the step. start and end are the
+// requested period, and setupDownload is run before clicking on a download
+// step.
+func (c *ScraperConfig) Action(s ScraperStep, start, end time.Time, setupDownload chromedp.Action) chromedp.Action {
+	if s.Action == ActionNavigate {
+		u := s.URL
+		if u == "" {
+			u = c.StartURL
+		}
+		return chromedp.Navigate(u)
+	}
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		sel, err := s.resolveSelector(ctx)
+		if err != nil {
+			return err
+		}
+		if sel == "" {
+			log.Printf("Skipping optional step '%s', its selector is not on the page", s.Name)
+			return nil
+		}
+		tasks := chromedp.Tasks{chromedp.WaitVisible(sel, s.queryOption())}
+		switch s.Action {
+		case ActionClick:
+			tasks = append(tasks, chromedp.Click(sel, s.queryOption()))
+		case ActionTypeDate:
+			tasks = append(tasks, chromedp.SendKeys(sel, c.dateText(s, start, end), s.queryOption()))
+		case ActionDownload:
+			if setupDownload != nil {
+				tasks = append(tasks, setupDownload)
+			}
+			tasks = append(tasks, chromedp.Click(sel, s.queryOption()))
+		}
+		return tasks.Do(ctx)
+	})
+}
+
+// dateText returns the text typed by a type-date step: the start or end
+// market day in the step's date format, in Italian time.
+func (c *ScraperConfig) dateText(s ScraperStep, start, end time.Time) string {
+	format := c.DateFormat
+	if s.DateFormat != "" {
+		format = s.DateFormat
+	}
+	t := start
+	if s.Date == "end" {
+		t = end
+	}
+	return t.In(market.Location).Format(format)
+}
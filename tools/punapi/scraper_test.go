@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// validScraperConfig returns a minimal valid configuration.
+func validScraperConfig() ScraperConfig {
+	return ScraperConfig{
+		Version:    scraperConfigVersion,
+		StartURL:   "https://example.com/download",
+		DateFormat: "02/01/2006",
+		Steps: []ScraperStep{
+			{Name: "navigate", Action: ActionNavigate},
+			{Name: "consent", Action: ActionClick, Selector: "#consent", By: "query", Optional: true},
+			{Name: "start date", Action: ActionTypeDate, Selector: "#start", By: "query", Date: "start"},
+			{Name: "end date", Action: ActionTypeDate, Selector: "#end", By: "query", Date: "end", DateFormat: "2006-01-02"},
+			{Name: "download", Action: ActionDownload, Selector: "//input[@id='download']", Fallback: "//input[@type='submit']"},
+		},
+	}
+}
+
+func TestScraperConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mutate func(c *ScraperConfig)
+		ok     bool
+	}{
+		{name: "valid", mutate: func(c *ScraperConfig) {}, ok: true},
+		{
+			name:   "fallback selector",
+			mutate: func(c *ScraperConfig) { c.Steps[1].Fallback = "//button[contains(., 'OK')]" },
+			ok:     true,
+		},
+		{name: "unsupported version", mutate: func(c *ScraperConfig) { c.Version = 2 }},
+		{name: "relative start URL", mutate: func(c *ScraperConfig) { c.StartURL = "/download" }},
+		{name: "empty date format", mutate: func(c *ScraperConfig) { c.DateFormat = "" }},
+		{name: "date format without the year", mutate: func(c *ScraperConfig) { c.DateFormat = "02/01" }},
+		{name: "invalid step date format", mutate: func(c *ScraperConfig) { c.Steps[3].DateFormat = "15:04" }},
+		{name: "no steps", mutate: func(c *ScraperConfig) { c.Steps = nil }},
+		{name: "unnamed step", mutate: func(c *ScraperConfig) { c.Steps[1].Name = "" }},
+		{name: "duplicate step name", mutate: func(c *ScraperConfig) { c.Steps[2].Name = "consent" }},
+		{name: "missing selector", mutate: func(c *ScraperConfig) { c.Steps[1].Selector = "" }},
+		{name: "fallback without a selector", mutate: func(c *ScraperConfig) { c.Steps[4].Selector = "" }},
+		{name: "unknown action", mutate: func(c *ScraperConfig) { c.Steps[1].Action = "hover" }},
+		{name: "unknown by", mutate: func(c *ScraperConfig) { c.Steps[1].By = "css" }},
+		{name: "unknown date", mutate: func(c *ScraperConfig) { c.Steps[2].Date = "today" }},
+		{name: "optional download", mutate: func(c *ScraperConfig) { c.Steps[4].Optional = true }},
+		{
+			name:   "no download",
+			mutate: func(c *ScraperConfig) { c.Steps = c.Steps[:4] },
+		},
+		{
+			name: "two downloads",
+			mutate: func(c *ScraperConfig) {
+				c.Steps = append(c.Steps, ScraperStep{Name: "download again", Action: ActionDownload, Selector: "#again"})
+			},
+		},
+		{
+			name: "download not last",
+			mutate: func(c *ScraperConfig) {
+				c.Steps = append(c.Steps, ScraperStep{Name: "wait", Action: ActionWait, Selector: "body"})
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := validScraperConfig()
+			tc.mutate(&c)
+			err := c.Validate()
+			if tc.ok && err != nil {
+				t.Errorf("Validate failed: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Error("want an error")
+			}
+		})
+	}
+}
+
+func TestLoadScraperConfig(t *testing.T) {
+	// the shipped configuration is the default one
+	cfg, err := LoadScraperConfig("")
+	if err != nil {
+		t.Fatalf("the shipped scraper.json is invalid: %v", err)
+	}
+	last := cfg.Steps[len(cfg.Steps)-1]
+	if last.Action != ActionDownload || last.Selector == "" || last.Fallback == "" {
+		t.Errorf("unexpected download step %+v", last)
+	}
+
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{
+			name: "valid",
+			data: `{"version": 1, "start_url": "https://example.com", "date_format": "2006-01-02",
+				"steps": [{"name": "download", "action": "download", "selector": "#download", "by": "query"}]}`,
+			ok: true,
+		},
+		{name: "not json", data: "version: 1"},
+		{
+			name: "invalid",
+			data: `{"version": 1, "start_url": "https://example.com", "date_format": "2006-01-02", "steps": []}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := path.Join(dir, tc.name+".json")
+			if err := os.WriteFile(p, []byte(tc.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadScraperConfig(p)
+			if tc.ok && err != nil {
+				t.Errorf("LoadScraperConfig failed: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Error("want an error")
+			}
+		})
+	}
+	if _, err := LoadScraperConfig(path.Join(dir, "missing.json")); err == nil {
+		t.Error("want an error for a missing file")
+	}
+}
+
+func TestScraperConfigDateText(t *testing.T) {
+	c := validScraperConfig()
+	// the market days start at 23:00 UTC in winter
+	start := time.Date(2024, time.January, 14, 23, 0, 0, 0, time.UTC)
+	end := time.Date(2024, time.January, 15, 23, 0, 0, 0, time.UTC)
+	if got := c.dateText(c.Steps[2], start, end); got != "15/01/2024" {
+		t.Errorf("start date: got %s, want 15/01/2024", got)
+	}
+	// the step's own format wins
+	if got := c.dateText(c.Steps[3], start, end); got != "2024-01-16" {
+		t.Errorf("end date: got %s, want 2024-01-16", got)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// HourlyPrice is the price of a zone in the market interval starting at Time.
+// Intervals last one hour, or 15 minutes in the 15-minute market.
+type HourlyPrice struct {
+	Time    time.Time `json:"time"`
+	Minutes int       `json:"minutes"`
+	Price   float64   `json:"price"`
+}
+
+// rowInterval returns the length of the market interval of a row.
+func rowInterval(p *Prezzi) time.Duration {
+	if p.Periodo > 0 {
+		return 15 * time.Minute
+	}
+	return time.Hour
+}
+
+// rowTime returns the start of the market interval of a row, in Italian time.
+// Intervals are counted from midnight in elapsed time, so that the 23 and 25
+// hour days of the DST changes map to the right instants.
+func rowTime(p *Prezzi) (time.Time, error) {
+	day, err := time.ParseInLocation("20060102", p.Data, market.Location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid market day '%s': %w", p.Data, err)
+	}
+	// Ora and Periodo start at 1
+	if p.Periodo > 0 {
+		return day.Add(time.Duration(p.Periodo-1) * 15 * time.Minute), nil
+	}
+	return day.Add(time.Duration(p.Ora-1) * time.Hour), nil
+}
+
+// hourlyPrices returns the prices of zone in days, in chronological order.
+// Intervals without a price for zone are skipped.
+func hourlyPrices(days []PUNXML, zone string) ([]HourlyPrice, error) {
+	var prices []HourlyPrice
+	for _, pun := range days {
+		for idx := range pun.Prezzi {
+			p := &pun.Prezzi[idx]
+			price, ok := p.Price(zone)
+			if !ok {
+				continue
+			}
+			t, err := rowTime(p)
+			if err != nil {
+				return nil, err
+			}
+			prices = append(prices, HourlyPrice{
+				Time:    t,
+				Minutes: int(rowInterval(p) / time.Minute),
+				Price:   float64(price),
+			})
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Time.Before(prices[j].Time) })
+	return prices, nil
+}
+
+// getHourlyPrices returns the hourly prices of zone from `from` to `to`. On
+// failure, or if there are no prices, it writes the error to w and returns
+// false.
+func getHourlyPrices(w http.ResponseWriter, r *http.Request, store *DayStore, from, to time.Time, zone string) ([]HourlyPrice, bool) {
+	days, err := store.Days(r.Context(), from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("Fetch failed: %v", err)))
+		return nil, false
+	}
+	prices, err := hourlyPrices(days, zone)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return nil, false
+	}
+	if len(prices) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(fmt.Sprintf("No %s found from %s to %s", zone, from.Format("2006-01-02"), to.Format("2006-01-02"))))
+		return nil, false
+	}
+	return prices, true
+}
+
+// makePricesHandler returns the hourly prices of a zone over a period as
+// JSON.
+func makePricesHandler(store *DayStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, ok := getPeriodFromQuery(w, r)
+		if !ok {
+			return
+		}
+		prices, ok := getHourlyPrices(w, r, store, from, to, getZoneFromQuery(r))
+		if !ok {
+			return
+		}
+		writeJSON(w, prices)
+	}
+}
+
+// percentile returns the p-th percentile (0-100) of the sorted values, with
+// linear interpolation between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// hourlyAverages returns the sorted prices averaged over every hour, weighted
+// by the length of their intervals, so that hours weigh the same whether the
+// market priced them by hour or by quarter-hour.
+func hourlyAverages(prices []HourlyPrice) []HourlyPrice {
+	var hours []HourlyPrice
+	for _, p := range prices {
+		start := p.Time.Truncate(time.Hour)
+		if len(hours) == 0 || !hours[len(hours)-1].Time.Equal(start) {
+			hours = append(hours, HourlyPrice{Time: start})
+		}
+		h := &hours[len(hours)-1]
+		h.Price += p.Price * float64(p.Minutes)
+		h.Minutes += p.Minutes
+	}
+	for idx := range hours {
+		hours[idx].Price /= float64(hours[idx].Minutes)
+		hours[idx].Minutes = 60
+	}
+	return hours
+}
+
+// aggregate computes the aggregation agg over hourly prices, which must not
+// be empty. Supported aggregations are avg, min, max, median, stddev, count,
+// argmin and argmax (the hour of the minimum and maximum price), and pNN for
+// the NN-th percentile, e.g. p10 or p90.
+func aggregate(agg string, prices []HourlyPrice) (interface{}, error) {
+	values := make([]float64, len(prices))
+	for idx, p := range prices {
+		values[idx] = p.Price
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	switch agg {
+	case "count":
+		return len(values), nil
+	case "avg":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values)), nil
+	case "min":
+		return sorted[0], nil
+	case "max":
+		return sorted[len(sorted)-1], nil
+	case "median":
+		return percentile(sorted, 50), nil
+	case "stddev":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		mean := sum / float64(len(values))
+		var sq float64
+		for _, v := range values {
+			sq += (v - mean) * (v - mean)
+		}
+		return math.Sqrt(sq / float64(len(values))), nil
+	case "argmin", "argmax":
+		best := prices[0]
+		for _, p := range prices[1:] {
+			if (agg == "argmin" && p.Price < best.Price) || (agg == "argmax" && p.Price > best.Price) {
+				best = p
+			}
+		}
+		return best.Time, nil
+	}
+	if strings.HasPrefix(agg, "p") {
+		p, err := strconv.ParseFloat(agg[1:], 64)
+		if err == nil && p >= 0 && p <= 100 {
+			return percentile(sorted, p), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown aggregation '%s'", agg)
+}
+
+// groupStart returns the start of the group of t: the day, the week starting
+// on Monday, the month, or the zero time if there is no grouping.
+func groupStart(group string, t time.Time) time.Time {
+	day := midnight(t)
+	switch group {
+	case "day":
+		return day
+	case "week":
+		// time.Weekday starts on Sunday
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return time.Time{}
+}
+
+// StatsGroup holds the aggregations of a group of hours.
+type StatsGroup struct {
+	Start time.Time              `json:"start"`
+	Stats map[string]interface{} `json:"stats"`
+}
+
+// StatsResponse is the response of /stats.
+type StatsResponse struct {
+	Zone   string       `json:"zone"`
+	From   string       `json:"from"`
+	To     string       `json:"to"`
+	Group  string       `json:"group"`
+	Groups []StatsGroup `json:"groups"`
+}
+
+// maxPeriodDays is the longest period, in days, accepted by the endpoints
+// taking `from` and `to`. Every missing day has to be downloaded, so a longer
+// period could start a very long series of fetches.
+const maxPeriodDays = 366
+
+// getPeriodFromQuery returns the period in the `from` and `to` query
+// parameters, as yyyy-mm-dd market days. It defaults to the current month up
+// to today, and is at most maxPeriodDays long. On failure it writes the error
+// to w and returns false.
+func getPeriodFromQuery(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	now := time.Now().In(market.Location)
+	from := groupStart("month", now)
+	to := midnight(now)
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		s := r.URL.Query().Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02", s, market.Location)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(fmt.Sprintf("%s parameter format must be yyyy-mm-dd", p.name)))
+			return from, to, false
+		}
+		*p.t = t
+	}
+	if to.Before(from) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("from must not be after to"))
+		return from, to, false
+	}
+	// both ends are included
+	if !to.Before(from.AddDate(0, 0, maxPeriodDays)) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(fmt.Sprintf("the period cannot be longer than %d days", maxPeriodDays)))
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// makeStatsHandler returns aggregations of the hourly prices of a zone over
+// a period, optionally grouped by day, week or month.
+func makeStatsHandler(store *DayStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, ok := getPeriodFromQuery(w, r)
+		if !ok {
+			return
+		}
+		zone := getZoneFromQuery(r)
+		aggs := []string{"avg"}
+		if s := r.URL.Query().Get("agg"); s != "" {
+			aggs = strings.Split(s, ",")
+		}
+		group := r.URL.Query().Get("group")
+		switch group {
+		case "":
+			group = "none"
+		case "none", "day", "week", "month":
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("group must be one of none, day, week, month"))
+			return
+		}
+		// validate the aggregations before fetching anything
+		for _, agg := range aggs {
+			if _, err := aggregate(agg, []HourlyPrice{{}}); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+		}
+
+		prices, ok := getHourlyPrices(w, r, store, from, to, zone)
+		if !ok {
+			return
+		}
+		prices = hourlyAverages(prices)
+
+		resp := StatsResponse{
+			Zone:  zone,
+			From:  from.Format("2006-01-02"),
+			To:    to.Format("2006-01-02"),
+			Group: group,
+		}
+		// prices are sorted, so every group is a contiguous slice
+		for start := 0; start < len(prices); {
+			gs := groupStart(group, prices[start].Time)
+			end := start + 1
+			for end < len(prices) && groupStart(group, prices[end].Time).Equal(gs) {
+				end++
+			}
+			g := StatsGroup{Start: gs, Stats: make(map[string]interface{})}
+			if group == "none" {
+				g.Start = from
+			}
+			for _, agg := range aggs {
+				// aggregations were validated above
+				g.Stats[agg], _ = aggregate(agg, prices[start:end])
+			}
+			resp.Groups = append(resp.Groups, g)
+			start = end
+		}
+		writeJSON(w, resp)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/prometheus-pun-exporter/internal/market"
+)
+
+// dayRows returns the rows of an hourly day with n hours, priced with their
+// Ora.
+func dayRows(data string, n int) []Prezzi {
+	rows := make([]Prezzi, n)
+	for idx := range rows {
+		rows[idx] = Prezzi{Data: data, Ora: idx + 1, Prices: map[string]Price{"PUN": Price(idx + 1)}}
+	}
+	return rows
+}
+
+func TestHourlyPrices(t *testing.T) {
+	days := []PUNXML{
+		{Prezzi: dayRows("20240116", 24)},
+		{Prezzi: dayRows("20240115", 24)},
+	}
+	// hours without a price for the zone are skipped
+	days[0].Prezzi[3].Prices = map[string]Price{"NORD": 1}
+	prices, err := hourlyPrices(days, "PUN")
+	if err != nil {
+		t.Fatalf("hourlyPrices failed: %v", err)
+	}
+	if len(prices) != 47 {
+		t.Fatalf("got %d prices, want 47", len(prices))
+	}
+	for idx := 1; idx < len(prices); idx++ {
+		if !prices[idx].Time.After(prices[idx-1].Time) {
+			t.Fatalf("price #%d at %s is not after %s", idx, prices[idx].Time, prices[idx-1].Time)
+		}
+	}
+	if first := prices[0]; first.Price != 1 || first.Time.Format("2006-01-02 15:04") != "2024-01-15 00:00" {
+		t.Errorf("unexpected first price %+v", first)
+	}
+	if _, err := hourlyPrices([]PUNXML{{Prezzi: []Prezzi{{Data: "2024-01-15", Ora: 1, Prices: map[string]Price{"PUN": 1}}}}}, "PUN"); err == nil {
+		t.Error("want an error for an invalid market day")
+	}
+}
+
+func TestRowTime(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		row  Prezzi
+		want time.Duration
+	}{
+		{"first hour", Prezzi{Data: "20240115", Ora: 1}, 0},
+		{"last hour", Prezzi{Data: "20240115", Ora: 24}, 23 * time.Hour},
+		{"first quarter-hour", Prezzi{Data: "20251001", Ora: 1, Periodo: 1}, 0},
+		{"quarter-hour", Prezzi{Data: "20251001", Ora: 11, Periodo: 42}, 10*time.Hour + 15*time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := rowTime(&tc.row)
+			if err != nil {
+				t.Fatalf("rowTime failed: %v", err)
+			}
+			day, _ := time.ParseInLocation("20060102", tc.row.Data, market.Location)
+			if d := got.Sub(day); d != tc.want {
+				t.Errorf("starts %s after midnight, want %s", d, tc.want)
+			}
+		})
+	}
+	if _, err := rowTime(&Prezzi{Data: "2024-01-15", Ora: 1}); err == nil {
+		t.Error("want an error for an invalid market day")
+	}
+}
+
+func TestHourlyPricesQuarterHours(t *testing.T) {
+	rows := []Prezzi{
+		{Data: "20251001", Ora: 1, Periodo: 2, Prices: map[string]Price{"PUN": 2}},
+		{Data: "20251001", Ora: 1, Periodo: 1, Prices: map[string]Price{"PUN": 1}},
+	}
+	prices, err := hourlyPrices([]PUNXML{{Prezzi: rows}}, "PUN")
+	if err != nil {
+		t.Fatalf("hourlyPrices failed: %v", err)
+	}
+	if len(prices) != 2 || prices[0].Price != 1 || prices[1].Price != 2 {
+		t.Fatalf("unexpected prices %+v", prices)
+	}
+	for _, p := range prices {
+		if p.Minutes != 15 {
+			t.Errorf("price at %s lasts %d minutes, want 15", p.Time, p.Minutes)
+		}
+	}
+	if d := prices[1].Time.Sub(prices[0].Time); d != 15*time.Minute {
+		t.Errorf("the quarter-hours are %s apart", d)
+	}
+}
+
+func TestGetPeriodFromQuery(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		code  int
+		days  int
+	}{
+		{"from=2024-01-01&to=2024-01-31", http.StatusOK, 31},
+		{"from=2024-01-01&to=2024-01-01", http.StatusOK, 1},
+		// 2024 is a leap year
+		{"from=2024-01-01&to=2024-12-31", http.StatusOK, 366},
+		{"from=2023-01-01&to=2024-01-01", http.StatusOK, 366},
+		{"from=2023-01-01&to=2024-01-02", http.StatusBadRequest, 0},
+		{"from=2020-01-01&to=2024-12-31", http.StatusBadRequest, 0},
+		{"from=2024-02-01&to=2024-01-31", http.StatusBadRequest, 0},
+		{"from=01/01/2024", http.StatusBadRequest, 0},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			from, to, ok := getPeriodFromQuery(w, httptest.NewRequest("GET", "/stats?"+tc.query, nil))
+			if ok != (tc.code == http.StatusOK) || w.Code != tc.code {
+				t.Fatalf("got ok %v and code %d, want code %d: %s", ok, w.Code, tc.code, w.Body.String())
+			}
+			if !ok {
+				return
+			}
+			if days := int(math.Round(to.Sub(from).Hours()/24)) + 1; days != tc.days {
+				t.Errorf("got %d days, want %d", days, tc.days)
+			}
+			if from.Location() != market.Location || to.Location() != market.Location {
+				t.Errorf("got %s and %s, want times in %s", from, to, market.Location)
+			}
+		})
+	}
+	// the default period is the current market month
+	w := httptest.NewRecorder()
+	from, to, ok := getPeriodFromQuery(w, httptest.NewRequest("GET", "/stats", nil))
+	if !ok {
+		t.Fatalf("got %d %s for the default period", w.Code, w.Body.String())
+	}
+	if from.Day() != 1 || from.Month() != to.Month() || from.Location() != market.Location {
+		t.Errorf("got %s to %s, want the current month in Italian time", from, to)
+	}
+}
+
+func TestHourlyAverages(t *testing.T) {
+	start := time.Date(2025, time.October, 1, 0, 0, 0, 0, market.Location)
+	var prices []HourlyPrice
+	for idx, v := range []float64{100, 200, 300, 400, 50} {
+		prices = append(prices, HourlyPrice{Time: start.Add(time.Duration(idx) * 15 * time.Minute), Minutes: 15, Price: v})
+	}
+	hours := hourlyAverages(prices)
+	if len(hours) != 2 || hours[0].Price != 250 || hours[1].Price != 50 || !hours[1].Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("unexpected hours %+v", hours)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{10, 20, 30, 40, 50}
+	for _, tc := range []struct {
+		p    float64
+		want float64
+	}{
+		{0, 10},
+		{100, 50},
+		{50, 30},
+		{25, 20},
+		{10, 14},
+		{90, 46},
+	} {
+		if got := percentile(sorted, tc.p); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("p%v: got %v, want %v", tc.p, got, tc.want)
+		}
+	}
+	if got := percentile([]float64{7}, 90); got != 7 {
+		t.Errorf("single value: got %v, want 7", got)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC)
+	var prices []HourlyPrice
+	for idx, v := range []float64{30, 10, 50, 10, 50, 20} {
+		prices = append(prices, HourlyPrice{Time: start.Add(time.Duration(idx) * time.Hour), Price: v})
+	}
+	for _, tc := range []struct {
+		agg  string
+		want interface{}
+	}{
+		{"count", 6},
+		{"avg", 28.333333333333332},
+		{"min", 10.0},
+		{"max", 50.0},
+		{"median", 25.0},
+		{"p0", 10.0},
+		{"p100", 50.0},
+		{"stddev", 16.74979270186815},
+		// ties go to the earliest hour
+		{"argmin", start.Add(time.Hour)},
+		{"argmax", start.Add(2 * time.Hour)},
+	} {
+		t.Run(tc.agg, func(t *testing.T) {
+			got, err := aggregate(tc.agg, prices)
+			if err != nil {
+				t.Fatalf("aggregate failed: %v", err)
+			}
+			switch want := tc.want.(type) {
+			case float64:
+				if math.Abs(got.(float64)-want) > 1e-9 {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			case time.Time:
+				if !got.(time.Time).Equal(want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			default:
+				if got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			}
+		})
+	}
+	for _, agg := range []string{"mode", "p101", "p-1", "pxx"} {
+		if _, err := aggregate(agg, prices); err == nil {
+			t.Errorf("%s: want an error", agg)
+		}
+	}
+}
+
+func TestGroupStart(t *testing.T) {
+	// a Wednesday
+	t0 := time.Date(2024, time.January, 17, 15, 30, 0, 0, market.Location)
+	for _, tc := range []struct {
+		group string
+		want  string
+	}{
+		{"day", "2024-01-17"},
+		{"week", "2024-01-15"},
+		{"month", "2024-01-01"},
+	} {
+		if got := groupStart(tc.group, t0).Format("2006-01-02 15:04"); got != tc.want+" 00:00" {
+			t.Errorf("%s: got %s, want %s 00:00", tc.group, got, tc.want)
+		}
+	}
+	// a Sunday belongs to the week started on the previous Monday
+	if got := groupStart("week", time.Date(2024, time.January, 21, 12, 0, 0, 0, market.Location)).Format("2006-01-02"); got != "2024-01-15" {
+		t.Errorf("week of a Sunday: got %s, want 2024-01-15", got)
+	}
+	if got := groupStart("", t0); !got.IsZero() {
+		t.Errorf("no grouping: got %s, want the zero time", got)
+	}
+}
+
+func TestStatsHandlerQuarterHours(t *testing.T) {
+	cache := NewCache(0)
+	hourly := time.Date(2025, time.September, 30, 0, 0, 0, 0, market.Location)
+	cache.Put(dayKey(hourly), hourly, hourly, []PUNXML{{Prezzi: dayRows("20250930", 24)}})
+	// quarter-hour q is priced q+10, so hour h averages 4h+12.5
+	quarterly := hourly.AddDate(0, 0, 1)
+	var rows []Prezzi
+	for q := 1; q <= 96; q++ {
+		rows = append(rows, Prezzi{Data: "20251001", Ora: (q-1)/4 + 1, Periodo: q, Prices: map[string]Price{"PUN": Price(q + 10)}})
+	}
+	cache.Put(dayKey(quarterly), quarterly, quarterly, []PUNXML{{Prezzi: rows}})
+	h := makeStatsHandler(NewDayStore(cache, nil, PublishSchedule{Hour: 13}))
+
+	for _, tc := range []struct {
+		group string
+		want  []map[string]interface{}
+	}{
+		{
+			// both days weigh the same, with 24 hours each
+			group: "none",
+			want:  []map[string]interface{}{{"count": 48.0, "avg": 35.5, "argmax": quarterly.Add(23 * time.Hour)}},
+		},
+		{
+			group: "day",
+			want: []map[string]interface{}{
+				{"count": 24.0, "avg": 12.5, "argmax": hourly.Add(23 * time.Hour)},
+				{"count": 24.0, "avg": 58.5, "argmax": quarterly.Add(23 * time.Hour)},
+			},
+		},
+	} {
+		t.Run(tc.group, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest("GET", "/stats?from=2025-09-30&to=2025-10-01&agg=count,avg,argmax&group="+tc.group, nil))
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("got %d %s: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+			}
+			var resp StatsResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+			}
+			if len(resp.Groups) != len(tc.want) {
+				t.Fatalf("got %d groups, want %d", len(resp.Groups), len(tc.want))
+			}
+			for idx, want := range tc.want {
+				got := resp.Groups[idx].Stats
+				if got["count"] != want["count"] || math.Abs(got["avg"].(float64)-want["avg"].(float64)) > 1e-9 {
+					t.Errorf("group %d: got %v, want %v", idx, got, want)
+				}
+				argmax, err := time.Parse(time.RFC3339, got["argmax"].(string))
+				if err != nil || !argmax.Equal(want["argmax"].(time.Time)) {
+					t.Errorf("group %d: got argmax %v, want %v", idx, got["argmax"], want["argmax"])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+)
+
+// ZoneKind classifies a price column of the MGP prices XML.
+type ZoneKind string
+
+const (
+	// ZoneKindNational is a national price, e.g. the PUN itself.
+	ZoneKindNational ZoneKind = "national"
+	// ZoneKindDomestic is one of the Italian bidding zones.
+	ZoneKindDomestic ZoneKind = "domestic"
+	// ZoneKindForeign is a foreign virtual zone or a coupled market.
+	ZoneKindForeign ZoneKind = "foreign"
+	// ZoneKindPole is a limited production pole.
+	ZoneKindPole ZoneKind = "pole"
+	// ZoneKindUnknown is a column that is not listed in knownZones, likely
+	// something that GME added after this list was last updated.
+	ZoneKindUnknown ZoneKind = "unknown"
+)
+
+// Zone holds the metadata of a price column.
+type Zone struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Kind        ZoneKind `json:"kind"`
+}
+
+var knownZones = map[string]Zone{
+	"PUN": {Name: "PUN", Description: "Prezzo Unico Nazionale", Kind: ZoneKindNational},
+	"NAT": {Name: "NAT", Description: "Nazionale", Kind: ZoneKindNational},
+
+	"NORD": {Name: "NORD", Description: "Nord", Kind: ZoneKindDomestic},
+	"CNOR": {Name: "CNOR", Description: "Centro Nord", Kind: ZoneKindDomestic},
+	"CSUD": {Name: "CSUD", Description: "Centro Sud", Kind: ZoneKindDomestic},
+	"SUD":  {Name: "SUD", Description: "Sud", Kind: ZoneKindDomestic},
+	"CALA": {Name: "CALA", Description: "Calabria", Kind: ZoneKindDomestic},
+	"SICI": {Name: "SICI", Description: "Sicilia", Kind: ZoneKindDomestic},
+	"SARD": {Name: "SARD", Description: "Sardegna", Kind: ZoneKindDomestic},
+
+	"AUST": {Name: "AUST", Description: "Austria", Kind: ZoneKindForeign},
+	"COAC": {Name: "COAC", Description: "Corsica AC", Kind: ZoneKindForeign},
+	"CORS": {Name: "CORS", Description: "Corsica", Kind: ZoneKindForeign},
+	"COUP": {Name: "COUP", Description: "Coupling", Kind: ZoneKindForeign},
+	"FRAN": {Name: "FRAN", Description: "Francia", Kind: ZoneKindForeign},
+	"GREC": {Name: "GREC", Description: "Grecia", Kind: ZoneKindForeign},
+	"SLOV": {Name: "SLOV", Description: "Slovenia", Kind: ZoneKindForeign},
+	"SVIZ": {Name: "SVIZ", Description: "Svizzera", Kind: ZoneKindForeign},
+	"BSP":  {Name: "BSP", Description: "Slovenia (BSP coupling)", Kind: ZoneKindForeign},
+	"MALT": {Name: "MALT", Description: "Malta", Kind: ZoneKindForeign},
+	"MONT": {Name: "MONT", Description: "Montenegro", Kind: ZoneKindForeign},
+	"XAUS": {Name: "XAUS", Description: "Austria (coupling)", Kind: ZoneKindForeign},
+	"XFRA": {Name: "XFRA", Description: "Francia (coupling)", Kind: ZoneKindForeign},
+	"XGRE": {Name: "XGRE", Description: "Grecia (coupling)", Kind: ZoneKindForeign},
+
+	"BRNN": {Name: "BRNN", Description: "Brindisi", Kind: ZoneKindPole},
+	"FOGN": {Name: "FOGN", Description: "Foggia", Kind: ZoneKindPole},
+	"MFTV": {Name: "MFTV", Description: "Monfalcone", Kind: ZoneKindPole},
+	"PRGP": {Name: "PRGP", Description: "Priolo Gargallo", Kind: ZoneKindPole},
+	"ROSN": {Name: "ROSN", Description: "Rossano", Kind: ZoneKindPole},
+}
+
+// LookupZone returns the metadata for the given column. Columns that are not
+// known are returned with ZoneKindUnknown rather than dropped.
+func LookupZone(name string) Zone {
+	if z, ok := knownZones[name]; ok {
+		return z
+	}
+	return Zone{Name: name, Kind: ZoneKindUnknown}
+}
+
+// SortedColumns returns the columns of a price row in a stable order.
+func SortedColumns(prices map[string]Price) []string {
+	columns := make([]string, 0, len(prices))
+	for c := range prices {
+		columns = append(columns, c)
+	}
+	sort.Strings(columns)
+	return columns
+}